@@ -0,0 +1,222 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"site/config"
+	"site/pkg/database"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// errorColumnWidth keeps the listing to a line per message
+	errorColumnWidth = 60
+	tabPadding       = 2
+)
+
+// DeadLetterCommand is a struct to enclose the management of messages that failed processing
+type DeadLetterCommand struct {
+	ConfigurationFile string `short:"c" help:"Defines the non-default configuration file to use."`
+
+	List   DeadLetterListCommand   `cmd:"" help:"List the messages that failed processing"`
+	Show   DeadLetterShowCommand   `cmd:"" help:"Print a message that failed processing along with its payload"`
+	Replay DeadLetterReplayCommand `cmd:"" help:"Publish messages to the broker again so the running site reprocesses them"`
+	Purge  DeadLetterPurgeCommand  `cmd:"" help:"Remove messages that failed processing"`
+}
+
+// DeadLetterListCommand lists the dead letters
+type DeadLetterListCommand struct {
+	Topic string `short:"t" help:"Only list the messages received on this topic"`
+}
+
+// DeadLetterShowCommand prints a single dead letter
+type DeadLetterShowCommand struct {
+	ID  int  `arg:"" help:"Id of the message"`
+	Raw bool `short:"r" help:"Write only the payload, e.g. to redirect it to a file"`
+}
+
+// DeadLetterReplayCommand republishes dead letters
+type DeadLetterReplayCommand struct {
+	IDs []int `arg:"" optional:"" name:"id" help:"Ids of the messages to replay"`
+	All bool  `short:"a" help:"Replay every message"`
+}
+
+// DeadLetterPurgeCommand removes dead letters
+type DeadLetterPurgeCommand struct {
+	IDs       []int         `arg:"" optional:"" name:"id" help:"Ids of the messages to remove"`
+	All       bool          `short:"a" help:"Remove every message"`
+	OlderThan time.Duration `short:"o" help:"Only remove messages that last failed longer ago than this"`
+}
+
+// selectDeadLetters loads the dead letters by id, or every one of them with all
+func selectDeadLetters(ctx context.Context, db *sqlx.DB, ids []int, all bool) ([]database.DeadLetterObject, error) {
+	switch {
+	case all && len(ids) > 0:
+		return nil, errors.New("either give ids or --all, not both")
+	case all:
+		return (&database.DeadLetterObject{}).Query(ctx, db, nil)
+	case len(ids) == 0:
+		return nil, errors.New("no messages selected, give their ids or --all")
+	}
+
+	letters := make([]database.DeadLetterObject, 0, len(ids))
+	for _, id := range ids {
+		letter := database.DeadLetterObject{ID: id}
+		err := letter.Load(ctx, db)
+		if err == database.ErrNotFound {
+			return nil, fmt.Errorf("no dead letter %d", id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// Run is the method that is executed when the deadletter list command is selected
+func (cmd *DeadLetterListCommand) Run(parent *DeadLetterCommand) error {
+	siteConfig := config.NewSiteConfiguration(parent.ConfigurationFile, true)
+	defer siteConfig.Database.Close()
+
+	var criteria map[string]string
+	if len(cmd.Topic) > 0 {
+		criteria = map[string]string{"topic": cmd.Topic}
+	}
+
+	letters, err := (&database.DeadLetterObject{}).Query(context.Background(), siteConfig.Database, criteria)
+	if err != nil {
+		return err
+	}
+
+	output := tabwriter.NewWriter(os.Stdout, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintln(output, "ID\tTOPIC\tSIZE\tATTEMPTS\tLAST ATTEMPT\tERROR")
+	for _, letter := range letters {
+		reason := letter.Error
+		if len(reason) > errorColumnWidth {
+			reason = reason[:errorColumnWidth] + "..."
+		}
+		fmt.Fprintf(output, "%d\t%s\t%d\t%d\t%s\t%s\n", letter.ID, letter.Topic, len(letter.Payload),
+			letter.Attempts, letter.LastAttempt.Format(time.RFC3339), reason)
+	}
+
+	return output.Flush()
+}
+
+// Run is the method that is executed when the deadletter show command is selected
+func (cmd *DeadLetterShowCommand) Run(parent *DeadLetterCommand) error {
+	siteConfig := config.NewSiteConfiguration(parent.ConfigurationFile, true)
+	defer siteConfig.Database.Close()
+
+	letters, err := selectDeadLetters(context.Background(), siteConfig.Database, []int{cmd.ID}, false)
+	if err != nil {
+		return err
+	}
+	letter := letters[0]
+
+	if cmd.Raw {
+		_, err = os.Stdout.Write(letter.Payload)
+		return err
+	}
+
+	fmt.Printf("id:           %d\n", letter.ID)
+	fmt.Printf("topic:        %s\n", letter.Topic)
+	fmt.Printf("received:     %s\n", letter.Created.Format(time.RFC3339))
+	fmt.Printf("last attempt: %s\n", letter.LastAttempt.Format(time.RFC3339))
+	fmt.Printf("attempts:     %d\n", letter.Attempts)
+	fmt.Printf("error:        %s\n", letter.Error)
+	fmt.Printf("payload:      %d bytes\n", len(letter.Payload))
+
+	if utf8.Valid(letter.Payload) {
+		fmt.Println(string(letter.Payload))
+	} else {
+		fmt.Print(hex.Dump(letter.Payload))
+	}
+
+	return nil
+}
+
+// Run is the method that is executed when the deadletter replay command is selected
+func (cmd *DeadLetterReplayCommand) Run(parent *DeadLetterCommand) error {
+	siteConfig := config.NewSiteConfiguration(parent.ConfigurationFile, true)
+	defer siteConfig.Database.Close()
+
+	ctx := context.Background()
+	letters, err := selectDeadLetters(ctx, siteConfig.Database, cmd.IDs, cmd.All)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+
+	opts, err := newClientOptions(siteConfig.ClientID+"-deadletter-"+strconv.Itoa(os.Getpid()), credentials)
+	if err != nil {
+		return err
+	}
+
+	client := MQTT.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("unable to connect to %s: %v", brokerURL(), token.Error())
+	}
+	defer client.Disconnect(mqttWait)
+
+	replayed := 0
+	for index := range letters {
+		letter := &letters[index]
+
+		// the message is only removed once the broker has it, should it fail again it is kept anew
+		if token := client.Publish(letter.Topic, publishQoS, false, letter.Payload); token.Wait() && token.Error() != nil {
+			return fmt.Errorf("failed to replay %d after replaying %d: %v", letter.ID, replayed, token.Error())
+		}
+
+		if err = letter.Remove(ctx, siteConfig.Database); err != nil {
+			logrus.Errorf("replayed %d but failed to remove it, it may be replayed twice: %v", letter.ID, err)
+		}
+		replayed++
+	}
+
+	fmt.Printf("replayed %d messages\n", replayed)
+	return nil
+}
+
+// Run is the method that is executed when the deadletter purge command is selected
+func (cmd *DeadLetterPurgeCommand) Run(parent *DeadLetterCommand) error {
+	siteConfig := config.NewSiteConfiguration(parent.ConfigurationFile, true)
+	defer siteConfig.Database.Close()
+
+	ctx := context.Background()
+	letters, err := selectDeadLetters(ctx, siteConfig.Database, cmd.IDs, cmd.All)
+	if err != nil {
+		return err
+	}
+
+	purged := 0
+	for index := range letters {
+		letter := &letters[index]
+		if cmd.OlderThan > 0 && time.Since(letter.LastAttempt) < cmd.OlderThan {
+			continue
+		}
+
+		if err = letter.Remove(ctx, siteConfig.Database); err != nil {
+			return fmt.Errorf("failed to remove %d after removing %d: %v", letter.ID, purged, err)
+		}
+		purged++
+	}
+
+	fmt.Printf("purged %d messages\n", purged)
+	return nil
+}
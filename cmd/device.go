@@ -0,0 +1,112 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"site/config"
+	"site/pkg/commands"
+	"site/pkg/topics"
+	"strconv"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// DeviceCommand is a struct to enclose all device management sub commands
+type DeviceCommand struct {
+	ConfigurationFile string `short:"c" help:"Defines the non-default configuration file to use."`
+
+	Cmd DeviceCmdCommand `cmd:"" help:"Send a command to a device and wait for it to be acknowledged"`
+}
+
+// DeviceCmdCommand sends a single command to a device
+type DeviceCmdCommand struct {
+	Serial  string        `arg:"" help:"Serial of the device to command"`
+	Type    string        `arg:"" enum:"capture,reboot,apply_settings,start_video,stop_video" help:"One of capture, reboot, apply_settings, start_video or stop_video"`
+	Args    string        `short:"a" help:"JSON arguments for the command, the settings object for apply_settings"`
+	Timeout time.Duration `short:"t" help:"How long to wait for the acknowledgement, defaults to commands.timeout"`
+}
+
+// connectCommandClient connects to the broker with a client id of its own so a running
+// site is not disconnected, handing acknowledgements from the device to the dispatcher
+func connectCommandClient(clientID, serial string, dispatcher *commands.Dispatcher) (MQTT.Client, error) {
+	credentials, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := newClientOptions(clientID, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	client := MQTT.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %v", brokerURL(), token.Error())
+	}
+
+	ackTopic := topics.TopicPrefix + "/" + topics.AckTopic + "/" + serial
+	token := client.Subscribe(ackTopic, 1, func(client MQTT.Client, msg MQTT.Message) {
+		if err := dispatcher.Acknowledge(serial, msg.Payload()); err != nil {
+			logrus.Warn(err)
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		client.Disconnect(mqttWait)
+		return nil, fmt.Errorf("unable to subscribe to %s: %v", ackTopic, token.Error())
+	}
+
+	return client, nil
+}
+
+// Run is the method that is executed when the device cmd command is selected
+func (cmd *DeviceCmdCommand) Run(parent *DeviceCommand) error {
+	siteConfig := config.NewSiteConfiguration(parent.ConfigurationFile, true)
+	_ = siteConfig.Database.Close()
+
+	timeout := cmd.Timeout
+	if timeout <= 0 {
+		timeout = viper.GetDuration(config.CommandTimeout)
+	}
+
+	var client MQTT.Client
+	dispatcher := commands.NewDispatcher(func(topic string, payload []byte) {
+		if token := client.Publish(topic, publishQoS, false, payload); token.Wait() && token.Error() != nil {
+			logrus.Errorf("failed publishing command: %v", token.Error())
+		}
+	}, timeout)
+
+	clientID := siteConfig.ClientID + "-cmd-" + strconv.Itoa(os.Getpid())
+	client, err := connectCommandClient(clientID, cmd.Serial, dispatcher)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(mqttWait)
+
+	var args json.RawMessage
+	if len(cmd.Args) > 0 {
+		args = json.RawMessage(cmd.Args)
+	}
+
+	command, ack, err := dispatcher.Send(context.Background(), cmd.Serial, cmd.Type, args)
+	if err != nil {
+		return err
+	}
+
+	result, err := json.MarshalIndent(ack, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("command %s acknowledged:\n%s\n", command.ID, result)
+	if ack.Status != commands.StatusOK {
+		return fmt.Errorf("device reported %s for %s", ack.Status, cmd.Type)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"site/config"
+	"site/pkg/database"
+	"site/pkg/storage"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// FsckCommand is a struct to enclose the cache consistency check
+type FsckCommand struct {
+	ConfigurationFile string        `short:"c" help:"Defines the non-default configuration file to use."`
+	Repair            bool          `short:"r" help:"Remove orphaned rows and files instead of only reporting them."`
+	Grace             time.Duration `default:"1h" help:"Leave files changed more recently than this alone, the site may still be ingesting them."`
+}
+
+// fsckReport tallies what was found during a check
+type fsckReport struct {
+	orphanRows  int
+	orphanFiles int
+	partial     int
+	recent      int
+}
+
+// trackedFile is a row in the database that refers to a file in the cache
+type trackedFile struct {
+	kind   string
+	id     int
+	path   string
+	remove func(ctx context.Context, database database.Executor) error
+}
+
+// trackedFiles returns every image, video and audio row along with the file it refers to
+func trackedFiles(ctx context.Context, db database.Executor) ([]trackedFile, error) {
+	images, err := (&database.ImageObject{}).Query(ctx, db, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	videos, err := (&database.VideoObject{}).Query(ctx, db, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	clips, err := (&database.AudioObject{}).Query(ctx, db, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make([]trackedFile, 0, len(images)+len(videos)+len(clips))
+	for index := range images {
+		image := &images[index]
+		tracked = append(tracked, trackedFile{kind: "image", id: image.ID, path: image.Path, remove: image.Remove})
+	}
+
+	for index := range videos {
+		video := &videos[index]
+		tracked = append(tracked, trackedFile{kind: "video", id: video.ID, path: video.Path, remove: video.Remove})
+	}
+
+	for index := range clips {
+		clip := &clips[index]
+		tracked = append(tracked, trackedFile{kind: "audio", id: clip.ID, path: clip.Path, remove: clip.Remove})
+	}
+
+	return tracked, nil
+}
+
+// checkRows reports rows whose file is missing, returning every path that is accounted for
+func (cmd *FsckCommand) checkRows(ctx context.Context, siteConfig *config.SiteConfiguration, report *fsckReport) (map[string]bool, error) {
+	tracked, err := trackedFiles(ctx, siteConfig.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(tracked))
+	for _, row := range tracked {
+		path := filepath.Clean(row.path)
+		known[path] = true
+
+		if _, err = os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+
+		report.orphanRows++
+		fmt.Printf("missing file for %s %d: %s\n", row.kind, row.id, path)
+
+		if cmd.Repair {
+			if err = row.remove(ctx, siteConfig.Database); err != nil {
+				logrus.Errorf("failed to remove %s %d: %v", row.kind, row.id, err)
+			}
+		}
+	}
+
+	return known, nil
+}
+
+// checkFiles reports files in the cache that have no row, and any left over partial ingestions
+func (cmd *FsckCommand) checkFiles(cacheStorage string, known map[string]bool, report *fsckReport) error {
+	return filepath.Walk(cacheStorage, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		path = filepath.Clean(path)
+		switch {
+		case (strings.HasPrefix(info.Name(), storage.TemporaryPrefix) || !known[path]) && time.Since(info.ModTime()) < cmd.Grace:
+			// a running site writes the temporary file and links it in place before committing the row
+			report.recent++
+			return nil
+		case strings.HasPrefix(info.Name(), storage.TemporaryPrefix):
+			report.partial++
+			fmt.Printf("partial ingestion: %s\n", path)
+		case !known[path]:
+			report.orphanFiles++
+			fmt.Printf("file without a row: %s\n", path)
+		default:
+			return nil
+		}
+
+		if cmd.Repair {
+			if err = os.Remove(path); err != nil {
+				logrus.Errorf("failed to remove %s: %v", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Run is the method that is executed when the fsck command is selected
+func (cmd *FsckCommand) Run() error {
+	siteConfig := config.NewSiteConfiguration(cmd.ConfigurationFile, true)
+	defer siteConfig.Database.Close()
+
+	cacheStorage := viper.GetString(config.WebServerCache)
+	if len(cacheStorage) == 0 {
+		return fmt.Errorf("no cache directory configured: %s", config.WebServerCache)
+	}
+
+	ctx := context.Background()
+	report := fsckReport{}
+
+	known, err := cmd.checkRows(ctx, siteConfig, &report)
+	if err != nil {
+		return err
+	}
+
+	err = cmd.checkFiles(filepath.Clean(cacheStorage), known, &report)
+	if err != nil {
+		return err
+	}
+
+	action := "found"
+	if cmd.Repair {
+		action = "repaired"
+	}
+	fmt.Printf("%s %d rows without files, %d files without rows, %d partial ingestions, skipped %d recent files\n",
+		action, report.orphanRows, report.orphanFiles, report.partial, report.recent)
+
+	return nil
+}
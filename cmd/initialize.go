@@ -2,13 +2,9 @@
 package cmd
 
 import (
-	"io/ioutil"
-	"path/filepath"
 	"site/config"
-	"strconv"
-	"strings"
+	"site/pkg/database"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -16,39 +12,9 @@ import (
 // InitializeCommand is a struct to enclose all initialization related sub commands if any
 type InitializeCommand struct {
 	ConfigurationFile string `short:"c" help:"Defines the non-default configuration file to use."`
-	DatabaseFile      string `short:"d" help:"Defines the database file to initialize with."`
 }
 
-func createDatabase(sqlFile string, database *sqlx.DB) error {
-	file, err := ioutil.ReadFile(filepath.Clean(sqlFile))
-	if err != nil {
-		return err
-	}
-
-	// Convert to strings
-	sqlStatements := strings.Split(string(file), "\n")
-	fullStatement := ""
-	for _, statement := range sqlStatements {
-		if len(statement) > 0 && !strings.Contains(statement, "--") && !strings.Contains(statement, "/*") {
-			if statement[len(statement)-1] == '\n' {
-				statement = statement[:len(statement)-1]
-			}
-			fullStatement += statement
-			if strings.Contains(fullStatement, ";") {
-				logrus.Infof("query: %s", fullStatement)
-				_, err = database.Exec(fullStatement)
-				if err != nil {
-					logrus.Errorf("query failed on initialize: %v", err)
-					return err
-				}
-				fullStatement = ""
-			}
-		}
-	}
-	return nil
-}
-
-// Run is the method that is executed when the version command is selected
+// Run is the method that is executed when the initialize command is selected
 func (cmd *InitializeCommand) Run() error {
 	logrus.Info("Initializing system for first usage")
 
@@ -56,17 +22,15 @@ func (cmd *InitializeCommand) Run() error {
 	// Will use passed in configuration file if any
 	siteConfig := config.NewSiteConfiguration(cmd.ConfigurationFile, false)
 
-	targetDatabaseName := viper.GetString(config.DatabaseName)
-
-	_, err := siteConfig.Database.Query("drop database " + targetDatabaseName)
+	dialect, err := database.LookupDialect(viper.GetString(config.DatabaseType))
 	if err != nil {
-		logrus.Warnf("failed to drop database: %v", err)
+		return err
 	}
 
-	// Create the new database
-	_, err = siteConfig.Database.Query("create database " + targetDatabaseName)
+	// Create the database if it isn't already there
+	err = dialect.CreateDatabase(siteConfig.Database, viper.GetString(config.DatabaseName))
 	if err != nil {
-		logrus.Errorf("failed to create new database: %v", err)
+		logrus.Errorf("failed to create database: %v", err)
 		return err
 	}
 
@@ -76,19 +40,13 @@ func (cmd *InitializeCommand) Run() error {
 		logrus.Warnf("unable to close the database connection: %v", err)
 	}
 
-	connectionString := viper.GetString(config.DatabaseUser) + ":" + viper.GetString(config.DatabasePassword)
-	connectionString += "@tcp(" + viper.GetString(config.DatabaseHost) + ":" + strconv.Itoa(viper.GetInt(config.DatabasePort)) + ")/"
-
-	siteConfig.Database, err = sqlx.Open(viper.GetString(config.DatabaseType), connectionString+targetDatabaseName)
-
+	siteConfig.Database, err = config.OpenDatabase(true)
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 
-	// Once database is dropped we can load the new one
-	err = createDatabase(cmd.DatabaseFile, siteConfig.Database)
-
-	_ = siteConfig.Database.Close()
+	defer siteConfig.Database.Close()
 
-	return err
+	// Bring the schema up to date without disturbing existing data
+	return database.MigrateUp(siteConfig.Database)
 }
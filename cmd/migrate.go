@@ -0,0 +1,89 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"fmt"
+	"site/config"
+	"site/pkg/database"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MigrateCommand is a struct to enclose all schema migration sub commands
+type MigrateCommand struct {
+	ConfigurationFile string `short:"c" help:"Defines the non-default configuration file to use."`
+
+	Up     MigrateUpCommand     `cmd:"" help:"Apply all pending migrations, run it again once the cause of a failure is fixed"`
+	Down   MigrateDownCommand   `cmd:"" help:"Revert the most recently applied migration"`
+	Status MigrateStatusCommand `cmd:"" help:"List migrations and whether they have been applied"`
+	To     MigrateToCommand     `cmd:"" help:"Apply or revert migrations to reach a specific version"`
+}
+
+// MigrateUpCommand applies all pending migrations
+type MigrateUpCommand struct {
+}
+
+// MigrateDownCommand reverts the most recent migration
+type MigrateDownCommand struct {
+}
+
+// MigrateStatusCommand reports the state of each migration
+type MigrateStatusCommand struct {
+}
+
+// MigrateToCommand migrates to a specific version
+type MigrateToCommand struct {
+	Version int `arg:"" help:"The schema version to migrate to, 0 reverts everything"`
+}
+
+func (cmd *MigrateCommand) withDatabase(action func(siteConfig *config.SiteConfiguration) error) error {
+	siteConfig := config.NewSiteConfiguration(cmd.ConfigurationFile, true)
+
+	err := action(siteConfig)
+
+	_ = siteConfig.Database.Close()
+
+	return err
+}
+
+// Run is the method that is executed when the migrate up command is selected
+func (cmd *MigrateUpCommand) Run(parent *MigrateCommand) error {
+	return parent.withDatabase(func(siteConfig *config.SiteConfiguration) error {
+		return database.MigrateUp(siteConfig.Database)
+	})
+}
+
+// Run is the method that is executed when the migrate down command is selected
+func (cmd *MigrateDownCommand) Run(parent *MigrateCommand) error {
+	return parent.withDatabase(func(siteConfig *config.SiteConfiguration) error {
+		return database.MigrateDown(siteConfig.Database)
+	})
+}
+
+// Run is the method that is executed when the migrate to command is selected
+func (cmd *MigrateToCommand) Run(parent *MigrateCommand) error {
+	return parent.withDatabase(func(siteConfig *config.SiteConfiguration) error {
+		return database.MigrateTo(siteConfig.Database, cmd.Version)
+	})
+}
+
+// Run is the method that is executed when the migrate status command is selected
+func (cmd *MigrateStatusCommand) Run(parent *MigrateCommand) error {
+	return parent.withDatabase(func(siteConfig *config.SiteConfiguration) error {
+		states, err := database.MigrationStatus(siteConfig.Database)
+		if err != nil {
+			return err
+		}
+
+		for _, state := range states {
+			applied := "pending"
+			if state.Applied {
+				applied = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-40s %s\n", state.Version, state.Description, applied)
+		}
+
+		logrus.Infof("latest migration: %d", database.LatestMigration())
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"site/config"
+	"site/pkg/certs"
+	"site/pkg/health"
+	"site/pkg/topics"
+	"strconv"
+	"syscall"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// brokerURL returns the address of the configured broker
+func brokerURL() string {
+	prefix := "ssl://"
+	if !viper.GetBool(config.BrokerSSL) {
+		// configure for tcp
+		prefix = "tcp://"
+	}
+
+	return prefix + viper.GetString(config.BrokerAddress) + ":" + strconv.Itoa(viper.GetInt(config.BrokerPort))
+}
+
+// loadCredentials reads the configured broker certificates, there are none without ssl
+func loadCredentials() (*certs.Credentials, error) {
+	if !viper.GetBool(config.BrokerSSL) {
+		return nil, nil
+	}
+
+	credentials, err := certs.Load(certs.Paths{
+		CA:          viper.GetString(config.BrokerCAPath),
+		Certificate: viper.GetString(config.BrokerPublicKeyPath),
+		Key:         viper.GetString(config.BrokerPrivateKeyPath),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s is enabled but the certificates cannot be used: %v", config.BrokerSSL, err)
+	}
+	return credentials, nil
+}
+
+// newClientOptions returns the options to connect to the configured broker as the client id,
+// every client connected at the same time needs its own id or the broker disconnects the other
+func newClientOptions(clientID string, credentials *certs.Credentials) (*MQTT.ClientOptions, error) {
+	opts := MQTT.NewClientOptions()
+	opts.AddBroker(brokerURL())
+	opts.SetClientID(clientID)
+	opts.SetCleanSession(true)
+
+	if credentials != nil {
+		minVersion, err := certs.ParseVersion(viper.GetString(config.BrokerTLSVersion))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", config.BrokerTLSVersion, err)
+		}
+
+		serverName := viper.GetString(config.BrokerServerName)
+		if len(serverName) == 0 {
+			serverName = viper.GetString(config.BrokerAddress)
+		}
+		opts.SetTLSConfig(credentials.Config(serverName, minVersion))
+	}
+
+	return opts, nil
+}
+
+// configureSession keeps the site connected once it has reached the broker, reconnecting with
+// an increasing delay and subscribing again on every connect. with a store directory configured
+// the session persists so qos 1 messages are neither lost on the broker nor in flight on restart
+func configureSession(opts *MQTT.ClientOptions, monitor *health.Monitor) {
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(reconnectLimit())
+
+	if store := viper.GetString(config.BrokerStore); len(store) > 0 {
+		opts.SetStore(MQTT.NewFileStore(store))
+		opts.SetCleanSession(false)
+	}
+
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		health.BrokerConnects.Add(1)
+
+		if !subscribeWithBackoff(client, monitor) {
+			return
+		}
+
+		logrus.Infof("connected to broker %s", brokerURL())
+		monitor.SetBroker(health.Connected, nil)
+	})
+
+	opts.SetConnectionLostHandler(func(client MQTT.Client, err error) {
+		health.BrokerConnectionLost.Add(1)
+		logrus.Errorf("lost connection to broker %s, reconnecting: %v", brokerURL(), err)
+		monitor.SetBroker(health.Connecting, err)
+	})
+}
+
+// subscribeWithBackoff subscribes to the device topics, without which nothing is received, doubling
+// the wait after each failure up to the configured limit. disconnecting would also stop the client
+// from reconnecting, so it only gives up, returning false, once the connection is lost and the
+// subscription is made again on the next connect
+func subscribeWithBackoff(client MQTT.Client, monitor *health.Monitor) bool {
+	delay := time.Second
+	for {
+		token := client.Subscribe(topics.TopicPrefix+"/#", 1, nil)
+		if token.Wait() && token.Error() == nil {
+			return true
+		}
+
+		logrus.Errorf("failed to subscribe to device topics, retrying in %v: %v", delay, token.Error())
+		monitor.SetBroker(health.Connecting, token.Error())
+
+		time.Sleep(delay)
+		if !client.IsConnectionOpen() {
+			return false
+		}
+
+		delay *= 2
+		if limit := reconnectLimit(); delay > limit {
+			delay = limit
+		}
+	}
+}
+
+// reconnectLimit is the longest wait between attempts to reach the broker
+func reconnectLimit() time.Duration {
+	limit := viper.GetDuration(config.BrokerReconnectMax)
+	if limit < time.Second {
+		limit = time.Second
+	}
+	return limit
+}
+
+// connectWithBackoff tries to reach the broker until it succeeds, doubling the wait after each
+// failure up to the configured limit. it only gives up, returning false, once quit is closed
+func connectWithBackoff(client MQTT.Client, monitor *health.Monitor, quit <-chan struct{}) bool {
+	delay := time.Second
+	for {
+		token := client.Connect()
+		if token.Wait() && token.Error() == nil {
+			return true
+		}
+
+		health.BrokerConnectFailed.Add(1)
+		monitor.SetBroker(health.Connecting, token.Error())
+		logrus.Warnf("unable to connect to broker %s, retrying in %v: %v", brokerURL(), delay, token.Error())
+
+		select {
+		case <-time.After(delay):
+		case <-quit:
+			return false
+		}
+
+		delay *= 2
+		if limit := reconnectLimit(); delay > limit {
+			delay = limit
+		}
+	}
+}
+
+// reloadCredentials reads the broker certificates again whenever the process receives SIGHUP,
+// connections made from then on use them
+func reloadCredentials(ctx context.Context, credentials *certs.Credentials) {
+	if credentials == nil {
+		return
+	}
+
+	onReload := make(chan os.Signal, 1)
+	signal.Notify(onReload, syscall.SIGHUP)
+	defer signal.Stop(onReload)
+
+	for {
+		select {
+		case <-onReload:
+			if err := credentials.Reload(); err != nil {
+				logrus.Errorf("failed to reload broker certificates, keeping the previous ones: %v", err)
+				continue
+			}
+			logrus.Info("reloaded broker certificates")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
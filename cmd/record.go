@@ -0,0 +1,89 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"site/config"
+	"site/pkg/topics"
+	"strconv"
+	"syscall"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingOptions keeps recordings, which may hold images, private to the user
+const recordingOptions = 0600
+
+// RecordCommand is a struct to enclose recording the traffic on the broker
+type RecordCommand struct {
+	ConfigurationFile string        `short:"c" help:"Defines the non-default configuration file to use."`
+	Output            string        `short:"o" required:"" help:"File to write the recording to, it is replaced if it exists"`
+	Topic             string        `short:"t" default:"afm/v1/#" help:"Topic filter of the messages to record"`
+	Duration          time.Duration `short:"d" help:"Stop after this long instead of on interrupt"`
+}
+
+// Run is the method that is executed when the record command is selected
+func (cmd *RecordCommand) Run() error {
+	siteConfig := config.NewSiteConfiguration(cmd.ConfigurationFile, true)
+	_ = siteConfig.Database.Close()
+
+	output, err := os.OpenFile(filepath.Clean(cmd.Output), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, recordingOptions)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	credentials, err := loadCredentials()
+	if err != nil {
+		return err
+	}
+
+	opts, err := newClientOptions(siteConfig.ClientID+"-record-"+strconv.Itoa(os.Getpid()), credentials)
+	if err != nil {
+		return err
+	}
+
+	client := MQTT.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("unable to connect to %s: %v", brokerURL(), token.Error())
+	}
+	defer client.Disconnect(mqttWait)
+
+	recorder := topics.NewRecorder(output)
+	token := client.Subscribe(cmd.Topic, 1, func(client MQTT.Client, msg MQTT.Message) {
+		message := topics.Message{Topic: msg.Topic(), Payload: msg.Payload(), QoS: msg.Qos(),
+			Retained: msg.Retained(), Received: time.Now(), MessageID: msg.MessageID()}
+		if err := recorder.Record(&message); err != nil {
+			logrus.Errorf("failed to record %s: %v", &message, err)
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("unable to subscribe to %s: %v", cmd.Topic, token.Error())
+	}
+
+	logrus.Infof("recording %s to %s", cmd.Topic, cmd.Output)
+
+	onQuit := make(chan os.Signal, 1)
+	signal.Notify(onQuit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(onQuit)
+
+	var stop <-chan time.Time
+	if cmd.Duration > 0 {
+		stop = time.After(cmd.Duration)
+	}
+
+	select {
+	case <-onQuit:
+	case <-stop:
+	}
+
+	client.Unsubscribe(cmd.Topic).WaitTimeout(time.Second)
+	fmt.Printf("recorded %d messages to %s\n", recorder.Count(), cmd.Output)
+
+	return output.Sync()
+}
@@ -0,0 +1,79 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"site/config"
+	"site/pkg/topics"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReplayCommand is a struct to enclose feeding a recording through the message processing
+type ReplayCommand struct {
+	ConfigurationFile string  `short:"c" help:"Defines the non-default configuration file to use, point it at a test database."`
+	File              string  `arg:"" type:"existingfile" help:"Recording made with the record command"`
+	Speed             float64 `short:"s" default:"1" help:"How much faster than recorded to replay, 0 for as fast as possible"`
+}
+
+// Run is the method that is executed when the replay command is selected
+func (cmd *ReplayCommand) Run() error {
+	siteConfig := config.NewSiteConfiguration(cmd.ConfigurationFile, true)
+	defer siteConfig.Database.Close()
+
+	recording, err := os.Open(filepath.Clean(cmd.File))
+	if err != nil {
+		return err
+	}
+	defer recording.Close()
+
+	// the same processing as the run command, only without a broker to receive from or publish to
+	pipeline := &RunCommand{ConfigurationFile: cmd.ConfigurationFile}
+	if err = pipeline.preparePipeline(siteConfig); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go pipeline.reassembler.Run(ctx)
+	go logOutgoing(ctx, siteConfig)
+
+	onQuit := make(chan os.Signal, 1)
+	signal.Notify(onQuit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(onQuit)
+	go func() {
+		select {
+		case <-onQuit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// messages are handled one at a time in recorded order so a replay always plays out the same
+	count, err := topics.Replay(ctx, recording, cmd.Speed, func(message *topics.Message) error {
+		logrus.Infof("replaying %s", message)
+		pipeline.handleMQTTRequest(ctx, message)
+		return nil
+	})
+
+	fmt.Printf("replayed %d messages from %s\n", count, cmd.File)
+	return err
+}
+
+// logOutgoing reports what would have been published to the broker
+func logOutgoing(ctx context.Context, siteConfig *config.SiteConfiguration) {
+	for {
+		select {
+		case message := <-siteConfig.OutgoingMQTT:
+			logrus.Infof("would publish %s: %s", &message, message.Payload)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -3,15 +3,27 @@ package cmd
 
 import (
 	"context"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"site/config"
+	"site/pkg/certs"
+	"site/pkg/commands"
 	"site/pkg/database"
+	"site/pkg/events"
+	"site/pkg/health"
+	"site/pkg/ingest"
+	"site/pkg/presence"
+	"site/pkg/provision"
 	"site/pkg/server"
+	"site/pkg/settings"
+	"site/pkg/storage"
 	"site/pkg/topics"
 	"strconv"
 	"sync"
@@ -21,155 +33,614 @@ import (
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
-	"github.com/spf13/viper"
-
-	// Pulling in mysql driver
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 const (
-	mqttWait = 250
-	httpWait = 5 * time.Second
+	mqttWait    = 250
+	httpWait    = 5 * time.Second
+	retryDelay  = time.Second
+	publishWait = time.Second
+	publishQoS  = 1
+
+	// maxRetryDelay caps the wait between attempts at a request while the database is unavailable
+	maxRetryDelay = 30 * time.Second
+	// ingestWait is how long messages still queued on shutdown are processed for before the rest
+	// are kept as dead letters
+	ingestWait = 10 * time.Second
+
+	videoDirectory = "videos"
+	audioDirectory = "audio"
 )
 
 // RunCommand is a struct to enclose all run related sub commands if any
 type RunCommand struct {
 	ConfigurationFile string `short:"c" help:"Defines the non-default configuration file to use."`
+
+	router      *topics.Router
+	reassembler *topics.Reassembler
+	broadcaster *server.Broadcaster
+	events      *events.Bus
+	commands    *commands.Dispatcher
+	presence    *presence.Tracker
+	publish     topics.Publisher
+	// publishRetained is used for the desired settings so devices receive them on connecting
+	publishRetained topics.Publisher
+	schemas         *settings.Schemas
+	health          *health.Monitor
+	ingest          *ingest.Pool
+	database        *sqlx.DB
+}
+
+// pushDesired publishes the desired settings of a claimed device on its retained config topic,
+// only when they differ from what it reported unless force is set
+func (cmd *RunCommand) pushDesired(ctx context.Context, db *sqlx.DB, deviceObj *database.DeviceObject, reported map[string]string, force bool) error {
+	deviceUserMap := database.DeviceUserMappingObject{}
+	err := deviceUserMap.LoadByField(ctx, db, strconv.Itoa(deviceObj.ID))
+	if err != nil {
+		return err
+	}
+
+	desired, err := settings.Desired(ctx, db, deviceUserMap.ID)
+	if err != nil {
+		return err
+	}
+
+	changes := settings.Diff(desired, reported)
+	if len(desired) == 0 || (len(changes) == 0 && !force) {
+		return nil
+	}
+
+	logrus.Infof("sending %d changed settings to %s", len(changes), deviceObj.Serial)
+	payload, err := settings.Payload(cmd.schemas.For(deviceObj.Model), desired)
+	if err != nil {
+		return err
+	}
+
+	cmd.publishRetained(settings.DeviceConfigTopic(deviceObj.Serial), payload)
+	return nil
+}
+
+// register records the device and tells it whether it is waiting to be claimed,
+// a claimed device is sent its desired settings as it has just connected
+func (cmd *RunCommand) register(ctx context.Context, db *sqlx.DB, serial string, registration *provision.Registration) error {
+	deviceObj, err := provision.Register(ctx, db, serial, registration)
+	if err != nil {
+		return err
+	}
+
+	reply, err := provision.Reply(deviceObj)
+	if err != nil {
+		return err
+	}
+
+	cmd.publish(topics.TopicPrefix+"/"+topics.RegisteredTopic+"/"+serial, reply)
+
+	if deviceObj.State != database.DeviceClaimed {
+		return nil
+	}
+	return cmd.pushDesired(ctx, db, deviceObj, nil, true)
+}
+
+func (cmd *RunCommand) processRegistration(ctx context.Context, db *sqlx.DB, device topics.DeviceData) error {
+	registration := provision.Registration{}
+	if err := json.Unmarshal(device.GetData(), &registration); err != nil {
+		return fmt.Errorf("invalid registration from %s: %v", device.GetDeviceID(), err)
+	}
+
+	return cmd.register(ctx, db, device.GetDeviceID(), &registration)
 }
 
-func (cmd *RunCommand) processSettingsObject(db *sqlx.DB, device topics.DeviceData) error {
+// processSettingsObject records the settings the device reports using and sends
+// back the desired settings if they differ
+func (cmd *RunCommand) processSettingsObject(ctx context.Context, db *sqlx.DB, device topics.DeviceData) error {
 	// look up device by id (serial)
 	deviceObj := database.DeviceObject{}
 
-	// test out a database seting
-	err := deviceObj.LoadByField(db, device.GetDeviceID())
-	if err == nil {
-		logrus.Infof("retrieved setting: %v", deviceObj)
-	} else {
-		// do we need to add this one
-		deviceObj.Serial = device.GetDeviceID()
-		deviceObj.Active = 1
-		if json.Unmarshal(device.GetData(), &deviceObj) != nil {
+	err := deviceObj.LoadByField(ctx, db, device.GetDeviceID())
+	if err == database.ErrNotFound {
+		// devices that have not registered are registered with what their settings describe
+		registration := provision.Registration{}
+		if json.Unmarshal(device.GetData(), &registration) != nil {
 			logrus.Error("unable to unmarshal json device data")
 		}
+		return cmd.register(ctx, db, device.GetDeviceID(), &registration)
+	}
 
-		err = deviceObj.Create(db)
-		if err != nil {
-			logrus.Errorf("failed to add device to database: %v", err)
+	if err != nil {
+		return err
+	}
+
+	if deviceObj.State != database.DeviceClaimed {
+		logrus.Infof("ignoring settings from unclaimed device %s", deviceObj.Serial)
+		return nil
+	}
+
+	reported, err := settings.ParseReported(device.GetData())
+	if err != nil {
+		return fmt.Errorf("invalid settings from %s: %v", deviceObj.Serial, err)
+	}
+
+	if err = settings.Record(ctx, db, deviceObj.ID, reported); err != nil {
+		return err
+	}
+
+	cmd.notifyOwner(ctx, db, deviceObj.ID, events.SettingsChanged, reported)
+
+	return cmd.pushDesired(ctx, db, &deviceObj, reported, false)
+}
+
+// notifyOwner publishes the event to the user the device belongs to, if it belongs to anyone
+func (cmd *RunCommand) notifyOwner(ctx context.Context, db *sqlx.DB, deviceID int, eventType string, data interface{}) {
+	deviceUserMap := database.DeviceUserMappingObject{}
+	err := deviceUserMap.LoadByField(ctx, db, strconv.Itoa(deviceID))
+	if err != nil {
+		if err != database.ErrNotFound {
+			logrus.Warnf("unable to notify owner of device %d: %v", deviceID, err)
 		}
+		return
 	}
 
-	return err
+	cmd.events.Publish(events.Event{Type: eventType, UserID: deviceUserMap.UserID, DeviceID: deviceID, Data: data})
 }
 
-func (cmd *RunCommand) processImageObject(db *sqlx.DB, device topics.DeviceData) error {
-	// look up device by id (serial)
+// captureEvent is the data published with new captures
+type captureEvent struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// lookupOwner finds the mapping and user the device with the serial belongs to
+func lookupOwner(ctx context.Context, db *sqlx.DB, serial string) (*database.DeviceUserMappingObject, *database.UserObject, error) {
 	deviceObj := database.DeviceObject{}
 
 	// look up device to determine user id
-	err := deviceObj.LoadByField(db, device.GetDeviceID())
+	err := deviceObj.LoadByField(ctx, db, serial)
+	if err == database.ErrNotFound {
+		return nil, nil, fmt.Errorf("%w: unknown device %s", provision.ErrNotClaimed, serial)
+	}
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	// media is only accepted once the device belongs to someone
+	if deviceObj.State != database.DeviceClaimed {
+		return nil, nil, fmt.Errorf("%w: %s", provision.ErrNotClaimed, serial)
 	}
 
 	logrus.Infof("retrieved device: %v", deviceObj)
 	deviceUserMap := database.DeviceUserMappingObject{}
-	err = deviceUserMap.LoadByField(db, strconv.Itoa(deviceObj.ID))
+	err = deviceUserMap.LoadByField(ctx, db, strconv.Itoa(deviceObj.ID))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	userObj := database.UserObject{ID: deviceUserMap.UserID}
-	err = userObj.Load(db)
+	err = userObj.Load(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &deviceUserMap, &userObj, nil
+}
+
+func (cmd *RunCommand) processImageObject(ctx context.Context, db *sqlx.DB, device topics.DeviceData) error {
+	imageData, ok := device.(*topics.ImageData)
+	if !ok {
+		return fmt.Errorf("unexpected image data type: %T", device)
+	}
+
+	deviceUserMap, userObj, err := lookupOwner(ctx, db, device.GetDeviceID())
 	if err != nil {
 		return err
 	}
 
 	// Get cache file location
 	cacheStorage := viper.GetString(config.WebServerCache)
+	frame := imageData.Frame()
+
+	// Create new image for this user, the file and row are stored together or not at all
+	imageObj := database.ImageObject{UserID: deviceUserMap.UserID, DeviceID: deviceUserMap.DeviceID, Name: frame.FileName}
+	_, err = storage.StoreFile(ctx, db, filepath.Join(cacheStorage, userObj.UserName, frame.FileName), frame.Data,
+		func(ctx context.Context, transaction database.Executor, path string) error {
+			imageObj.Path = path
+			return imageObj.Create(ctx, transaction)
+		})
+	if err != nil {
+		return err
+	}
+
+	cmd.events.Publish(events.Event{
+		Type:     events.ImageCaptured,
+		UserID:   imageObj.UserID,
+		DeviceID: imageObj.DeviceID,
+		Data:     captureEvent{ID: imageObj.ID, Name: frame.FileName},
+	})
+
+	// push the image to anyone watching the device live
+	cmd.broadcaster.Publish(&server.LiveFrame{
+		UserID:      imageObj.UserID,
+		DeviceID:    imageObj.DeviceID,
+		ContentType: http.DetectContentType(frame.Data),
+		Data:        frame.Data,
+	})
+	return nil
+}
+
+func (cmd *RunCommand) processVideoObject(ctx context.Context, db *sqlx.DB, device topics.DeviceData) error {
+	videoData, ok := device.(*topics.VideoData)
+	if !ok {
+		return fmt.Errorf("unexpected video data type: %T", device)
+	}
 
-	rawData := device.GetData()
-	fileNameLength := rawData[0]
-	fileName := cacheStorage + userObj.UserName + "/" + string(rawData[1:fileNameLength])
-	imageSizeTemp := rawData[fileNameLength+1 : 4] // copy to byte align
-	imageSize := binary.LittleEndian.Uint32(imageSizeTemp)
+	deviceUserMap, userObj, err := lookupOwner(ctx, db, device.GetDeviceID())
+	if err != nil {
+		return err
+	}
 
-	imageFile, err := os.Create(fileName)
+	cacheStorage := viper.GetString(config.WebServerCache)
+	frame := videoData.Frame()
+
+	// videos are kept apart from the images of the user
+	videoObj := database.VideoObject{
+		UserID:     deviceUserMap.UserID,
+		DeviceID:   deviceUserMap.DeviceID,
+		Name:       frame.FileName,
+		DurationMS: frame.Metadata.DurationMS,
+		Container:  frame.Metadata.Container,
+		Codec:      frame.Metadata.Codec,
+		Size:       int64(len(frame.Data)),
+	}
+	_, err = storage.StoreFile(ctx, db, filepath.Join(cacheStorage, userObj.UserName, videoDirectory, frame.FileName), frame.Data,
+		func(ctx context.Context, transaction database.Executor, path string) error {
+			videoObj.Path = path
+			return videoObj.Create(ctx, transaction)
+		})
 	if err != nil {
 		return err
 	}
 
-	bytesWritten, err := imageFile.Write(rawData[fileNameLength+5:])
+	cmd.events.Publish(events.Event{
+		Type:     events.VideoCaptured,
+		UserID:   videoObj.UserID,
+		DeviceID: videoObj.DeviceID,
+		Data:     captureEvent{ID: videoObj.ID, Name: frame.FileName},
+	})
+	return nil
+}
+
+// linkCapture points the clip at the latest image or video of the user with the capture name
+func linkCapture(ctx context.Context, db *sqlx.DB, audioObj *database.AudioObject, captureName string) error {
+	criteria := map[string]string{"user_id": strconv.Itoa(audioObj.UserID), "name": captureName}
 
+	image := database.ImageObject{}
+	err := image.LoadLatest(ctx, db, criteria)
+	if err == nil {
+		audioObj.ImageID = image.ID
+		return nil
+	}
+	if err != database.ErrNotFound {
+		return err
+	}
+
+	video := database.VideoObject{}
+	err = video.LoadLatest(ctx, db, criteria)
+	if err == nil {
+		audioObj.VideoID = video.ID
+		return nil
+	}
+	if err != database.ErrNotFound {
+		return err
+	}
+
+	logrus.Warnf("audio clip %s refers to unknown capture %s", audioObj.Name, captureName)
+	return nil
+}
+
+func (cmd *RunCommand) processAudioObject(ctx context.Context, db *sqlx.DB, device topics.DeviceData) error {
+	audioData, ok := device.(*topics.AudioData)
+	if !ok {
+		return fmt.Errorf("unexpected audio data type: %T", device)
+	}
+
+	deviceUserMap, userObj, err := lookupOwner(ctx, db, device.GetDeviceID())
 	if err != nil {
 		return err
 	}
 
-	if uint32(bytesWritten) != imageSize {
-		return fmt.Errorf("failed to write complete image file....%d of size %d", bytesWritten, imageSize)
+	userDirectory := filepath.Join(viper.GetString(config.WebServerCache), userObj.UserName)
+	frame := audioData.Frame()
+
+	audioObj := database.AudioObject{
+		UserID:     deviceUserMap.UserID,
+		DeviceID:   deviceUserMap.DeviceID,
+		Name:       frame.FileName,
+		Format:     frame.Format,
+		DurationMS: frame.DurationMS,
+		Size:       int64(len(frame.Data)),
 	}
 
-	// Create new image for this user
-	imageObj := database.ImageObject{UserID: deviceUserMap.UserID, DeviceID: deviceUserMap.DeviceID}
-	imageObj.Path = fileName
-	err = imageObj.Create(db)
+	if len(frame.CaptureName) > 0 {
+		err = linkCapture(ctx, db, &audioObj, frame.CaptureName)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = storage.StoreFile(ctx, db, filepath.Join(userDirectory, audioDirectory, frame.FileName), frame.Data,
+		func(ctx context.Context, transaction database.Executor, path string) error {
+			audioObj.Path = path
+			return audioObj.Create(ctx, transaction)
+		})
 	if err != nil {
 		return err
 	}
-	// Notify of new image
+
+	cmd.events.Publish(events.Event{
+		Type:     events.AudioCaptured,
+		UserID:   audioObj.UserID,
+		DeviceID: audioObj.DeviceID,
+		Data:     captureEvent{ID: audioObj.ID, Name: frame.FileName},
+	})
 	return nil
 }
 
-func (cmd *RunCommand) processMQTTRequest(siteConfig *config.SiteConfiguration, topic, message string) error {
-	deviceData, mqttErr := topics.ProcessIncomingMQTTMessage(topic, message)
-	if mqttErr == nil {
-		logrus.Infof("RECEIVED type: %d device: %s", deviceData.GetType(), deviceData.GetDeviceID())
+// publisher queues messages for the broker without stalling message processing when it is unavailable,
+// retained messages are kept by the broker for devices that subscribe later
+func publisher(siteConfig *config.SiteConfiguration, retained bool) topics.Publisher {
+	return func(topic string, payload []byte) {
+		select {
+		case siteConfig.OutgoingMQTT <- topics.Message{Topic: topic, Payload: payload, QoS: publishQoS, Retained: retained}:
+		case <-time.After(publishWait):
+			logrus.Warnf("dropped message to %s, broker is not accepting messages", topic)
+		}
+	}
+}
 
-		switch deviceData.GetType() {
-		case topics.SettingsType:
-			err := cmd.processSettingsObject(siteConfig.Database, deviceData)
-			if err != nil {
-				return err
-			}
+// registerHandlers routes each device topic to the processing for it
+func (cmd *RunCommand) registerHandlers(siteConfig *config.SiteConfiguration) error {
+	cmd.router = topics.NewRouter()
+	cmd.publish = publisher(siteConfig, false)
+	cmd.publishRetained = publisher(siteConfig, true)
+	cmd.broadcaster = server.NewBroadcaster()
+	cmd.health = health.NewMonitor(siteConfig.Database)
+	cmd.events = events.NewBus()
+	cmd.commands = commands.NewDispatcher(cmd.publish, viper.GetDuration(config.CommandTimeout))
+	cmd.reassembler = topics.NewReassembler(viper.GetDuration(config.TransferTimeout), cmd.publish)
+	cmd.presence = presence.NewTracker(siteConfig.Database, cmd.events, viper.GetDuration(config.PresenceTimeout))
+
+	err := cmd.router.Handle(topics.ActionPattern(topics.SettingsTopic), topics.DecodeDeviceSettings,
+		func(ctx context.Context, message *topics.Message, deviceData topics.DeviceData) error {
+			return cmd.processSettingsObject(ctx, siteConfig.Database, deviceData)
+		})
+	if err != nil {
+		return err
+	}
 
-		case topics.ImageType:
+	// large media may arrive as chunks, handleMQTTRequest reassembles them before routing
+	cmd.reassembler.Chunked(topics.ImageTopic, topics.VideoTopic, topics.AudioTopic)
+
+	err = cmd.router.Handle(topics.ActionPattern(topics.ImageTopic), topics.DecodeImageData,
+		func(ctx context.Context, message *topics.Message, deviceData topics.DeviceData) error {
 			// Store image data for this user in the database
-			err := cmd.processImageObject(siteConfig.Database, deviceData)
-			if err != nil {
-				return err
-			}
-		case topics.VideoType:
-		case topics.AudioType:
-		default:
+			return cmd.processImageObject(ctx, siteConfig.Database, deviceData)
+		})
+	if err != nil {
+		return err
+	}
+
+	err = cmd.router.Handle(topics.ActionPattern(topics.VideoTopic), topics.DecodeVideoData,
+		func(ctx context.Context, message *topics.Message, deviceData topics.DeviceData) error {
+			// Store video data for this user in the database
+			return cmd.processVideoObject(ctx, siteConfig.Database, deviceData)
+		})
+	if err != nil {
+		return err
+	}
+
+	err = cmd.router.Handle(topics.ActionPattern(topics.AudioTopic), topics.DecodeAudioData,
+		func(ctx context.Context, message *topics.Message, deviceData topics.DeviceData) error {
+			// Store audio clips for this user in the database
+			return cmd.processAudioObject(ctx, siteConfig.Database, deviceData)
+		})
+	if err != nil {
+		return err
+	}
+
+	err = cmd.router.Handle(topics.ActionPattern(topics.RegisterTopic), topics.DecodeRegisterData,
+		func(ctx context.Context, message *topics.Message, deviceData topics.DeviceData) error {
+			return cmd.processRegistration(ctx, siteConfig.Database, deviceData)
+		})
+	if err != nil {
+		return err
+	}
+
+	err = cmd.router.Handle(topics.ActionPattern(topics.AckTopic), topics.DecodeAckData,
+		func(ctx context.Context, message *topics.Message, deviceData topics.DeviceData) error {
+			return cmd.commands.Acknowledge(deviceData.GetDeviceID(), deviceData.GetData())
+		})
+	if err != nil {
+		return err
+	}
+
+	err = cmd.registerPresence()
+	if err != nil {
+		return err
+	}
+
+	cmd.router.HandleUnknown(func(ctx context.Context, message *topics.Message) error {
+		logrus.Debugf("ignoring message on unhandled topic: %s", message.Topic)
+		return nil
+	})
+
+	return nil
+}
+
+// registerPresence routes heartbeats and status reports, including the last will the broker
+// publishes for a device that disconnects uncleanly, to the presence tracker
+func (cmd *RunCommand) registerPresence() error {
+	handler := func(ctx context.Context, message *topics.Message, deviceData topics.DeviceData) error {
+		report, err := presence.ParseReport(deviceData.GetData())
+		if err != nil {
+			return fmt.Errorf("invalid presence report from %s: %v", deviceData.GetDeviceID(), err)
 		}
+
+		// the device was seen when the message arrived, not when it got its turn to be processed
+		return cmd.presence.Seen(ctx, deviceData.GetDeviceID(), report, message.Received)
 	}
-	return mqttErr
+
+	err := cmd.router.Handle(topics.ActionPattern(topics.HeartbeatTopic), topics.DecodeStatusData, handler)
+	if err != nil {
+		return err
+	}
+
+	return cmd.router.Handle(topics.ActionPattern(topics.StatusTopic), topics.DecodeStatusData, handler)
+}
+
+func (cmd *RunCommand) processMQTTRequest(ctx context.Context, message *topics.Message) error {
+	return cmd.router.Route(ctx, message)
 }
 
-func (cmd *RunCommand) process(siteConfig *config.SiteConfiguration) error {
+// handleMQTTRequest processes a request, retrying it with an increasing delay while the database
+// is unavailable. requests that still fail are kept as dead letters to be replayed later. chunks
+// are reassembled first so a retry or dead letter has the whole payload rather than the last chunk,
+// a refused chunk is not kept as a dead letter as a single chunk could never be replayed, the
+// reassembler tells the device to resend it instead
+func (cmd *RunCommand) handleMQTTRequest(ctx context.Context, message *topics.Message) {
+	complete, err := cmd.reassembler.Assemble(message)
+	if errors.Is(err, topics.ErrTransferIncomplete) {
+		return
+	}
+	if err != nil {
+		logrus.Warnf("refused chunk on %s: %v", message.Topic, err)
+		return
+	}
+	message = complete
+
+	retries := viper.GetInt(config.DatabaseRetries)
+	delay := retryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := cmd.processMQTTRequest(ctx, message)
+		if err == nil {
+			return
+		}
+
+		if errors.Is(err, provision.ErrNotClaimed) {
+			logrus.Infof("ignoring %s: %v", message.Topic, err)
+			return
+		}
+
+		if !database.IsTransient(err) || attempt > retries || ctx.Err() != nil {
+			cmd.deadLetter(message, attempt, err)
+			return
+		}
+
+		logrus.Warnf("database unavailable processing mqtt request: %s, retry %d of %d in %v: %v", message, attempt, retries, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// deadLetter keeps a message that could not be processed so it can be inspected and replayed
+func (cmd *RunCommand) deadLetter(message *topics.Message, attempts int, reason error) {
+	logrus.Errorf("dead-lettering mqtt request: %s after %d attempts: %v", message, attempts, reason)
+
+	// the request context may be what failed, the dead letter should still be kept
+	letter := database.DeadLetterObject{Topic: message.Topic, Payload: message.Payload, Error: reason.Error(),
+		Attempts: attempts, LastAttempt: time.Now().UTC()}
+	if err := letter.Create(context.Background(), cmd.database); err != nil {
+		logrus.Errorf("failed to keep dead letter for %s, the message is lost: %v", message, err)
+	}
+}
+
+// newIngestPool creates the workers messages are processed on, one device at a time per worker
+func (cmd *RunCommand) newIngestPool() (*ingest.Pool, error) {
+	policy, err := ingest.ParsePolicy(viper.GetString(config.IngestOverflow))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", config.IngestOverflow, err)
+	}
+
+	overflow := func(message *topics.Message, reason error) {
+		cmd.deadLetter(message, 0, reason)
+	}
+
+	return ingest.NewPool(viper.GetInt(config.IngestWorkers), viper.GetInt(config.IngestQueue), policy,
+		cmd.handleMQTTRequest, overflow), nil
+}
 
+func (cmd *RunCommand) process(ctx context.Context, cancel context.CancelFunc, siteConfig *config.SiteConfiguration) error {
 	onQuit := make(chan os.Signal, 1)
 	signal.Notify(onQuit, syscall.SIGINT, syscall.SIGTERM)
 
-	var quitReason os.Signal
-	for quitReason == nil {
+	quitReason := make(chan os.Signal, 1)
+	go func() {
+		reason := <-onQuit
+		logrus.Info("applcation is now exiting on signal")
+		quitReason <- reason
+		cancel()
+	}()
+
+	for ctx.Err() == nil {
 		select {
 		case incomingMQTT := <-siteConfig.IncomingMQTT:
-			logrus.Infof("Received mqtt request: %s, %s", incomingMQTT[0], incomingMQTT[1])
-			if cmd.processMQTTRequest(siteConfig, incomingMQTT[0], incomingMQTT[1]) != nil {
-				logrus.Warnf("failed to process mqtt request: %s, %s", incomingMQTT[0], incomingMQTT[1])
-			}
-		case quitReason = <-onQuit:
-			logrus.Info("applcation is now exiting on signal")
+			logrus.Infof("Received mqtt request: %s", &incomingMQTT)
+			cmd.ingest.Submit(ctx, topics.DeviceID(incomingMQTT.Topic), &incomingMQTT)
+		case <-ctx.Done():
 		}
 	}
-	if quitReason != syscall.SIGINT {
-		return fmt.Errorf("shutting down due to signal: %+v", quitReason)
+
+	reason := <-quitReason
+	if reason != syscall.SIGINT {
+		return fmt.Errorf("shutting down due to signal: %+v", reason)
 	}
 	return nil
 }
 
+// preparePipeline loads the settings schemas and sets up the processing of device messages
+func (cmd *RunCommand) preparePipeline(siteConfig *config.SiteConfiguration) error {
+	cmd.database = siteConfig.Database
+
+	schemas, err := settings.LoadSchemas(viper.GetString(config.SettingsSchemas))
+	if err != nil {
+		return err
+	}
+	cmd.schemas = schemas
+
+	err = cmd.registerHandlers(siteConfig)
+	if err != nil {
+		return err
+	}
+
+	cmd.ingest, err = cmd.newIngestPool()
+	return err
+}
+
+// prepare sets up the processing of device messages and loads the broker certificates,
+// refusing to start rather than fail later when any of them are unusable
+func (cmd *RunCommand) prepare(siteConfig *config.SiteConfiguration) (*certs.Credentials, *MQTT.ClientOptions, error) {
+	err := cmd.preparePipeline(siteConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentials, err := loadCredentials()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts, err := newClientOptions(siteConfig.ClientID, credentials)
+	return credentials, opts, err
+}
+
 // Run is the method that is executed when the run command is selected
 func (cmd *RunCommand) Run() error {
 
@@ -177,49 +648,71 @@ func (cmd *RunCommand) Run() error {
 
 	siteConfig := config.NewSiteConfiguration(cmd.ConfigurationFile, true)
 
+	credentials, opts, err := cmd.prepare(siteConfig)
+	if err != nil {
+		return err
+	}
+
+	// cancelling the context aborts any in flight database operations
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go cmd.reassembler.Run(ctx)
+	go cmd.presence.Run(ctx)
+	go reloadCredentials(ctx, credentials)
+
+	running := &sync.WaitGroup{}
+	running.Add(3)
+
+	// requests in flight are cancelled, and dead-lettered if that fails them. those still queued
+	// are processed for up to ingestWait and the rest dead-lettered, all before the database is
+	// closed. only a message the broker delivers once shutdown has begun is dropped, with a warning
+	go func() {
+		defer running.Done()
+		cmd.ingest.Run(ctx, ingestWait)
+	}()
+
 	// connect to mqtt
-	go setupMQTTMessages(siteConfig)
+	go func() {
+		defer running.Done()
+		setupMQTTMessages(siteConfig, opts, cmd.health)
+	}()
 
 	// server up the world
-	go setupWebserver(siteConfig)
+	go func() {
+		defer running.Done()
+		cmd.setupWebserver(siteConfig)
+	}()
 
-	quitReason := cmd.process(siteConfig)
+	quitReason := cmd.process(ctx, cancel, siteConfig)
 
-	siteConfig.AppActive <- struct{}{}
+	// closing rather than sending lets every goroutine waiting on it see the app go down
+	close(siteConfig.AppActive)
+	running.Wait()
 
 	_ = siteConfig.Database.Close()
 
 	return quitReason
 }
 
-func setupMQTTMessages(siteConfig *config.SiteConfiguration) {
-	prefix := "ssl://"
-	if !viper.GetBool(config.BrokerSSL) {
-		// configure for tcp
-		prefix = "tcp://"
-	}
-
+func setupMQTTMessages(siteConfig *config.SiteConfiguration, opts *MQTT.ClientOptions, monitor *health.Monitor) {
 	logrus.Infof("ClientID: %s", siteConfig.ClientID)
-	broker := prefix + viper.GetString(config.BrokerAddress)
-	broker += ":" + strconv.Itoa(viper.GetInt(config.BrokerPort))
-
-	opts := MQTT.NewClientOptions()
-	opts.AddBroker(broker)
-	opts.SetClientID(siteConfig.ClientID)
-	opts.SetCleanSession(true)
-	// opts.SetStore(MQTT.NewFileStore("path to store")) // default is memory
+	broker := brokerURL()
 
 	opts.SetDefaultPublishHandler(func(client MQTT.Client, msg MQTT.Message) {
-		siteConfig.IncomingMQTT <- [2]string{msg.Topic(), string(msg.Payload())}
+		health.MessagesReceived.Add(1)
+		select {
+		case siteConfig.IncomingMQTT <- topics.Message{Topic: msg.Topic(), Payload: msg.Payload(), QoS: msg.Qos(),
+			Retained: msg.Retained(), Received: time.Now(), MessageID: msg.MessageID()}:
+		case <-siteConfig.AppActive:
+			logrus.Warnf("dropped message on %s delivered while shutting down", msg.Topic())
+		}
 	})
+	configureSession(opts, monitor)
 
 	client := MQTT.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
-	}
-
-	if token := client.Subscribe("afm/v1/#", 1, nil); token.Wait() && token.Error() != nil {
-		logrus.Errorf(token.Error().Error())
+	if !connectWithBackoff(client, monitor, siteConfig.AppActive) {
+		return
 	}
 
 	logrus.Infof("Broker: %s", broker)
@@ -228,35 +721,76 @@ func setupMQTTMessages(siteConfig *config.SiteConfiguration) {
 	for !quit {
 		select {
 		case outgoingMessage := <-siteConfig.OutgoingMQTT:
-			qos, err := strconv.Atoi(outgoingMessage[2])
-			if err != nil {
-				qos = 0
-			}
-
-			token := client.Publish(outgoingMessage[0], byte(qos), false, outgoingMessage[1])
+			token := client.Publish(outgoingMessage.Topic, outgoingMessage.QoS, outgoingMessage.Retained, outgoingMessage.Payload)
 			if token.Error() != nil {
+				health.PublishFailures.Add(1)
 				logrus.Errorf("failed publishing message: %v", token.Error())
+				continue
 			}
+			health.MessagesPublished.Add(1)
 		// wait for the app to go down
 		case <-siteConfig.AppActive:
 			quit = true
 		}
 	}
 	client.Disconnect(mqttWait)
+	monitor.SetBroker(health.Disconnected, nil)
 }
 
-func setupWebserver(siteConfig *config.SiteConfiguration) {
-	httpServerDone := &sync.WaitGroup{}
+// routes registers the pages and api of the web server
+func (cmd *RunCommand) routes(siteConfig *config.SiteConfiguration) *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 
+	media := server.NewServer(siteConfig.Database)
+	media.LiveStaleness = viper.GetDuration(config.WebServerLiveStaleness)
+	media.LiveFrameRate = viper.GetInt(config.WebServerLiveFrameRate)
+	media.Broadcaster = cmd.broadcaster
+	media.Events = cmd.events
+	media.Commands = cmd.commands
+	media.Publish = cmd.publish
+	media.PublishRetained = cmd.publishRetained
+	media.Schemas = cmd.schemas
+	router.HandleFunc("/login", media.Login).Methods(http.MethodPost)
+	router.HandleFunc("/logout", media.Logout).Methods(http.MethodPost)
+	router.Handle("/devices/{deviceSerial}/settings", media.Authenticate(http.HandlerFunc(media.DeviceSettings))).Methods(http.MethodGet, http.MethodPut)
+	router.Handle("/devices/claim", media.Authenticate(http.HandlerFunc(media.ClaimDevice))).Methods(http.MethodPost)
+	router.Handle("/devices", media.Authenticate(http.HandlerFunc(media.ListDevices))).Methods(http.MethodGet)
+	router.Handle("/devices/{deviceSerial}/commands", media.Authenticate(http.HandlerFunc(media.SendCommand))).Methods(http.MethodPost)
+	router.Handle("/events", media.Authenticate(http.HandlerFunc(media.StreamEvents))).Methods(http.MethodGet)
+	router.Handle("/stream", media.Authenticate(http.HandlerFunc(media.StreamLive))).Methods(http.MethodGet)
+	router.Handle("/stream/{deviceSerial}", media.Authenticate(http.HandlerFunc(media.StreamLive))).Methods(http.MethodGet)
+	router.Handle("/live", media.Authenticate(http.HandlerFunc(media.RetrieveLiveImage))).Methods(http.MethodGet, http.MethodHead)
+	router.Handle("/live/{deviceSerial}", media.Authenticate(http.HandlerFunc(media.RetrieveLiveImage))).Methods(http.MethodGet, http.MethodHead)
+	router.Handle("/videos", media.Authenticate(http.HandlerFunc(media.ListVideos))).Methods(http.MethodGet)
+	router.Handle("/videos/{id:[0-9]+}", media.Authenticate(http.HandlerFunc(media.ServeVideo))).Methods(http.MethodGet, http.MethodHead)
+	router.Handle("/audio", media.Authenticate(http.HandlerFunc(media.ListAudio))).Methods(http.MethodGet)
+	router.Handle("/audio/{id:[0-9]+}", media.Authenticate(http.HandlerFunc(media.ServeAudio))).Methods(http.MethodGet, http.MethodHead)
+	// left open for load balancers, it only reports whether the database and broker are reachable
+	router.Handle("/health", cmd.health).Methods(http.MethodGet, http.MethodHead)
+	// the metrics include the command line and memory statistics, so they need a token like the rest
+	router.Handle("/debug/vars", media.Authenticate(expvar.Handler())).Methods(http.MethodGet)
+	// router.HandleFunc("favicon.ico", server.HandleFavoriteIcon)
+	router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("web"))))
+
+	return router
+}
+
+func (cmd *RunCommand) setupWebserver(siteConfig *config.SiteConfiguration) {
+	httpServerDone := &sync.WaitGroup{}
+
 	serverPort := viper.GetInt(config.WebServerPort)
 	serverAddress := viper.GetString(config.WebServerAddress)
 
-	router.HandleFunc("/live", server.RetrieveLiveImage)
-	// router.HandleFunc("favicon.ico", server.HandleFavoriteIcon)
-	router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("web"))))
+	// streams never finish on their own, cancel them once shutdown begins
+	baseContext, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
 
-	server := &http.Server{Addr: serverAddress + ":" + strconv.Itoa(serverPort), Handler: router}
+	server := &http.Server{
+		Addr:        serverAddress + ":" + strconv.Itoa(serverPort),
+		Handler:     cmd.routes(siteConfig),
+		BaseContext: func(net.Listener) context.Context { return baseContext },
+	}
+	server.RegisterOnShutdown(stopStreams)
 
 	logrus.Infof("http server: %v", server.Addr)
 
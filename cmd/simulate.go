@@ -0,0 +1,313 @@
+// Package cmd is for any command line arguments this application utilizes
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"site/config"
+	"site/pkg/broker"
+	"site/pkg/server"
+	"site/pkg/simulator"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	// claimAttempts is how often a camera is claimed before giving up, the site may not have registered it yet
+	claimAttempts = 10
+	// claimDelay is the wait between claims of a camera
+	claimDelay = time.Second
+	// siteTimeout is how long a request to the site may take
+	siteTimeout = 10 * time.Second
+)
+
+// SimulateCommand is a struct to enclose running virtual cameras against the broker
+type SimulateCommand struct {
+	ConfigurationFile string        `short:"c" help:"Defines the non-default configuration file to use."`
+	Count             int           `short:"n" default:"10" help:"Number of cameras to simulate"`
+	Prefix            string        `short:"p" default:"sim" help:"Serials are the prefix followed by the number of the camera"`
+	ImageInterval     time.Duration `short:"i" default:"5s" help:"How often each camera sends an image, 0 for only on capture"`
+	Resolution        string        `short:"r" default:"640x480" help:"Size of the images the cameras send"`
+	Heartbeat         time.Duration `default:"30s" help:"How often each camera sends a heartbeat, 0 for never"`
+	Duration          time.Duration `short:"d" help:"Stop after this long instead of on interrupt"`
+	Report            time.Duration `default:"10s" help:"How often to print throughput"`
+	Embedded          bool          `short:"e" help:"Run a broker in this process on the configured broker address and port"`
+	Site              string        `short:"s" help:"URL of the running site, e.g. http://localhost:8080, to report how fast it ingests with --token"`
+	Token             string        `short:"t" help:"Access token of the user the cameras are claimed for and the metrics of the site read with, requires --site"`
+
+	stats simulator.Stats
+}
+
+// Run is the method that is executed when the simulate command is selected
+func (cmd *SimulateCommand) Run() error {
+	if cmd.Count < 1 {
+		return fmt.Errorf("invalid count %d, at least one camera is needed", cmd.Count)
+	}
+
+	siteConfig := config.NewSiteConfiguration(cmd.ConfigurationFile, true)
+	_ = siteConfig.Database.Close()
+
+	var width, height int
+	if _, err := fmt.Sscanf(cmd.Resolution, "%dx%d", &width, &height); err != nil || width < 1 || height < 1 {
+		return fmt.Errorf("invalid resolution %q, expected e.g. 640x480", cmd.Resolution)
+	}
+
+	picture, err := simulator.NewImage(width, height, byte(os.Getpid()))
+	if err != nil {
+		return err
+	}
+
+	if cmd.Embedded {
+		embedded, err := startBroker()
+		if err != nil {
+			return err
+		}
+		defer embedded.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cameras, err := cmd.newCameras(picture)
+	if err != nil {
+		return err
+	}
+
+	// a site that is not up yet has processed nothing so far
+	processed := cmd.siteProcessed()
+	if processed < 0 {
+		processed = 0
+	}
+	started := time.Now()
+
+	running := cmd.start(ctx, cameras)
+	logrus.Infof("simulating %d cameras sending %d byte images against %s", len(cameras), len(picture), brokerURL())
+
+	if len(cmd.Token) > 0 {
+		go cmd.claim(ctx, cameras)
+	}
+
+	cmd.wait()
+	cancel()
+	running.Wait()
+
+	cmd.report(time.Since(started))
+	if len(cmd.Site) > 0 {
+		if total := cmd.siteProcessed(); total >= 0 {
+			fmt.Printf("site processed %d messages, %.1f/s\n", total-processed, float64(total-processed)/time.Since(started).Seconds())
+		}
+	}
+	return nil
+}
+
+// startBroker runs the embedded broker on the configured address, it does not speak tls
+func startBroker() (*broker.Broker, error) {
+	if viper.GetBool(config.BrokerSSL) {
+		return nil, fmt.Errorf("the embedded broker does not support tls, set %s to false", config.BrokerSSL)
+	}
+
+	address := viper.GetString(config.BrokerAddress) + ":" + strconv.Itoa(viper.GetInt(config.BrokerPort))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start the embedded broker: %v", err)
+	}
+
+	embedded := broker.New()
+	go func() {
+		if err := embedded.Serve(listener); err != nil {
+			logrus.Errorf("embedded broker stopped: %v", err)
+		}
+	}()
+
+	logrus.Infof("embedded broker listening on %s", address)
+	return embedded, nil
+}
+
+// newCameras creates the cameras, each connecting with its serial as client id
+func (cmd *SimulateCommand) newCameras(picture []byte) ([]*simulator.Camera, error) {
+	credentials, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	cameras := make([]*simulator.Camera, 0, cmd.Count)
+	for index := 1; index <= cmd.Count; index++ {
+		serial := fmt.Sprintf("%s-%04d", cmd.Prefix, index)
+
+		opts, err := newClientOptions(serial, credentials)
+		if err != nil {
+			return nil, err
+		}
+		cameras = append(cameras, simulator.NewCamera(serial, opts, picture, &cmd.stats))
+	}
+	return cameras, nil
+}
+
+// start runs every camera until ctx is done, spreading their images over the interval so
+// they do not all arrive at once
+func (cmd *SimulateCommand) start(ctx context.Context, cameras []*simulator.Camera) *sync.WaitGroup {
+	running := &sync.WaitGroup{}
+	running.Add(len(cameras))
+
+	for index, camera := range cameras {
+		go func(camera *simulator.Camera, offset time.Duration) {
+			defer running.Done()
+
+			select {
+			case <-time.After(offset):
+			case <-ctx.Done():
+				return
+			}
+
+			if err := camera.Run(ctx, cmd.ImageInterval, cmd.Heartbeat); err != nil {
+				logrus.Error(err)
+			}
+		}(camera, cmd.ImageInterval*time.Duration(index)/time.Duration(len(cameras)))
+	}
+
+	return running
+}
+
+// wait prints the throughput every report interval until interrupted or the duration has passed
+func (cmd *SimulateCommand) wait() {
+	onQuit := make(chan os.Signal, 1)
+	signal.Notify(onQuit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(onQuit)
+
+	var stop <-chan time.Time
+	if cmd.Duration > 0 {
+		stop = time.After(cmd.Duration)
+	}
+
+	var reports <-chan time.Time
+	if cmd.Report > 0 {
+		ticker := time.NewTicker(cmd.Report)
+		defer ticker.Stop()
+		reports = ticker.C
+	}
+
+	started := time.Now()
+	for {
+		select {
+		case <-reports:
+			cmd.report(time.Since(started))
+		case <-onQuit:
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// report prints what the cameras have sent so far
+func (cmd *SimulateCommand) report(elapsed time.Duration) {
+	current := cmd.stats.Snapshot()
+	seconds := elapsed.Seconds()
+
+	fmt.Printf("%v: %d images (%.1f/s, %.2f MB/s), %d heartbeats, %d commands, %d failures\n",
+		elapsed.Round(time.Second), current.Images, float64(current.Images)/seconds,
+		float64(current.Bytes)/seconds/(1024*1024), current.Heartbeats, current.Commands, current.Failures)
+}
+
+// siteProcessed returns how many messages the site has processed, -1 when there is no site to ask
+func (cmd *SimulateCommand) siteProcessed() int64 {
+	if len(cmd.Site) == 0 {
+		return -1
+	}
+
+	request, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(cmd.Site, "/")+"/debug/vars", nil)
+	if err != nil {
+		logrus.Warnf("unable to read the metrics of the site: %v", err)
+		return -1
+	}
+	if len(cmd.Token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+cmd.Token)
+	}
+
+	client := http.Client{Timeout: siteTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		logrus.Warnf("unable to read the metrics of the site: %v", err)
+		return -1
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		logrus.Warnf("unable to read the metrics of the site, reading them requires --token: %s", response.Status)
+		return -1
+	}
+
+	metrics := struct {
+		Processed int64 `json:"ingest_processed"`
+	}{}
+	if err = json.NewDecoder(response.Body).Decode(&metrics); err != nil {
+		logrus.Warnf("unable to read the metrics of the site: %v", err)
+		return -1
+	}
+	return metrics.Processed
+}
+
+// claim gives every camera to the user of the token, waiting for the site to register them
+func (cmd *SimulateCommand) claim(ctx context.Context, cameras []*simulator.Camera) {
+	if len(cmd.Site) == 0 {
+		logrus.Error("claiming the cameras requires --site")
+		return
+	}
+
+	client := http.Client{Timeout: siteTimeout}
+	for _, camera := range cameras {
+		for attempt := 1; ; attempt++ {
+			status, err := cmd.claimCamera(ctx, &client, camera)
+			if err == nil && (status == http.StatusOK || status == http.StatusConflict) {
+				logrus.Debugf("camera %s claimed", camera.Serial)
+				break
+			}
+
+			if attempt == claimAttempts {
+				logrus.Warnf("giving up claiming camera %s, last status %d: %v", camera.Serial, status, err)
+				break
+			}
+
+			select {
+			case <-time.After(claimDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// claimCamera asks the site to claim the camera with the code on its label
+func (cmd *SimulateCommand) claimCamera(ctx context.Context, client *http.Client, camera *simulator.Camera) (int, error) {
+	body, err := json.Marshal(&server.ClaimRequest{Serial: camera.Serial, ClaimCode: camera.ClaimCode})
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(cmd.Site, "/")+"/devices/claim", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+cmd.Token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	_ = response.Body.Close()
+
+	return response.StatusCode, nil
+}
@@ -8,8 +8,12 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"site/pkg/database"
+	"site/pkg/topics"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
@@ -17,12 +21,23 @@ import (
 )
 
 const (
-	identificationPath = "/var/cache/afm/identifier.id"
-	defaultSQLPort     = 3306
-	defaultSQLType     = "mysql"
-	defaultMQTTPort    = 1883
-	defaultFileOptions = 0600
-	defaultWebPort     = 8080
+	identificationPath      = "/var/cache/afm/identifier.id"
+	defaultSQLPort          = 3306
+	defaultSQLType          = "mysql"
+	defaultMQTTPort         = 1883
+	defaultFileOptions      = 0600
+	defaultDirectoryOptions = 0750
+	defaultWebPort          = 8080
+	defaultDatabaseTimeout  = 5 * time.Second
+	defaultDatabaseRetries  = 3
+	defaultTransferTimeout  = 2 * time.Minute
+	defaultLiveStaleness    = 5 * time.Minute
+	defaultLiveFrameRate    = 5
+	defaultCommandTimeout   = 10 * time.Second
+	defaultPresenceTimeout  = 3 * time.Minute
+	defaultReconnectMax     = 2 * time.Minute
+	defaultIngestWorkers    = 4
+	defaultIngestQueue      = 64
 )
 
 // ConfigurationDetails stores the configuration that will be used
@@ -30,14 +45,21 @@ var ConfigurationDetails = map[string]interface{}{
 	BrokerAddress:        "127.0.0.1",
 	BrokerPort:           defaultMQTTPort,
 	BrokerSSL:            false,
-	BrokerPrivateKeyPath: "/etc/afm/ssl",
-	BrokerPublicKeyPath:  "/etc/afm/ssl",
-	BrokerCAPath:         "/etc/afm/ssl",
-
-	DatabaseName: "afmcamera",
-	DatabaseHost: "localhost",
-	DatabasePort: defaultSQLPort,
-	DatabaseType: defaultSQLType,
+	BrokerPrivateKeyPath: "/etc/afm/ssl/client.key",
+	BrokerPublicKeyPath:  "/etc/afm/ssl/client.pem",
+	BrokerCAPath:         "/etc/afm/ssl/ca.pem",
+	BrokerServerName:     "",
+	BrokerTLSVersion:     "1.2",
+	BrokerStore:          "",
+	BrokerReconnectMax:   defaultReconnectMax,
+
+	DatabaseName:    "afmcamera",
+	DatabaseHost:    "localhost",
+	DatabasePort:    defaultSQLPort,
+	DatabaseType:    defaultSQLType,
+	DatabasePath:    "/var/lib/afm/camera.db",
+	DatabaseTimeout: defaultDatabaseTimeout,
+	DatabaseRetries: defaultDatabaseRetries,
 
 	LoggingUseFile: true,
 	LoggingFile:    "/var/log/afm/camera.log",
@@ -47,6 +69,21 @@ var ConfigurationDetails = map[string]interface{}{
 	WebServerAddress: "localhost",
 	WebServerFiles:   "/var/www/html",
 	WebServerPort:    defaultWebPort,
+
+	WebServerLiveStaleness: defaultLiveStaleness,
+	WebServerLiveFrameRate: defaultLiveFrameRate,
+
+	TransferTimeout: defaultTransferTimeout,
+
+	CommandTimeout: defaultCommandTimeout,
+
+	SettingsSchemas: "/etc/afm/schemas",
+
+	PresenceTimeout: defaultPresenceTimeout,
+
+	IngestWorkers:  defaultIngestWorkers,
+	IngestQueue:    defaultIngestQueue,
+	IngestOverflow: "block",
 }
 
 // DefaultConfigPath to our default config
@@ -62,8 +99,8 @@ type DatabaseQueryResponse struct {
 // SiteConfiguration is configuration
 type SiteConfiguration struct {
 	AppActive    chan struct{}
-	IncomingMQTT chan [2]string
-	OutgoingMQTT chan [3]string
+	IncomingMQTT chan topics.Message
+	OutgoingMQTT chan topics.Message
 	ClientID     string
 	Database     *sqlx.DB
 }
@@ -221,15 +258,41 @@ func initializeLogging() {
 	}
 }
 
-func setupDatabase(initialDBNameConnect bool) *sqlx.DB {
+// OpenDatabase opens a connection to the configured database, including
+// the configured database name when requested by engines that host several
+func OpenDatabase(includeDatabaseName bool) (*sqlx.DB, error) {
+	dialect, err := database.LookupDialect(viper.GetString(DatabaseType))
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect.Name() == database.SQLiteDialect {
+		databasePath := viper.GetString(DatabasePath)
+
+		err = os.MkdirAll(filepath.Dir(databasePath), defaultDirectoryOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		// wait on locks rather than failing and take the write lock up front in transactions
+		return dialect.Open("file:" + databasePath + "?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	}
+
 	connectionString := viper.GetString(DatabaseUser) + ":" + viper.GetString(DatabasePassword)
 	connectionString += "@tcp(" + viper.GetString(DatabaseHost) + ":" + strconv.Itoa(viper.GetInt(DatabasePort)) + ")/"
 
-	if initialDBNameConnect {
+	if includeDatabaseName {
 		connectionString += viper.GetString(DatabaseName)
 	}
 
-	database, err := sqlx.Open("mysql", connectionString)
+	// times are stored as datetime columns and need to be parsed into time.Time
+	connectionString += "?parseTime=true"
+
+	return dialect.Open(connectionString)
+}
+
+func setupDatabase(initialDBNameConnect bool) *sqlx.DB {
+	database, err := OpenDatabase(initialDBNameConnect)
 
 	if err != nil {
 		panic(err.Error())
@@ -261,10 +324,12 @@ func NewSiteConfiguration(cfgFileOverride string, initialDBNameConnect bool) *Si
 
 	initializeLogging()
 
+	database.SetOperationTimeout(viper.GetDuration(DatabaseTimeout))
+
 	siteConfig := &SiteConfiguration{
 		AppActive:    make(chan struct{}),
-		IncomingMQTT: make(chan [2]string),
-		OutgoingMQTT: make(chan [3]string),
+		IncomingMQTT: make(chan topics.Message),
+		OutgoingMQTT: make(chan topics.Message),
 		ClientID:     determineDeviceClientID(),
 		Database:     setupDatabase(initialDBNameConnect),
 	}
@@ -15,6 +15,9 @@ var (
 	DatabaseUser     = "database.user"
 	DatabasePassword = "database.password"
 	DatabaseKeyFile  = "database.keyfile"
+	DatabasePath     = "database.path"
+	DatabaseTimeout  = "database.timeout"
+	DatabaseRetries  = "database.retries"
 )
 
 // Config keys for mqtt
@@ -25,6 +28,10 @@ var (
 	BrokerCAPath         = "broker.capath"
 	BrokerPublicKeyPath  = "broker.pubkeypath"
 	BrokerPrivateKeyPath = "broker.privkeypath"
+	BrokerServerName     = "broker.servername"
+	BrokerTLSVersion     = "broker.tlsversion"
+	BrokerStore          = "broker.store"
+	BrokerReconnectMax   = "broker.reconnect"
 )
 
 // Logging configuration for logrus
@@ -41,4 +48,34 @@ var (
 	WebServerPort    = "webserver.port"
 	WebServerCache   = "webserver.cache"
 	WebServerFiles   = "webserver.files"
+
+	WebServerLiveStaleness = "webserver.live.staleness"
+	WebServerLiveFrameRate = "webserver.live.fps"
+)
+
+// Config keys for chunked transfers
+var (
+	TransferTimeout = "transfer.timeout"
+)
+
+// Config keys for device commands
+var (
+	CommandTimeout = "commands.timeout"
+)
+
+// Config keys for device settings
+var (
+	SettingsSchemas = "settings.schemas"
+)
+
+// Config keys for device presence
+var (
+	PresenceTimeout = "presence.timeout"
+)
+
+// Config keys for message ingestion
+var (
+	IngestWorkers  = "ingest.workers"
+	IngestQueue    = "ingest.queue"
+	IngestOverflow = "ingest.overflow"
 )
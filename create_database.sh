@@ -1,4 +0,0 @@
-#!/bin/bash
-
-mysql -u dan -p afmcamera < resources/database.sql
-
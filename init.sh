@@ -1,4 +1,3 @@
 #!/bin/bash
 
-./site initialize -d resources/database.sql
-
+./site initialize
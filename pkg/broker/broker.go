@@ -0,0 +1,426 @@
+// Package broker for a minimal embedded mqtt broker to test and simulate against
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"site/pkg/topics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxPacketSize is the largest packet accepted, enough for an image or a chunk of a video
+	MaxPacketSize = 64 * 1024 * 1024
+
+	// connectTimeout is how long a new connection has to send its connect packet
+	connectTimeout = 10 * time.Second
+	// writeTimeout is how long a subscriber may take to accept a packet before it is disconnected
+	writeTimeout = 10 * time.Second
+	// keepAliveGrace is how many keep alive periods may pass without a packet, as the spec allows
+	keepAliveGrace = 1.5
+
+	protocolLevel311 = 4
+	protocolLevel31  = 3
+
+	connectAccepted          = 0
+	connectBadProtocol       = 1
+	connectIdentifierInvalid = 2
+
+	willFlag      = 0x04
+	willQoSShift  = 3
+	willQoSMask   = 0x03
+	willRetain    = 0x20
+	passwordFlag  = 0x40
+	usernameFlag  = 0x80
+	qosShift      = 1
+	qosMask       = 0x03
+	retainFlag    = 0x01
+	maxQoS        = 1
+	maxPacketID   = 0xffff
+	clientIDBytes = 8
+)
+
+// Broker is an mqtt 3.1.1 broker that keeps everything in memory. Every session is clean,
+// messages are delivered with at most qos 1 and are not redelivered, so it suits tests and
+// simulations on a single machine rather than production use.
+type Broker struct {
+	lock     sync.RWMutex
+	clients  map[string]*client
+	retained map[string]*topics.Message
+	listener net.Listener
+	closed   bool
+}
+
+// client is a connected session
+type client struct {
+	id            string
+	connection    net.Conn
+	writeLock     sync.Mutex
+	subscriptions map[string]byte
+	will          *topics.Message
+	nextID        uint16
+}
+
+// New creates a broker without any clients
+func New() *Broker {
+	return &Broker{clients: make(map[string]*client), retained: make(map[string]*topics.Message)}
+}
+
+// Serve accepts connections on the listener until the broker is closed
+func (broker *Broker) Serve(listener net.Listener) error {
+	broker.lock.Lock()
+	broker.listener = listener
+	broker.lock.Unlock()
+
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				continue
+			}
+
+			broker.lock.RLock()
+			defer broker.lock.RUnlock()
+			if broker.closed {
+				return nil
+			}
+			return err
+		}
+
+		go broker.serveConnection(connection)
+	}
+}
+
+// Close stops accepting connections and disconnects every client without publishing their wills
+func (broker *Broker) Close() {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	broker.closed = true
+	if broker.listener != nil {
+		_ = broker.listener.Close()
+	}
+
+	for _, current := range broker.clients {
+		current.will = nil
+		_ = current.connection.Close()
+	}
+}
+
+// Publish delivers a message as if a client had published it
+func (broker *Broker) Publish(message *topics.Message) {
+	if message.Retained {
+		broker.lock.Lock()
+		if len(message.Payload) == 0 {
+			delete(broker.retained, message.Topic)
+		} else {
+			broker.retained[message.Topic] = message
+		}
+		broker.lock.Unlock()
+	}
+
+	broker.lock.RLock()
+	receivers := make(map[*client]byte)
+	for _, current := range broker.clients {
+		for filter, qos := range current.subscriptions {
+			if granted, found := receivers[current]; topics.MatchTopic(filter, message.Topic) && (!found || granted < qos) {
+				receivers[current] = qos
+			}
+		}
+	}
+	broker.lock.RUnlock()
+
+	for current, qos := range receivers {
+		if message.QoS < qos {
+			qos = message.QoS
+		}
+		// retain is only set for messages sent because of a new subscription
+		if err := current.publish(message, qos, false); err != nil {
+			logrus.Debugf("failed to deliver %s to %s: %v", message.Topic, current.id, err)
+			_ = current.connection.Close()
+		}
+	}
+}
+
+// serveConnection handles a client from its connect packet until it disconnects
+func (broker *Broker) serveConnection(connection net.Conn) {
+	defer connection.Close()
+
+	reader := bufio.NewReader(connection)
+	_ = connection.SetReadDeadline(time.Now().Add(connectTimeout))
+
+	first, err := readPacket(reader, MaxPacketSize)
+	if err != nil || first.kind != connectPacket {
+		logrus.Debugf("dropping connection from %s without a connect: %v", connection.RemoteAddr(), err)
+		return
+	}
+
+	current, keepAlive, err := broker.connect(connection, first)
+	if err != nil {
+		logrus.Debugf("refused connection from %s: %v", connection.RemoteAddr(), err)
+		return
+	}
+	logrus.Debugf("client %s connected from %s", current.id, connection.RemoteAddr())
+
+	err = broker.receive(current, reader, keepAlive)
+
+	broker.lock.Lock()
+	if broker.clients[current.id] == current {
+		delete(broker.clients, current.id)
+	}
+	will := current.will
+	broker.lock.Unlock()
+
+	// a client that did not disconnect cleanly has its will published
+	if err != nil && will != nil {
+		logrus.Debugf("client %s went away, publishing its will: %v", current.id, err)
+		broker.Publish(will)
+	}
+}
+
+// connect validates the connect packet and registers the client, taking over any session with the same id
+func (broker *Broker) connect(connection net.Conn, first *packet) (*client, time.Duration, error) {
+	fields := decoder{body: first.body}
+	protocol := fields.string()
+	level := fields.byte()
+	flags := fields.byte()
+	keepAlive := time.Duration(fields.uint16()) * time.Second
+
+	if fields.err == nil && (level != protocolLevel311 || protocol != "MQTT") && (level != protocolLevel31 || protocol != "MQIsdp") {
+		_ = writePacket(connection, &packet{kind: connackPacket, body: []byte{0, connectBadProtocol}})
+		return nil, 0, fmt.Errorf("unsupported protocol %s level %d", protocol, level)
+	}
+
+	current := &client{id: fields.string(), connection: connection, subscriptions: make(map[string]byte)}
+	if flags&willFlag != 0 {
+		current.will = &topics.Message{Topic: fields.string(), Payload: fields.bytes(),
+			QoS: flags >> willQoSShift & willQoSMask, Retained: flags&willRetain != 0}
+	}
+	if flags&usernameFlag != 0 {
+		fields.string()
+	}
+	if flags&passwordFlag != 0 {
+		fields.bytes()
+	}
+
+	if fields.err != nil {
+		return nil, 0, fields.err
+	}
+
+	if len(current.id) == 0 {
+		current.id = generatedClientID(connection)
+	}
+
+	if strings.ContainsAny(current.id, "#+") {
+		_ = writePacket(connection, &packet{kind: connackPacket, body: []byte{0, connectIdentifierInvalid}})
+		return nil, 0, fmt.Errorf("invalid client id %q", current.id)
+	}
+
+	broker.lock.Lock()
+	if previous, found := broker.clients[current.id]; found {
+		previous.will = nil
+		_ = previous.connection.Close()
+	}
+	broker.clients[current.id] = current
+	broker.lock.Unlock()
+
+	return current, keepAlive, current.write(&packet{kind: connackPacket, body: []byte{0, connectAccepted}})
+}
+
+// generatedClientID names a client that left it to the broker
+func generatedClientID(connection net.Conn) string {
+	id := strings.NewReplacer(".", "", ":", "", "[", "", "]", "").Replace(connection.RemoteAddr().String())
+	if len(id) > clientIDBytes {
+		id = id[len(id)-clientIDBytes:]
+	}
+	return "auto-" + id
+}
+
+// receive handles the packets of a connected client, returning nil once it disconnects cleanly
+func (broker *Broker) receive(current *client, reader *bufio.Reader, keepAlive time.Duration) error {
+	for {
+		deadline := time.Time{}
+		if keepAlive > 0 {
+			deadline = time.Now().Add(time.Duration(float64(keepAlive) * keepAliveGrace))
+		}
+		_ = current.connection.SetReadDeadline(deadline)
+
+		next, err := readPacket(reader, MaxPacketSize)
+		if err != nil {
+			return err
+		}
+
+		switch next.kind {
+		case publishPacket:
+			err = broker.receivePublish(current, next)
+		case pubrelPacket:
+			fields := decoder{body: next.body}
+			id := fields.uint16()
+			err = current.write(&packet{kind: pubcompPacket, body: (&encoder{}).uint16(id).body})
+		case subscribePacket:
+			err = broker.subscribe(current, next)
+		case unsubscribePacket:
+			err = broker.unsubscribe(current, next)
+		case pingreqPacket:
+			err = current.write(&packet{kind: pingrespPacket})
+		case disconnectPacket:
+			return nil
+		case pubackPacket, pubrecPacket, pubcompPacket:
+			// delivery is not retried, so there is nothing to acknowledge
+		default:
+			err = fmt.Errorf("%w: unexpected packet type %d", errMalformed, next.kind)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receivePublish acknowledges a message from a client and delivers it to the subscribers
+func (broker *Broker) receivePublish(current *client, next *packet) error {
+	fields := decoder{body: next.body}
+	message := &topics.Message{Topic: fields.string(), QoS: next.flags >> qosShift & qosMask,
+		Retained: next.flags&retainFlag != 0, Received: time.Now()}
+
+	if message.QoS > 0 {
+		message.MessageID = fields.uint16()
+	}
+	message.Payload = append([]byte{}, fields.rest()...)
+
+	if fields.err != nil || strings.ContainsAny(message.Topic, "#+") || len(message.Topic) == 0 {
+		return fmt.Errorf("%w: invalid publish to %q", errMalformed, message.Topic)
+	}
+
+	switch message.QoS {
+	case 1:
+		if err := current.write(&packet{kind: pubackPacket, body: (&encoder{}).uint16(message.MessageID).body}); err != nil {
+			return err
+		}
+	case 2:
+		if err := current.write(&packet{kind: pubrecPacket, body: (&encoder{}).uint16(message.MessageID).body}); err != nil {
+			return err
+		}
+	}
+
+	broker.Publish(message)
+	return nil
+}
+
+// subscribe adds the filters of the client, then sends it the retained messages they match
+func (broker *Broker) subscribe(current *client, next *packet) error {
+	if next.flags != pubrelFlags {
+		return fmt.Errorf("%w: subscribe flags %d", errMalformed, next.flags)
+	}
+
+	fields := decoder{body: next.body}
+	reply := (&encoder{}).uint16(fields.uint16())
+
+	filters := make(map[string]byte)
+	for fields.remaining() > 0 && fields.err == nil {
+		filter := fields.string()
+		qos := fields.byte()
+		if qos > maxQoS {
+			qos = maxQoS
+		}
+
+		if len(filter) == 0 {
+			reply.byte(subackFailure)
+			continue
+		}
+		filters[filter] = qos
+		reply.byte(qos)
+	}
+
+	if fields.err != nil {
+		return fields.err
+	}
+
+	broker.lock.Lock()
+	retained := make([]*topics.Message, 0)
+	for filter, qos := range filters {
+		current.subscriptions[filter] = qos
+		for _, message := range broker.retained {
+			if topics.MatchTopic(filter, message.Topic) {
+				retained = append(retained, message)
+			}
+		}
+	}
+	broker.lock.Unlock()
+
+	if err := current.write(&packet{kind: subackPacket, body: reply.body}); err != nil {
+		return err
+	}
+
+	for _, message := range retained {
+		if err := current.publish(message, message.QoS, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribe removes the filters of the client
+func (broker *Broker) unsubscribe(current *client, next *packet) error {
+	fields := decoder{body: next.body}
+	id := fields.uint16()
+
+	broker.lock.Lock()
+	for fields.remaining() > 0 && fields.err == nil {
+		delete(current.subscriptions, fields.string())
+	}
+	broker.lock.Unlock()
+
+	if fields.err != nil {
+		return fields.err
+	}
+
+	return current.write(&packet{kind: unsubackPacket, body: (&encoder{}).uint16(id).body})
+}
+
+// publish sends the message to the client with the given qos
+func (current *client) publish(message *topics.Message, qos byte, retained bool) error {
+	if qos > maxQoS {
+		qos = maxQoS
+	}
+
+	flags := qos << qosShift
+	if retained {
+		flags |= retainFlag
+	}
+
+	fields := (&encoder{}).string(message.Topic)
+
+	current.writeLock.Lock()
+	defer current.writeLock.Unlock()
+
+	if qos > 0 {
+		current.nextID = current.nextID%maxPacketID + 1
+		fields.uint16(current.nextID)
+	}
+	fields.raw(message.Payload)
+
+	return writePacket(current.connection, &packet{kind: publishPacket, flags: flags, body: fields.body})
+}
+
+// write sends a packet to the client
+func (current *client) write(next *packet) error {
+	current.writeLock.Lock()
+	defer current.writeLock.Unlock()
+
+	return writePacket(current.connection, next)
+}
+
+// writePacket sends a packet, the caller holding the write lock of the connection if it is shared
+func writePacket(connection net.Conn, next *packet) error {
+	_ = connection.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := connection.Write(next.encode())
+	return err
+}
@@ -0,0 +1,188 @@
+// Package broker for a minimal embedded mqtt broker to test and simulate against
+package broker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types of mqtt 3.1.1
+const (
+	connectPacket     = 1
+	connackPacket     = 2
+	publishPacket     = 3
+	pubackPacket      = 4
+	pubrecPacket      = 5
+	pubrelPacket      = 6
+	pubcompPacket     = 7
+	subscribePacket   = 8
+	subackPacket      = 9
+	unsubscribePacket = 10
+	unsubackPacket    = 11
+	pingreqPacket     = 12
+	pingrespPacket    = 13
+	disconnectPacket  = 14
+)
+
+const (
+	// maxLengthBytes is how many bytes the remaining length is encoded in at most
+	maxLengthBytes  = 4
+	lengthBits      = 7
+	lengthMask      = 0x7f
+	continuationBit = 0x80
+
+	// pubrelFlags are the fixed header flags required on pubrel, subscribe and unsubscribe
+	pubrelFlags = 0x02
+	// subackFailure is returned for a subscription that was refused
+	subackFailure = 0x80
+)
+
+// errMalformed is returned for a packet that does not follow the protocol
+var errMalformed = errors.New("malformed packet")
+
+// packet is a control packet as read from or written to a connection
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads the fixed header and the rest of a packet, refusing packets larger than limit
+func readPacket(reader *bufio.Reader, limit int) (*packet, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, multiplier := 0, 1
+	for index := 0; ; index++ {
+		if index == maxLengthBytes {
+			return nil, fmt.Errorf("%w: remaining length too long", errMalformed)
+		}
+
+		next, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		length += int(next&lengthMask) * multiplier
+		multiplier <<= lengthBits
+		if next&continuationBit == 0 {
+			break
+		}
+	}
+
+	if length > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", errMalformed, length, limit)
+	}
+
+	body := make([]byte, length)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	return &packet{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+// encode writes the packet in its wire format
+func (current *packet) encode() []byte {
+	encoded := make([]byte, 0, 1+maxLengthBytes+len(current.body))
+	encoded = append(encoded, current.kind<<4|current.flags)
+
+	length := len(current.body)
+	for {
+		next := byte(length & lengthMask)
+		length >>= lengthBits
+		if length > 0 {
+			next |= continuationBit
+		}
+		encoded = append(encoded, next)
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(encoded, current.body...)
+}
+
+// decoder reads the fields of a packet body in order
+type decoder struct {
+	body   []byte
+	offset int
+	err    error
+}
+
+func (fields *decoder) remaining() int {
+	return len(fields.body) - fields.offset
+}
+
+func (fields *decoder) byte() byte {
+	if fields.err != nil || fields.remaining() < 1 {
+		fields.err = errMalformed
+		return 0
+	}
+	value := fields.body[fields.offset]
+	fields.offset++
+	return value
+}
+
+func (fields *decoder) uint16() uint16 {
+	if fields.err != nil || fields.remaining() < 2 {
+		fields.err = errMalformed
+		return 0
+	}
+	value := binary.BigEndian.Uint16(fields.body[fields.offset:])
+	fields.offset += 2
+	return value
+}
+
+func (fields *decoder) bytes() []byte {
+	length := int(fields.uint16())
+	if fields.err != nil || fields.remaining() < length {
+		fields.err = errMalformed
+		return nil
+	}
+	value := fields.body[fields.offset : fields.offset+length]
+	fields.offset += length
+	return value
+}
+
+func (fields *decoder) string() string {
+	return string(fields.bytes())
+}
+
+// rest returns everything not read yet, the payload of a publish
+func (fields *decoder) rest() []byte {
+	value := fields.body[fields.offset:]
+	fields.offset = len(fields.body)
+	return value
+}
+
+// encoder builds a packet body
+type encoder struct {
+	body []byte
+}
+
+func (fields *encoder) byte(value byte) *encoder {
+	fields.body = append(fields.body, value)
+	return fields
+}
+
+func (fields *encoder) uint16(value uint16) *encoder {
+	fields.body = append(fields.body, byte(value>>8), byte(value))
+	return fields
+}
+
+func (fields *encoder) string(value string) *encoder {
+	fields.uint16(uint16(len(value)))
+	fields.body = append(fields.body, value...)
+	return fields
+}
+
+func (fields *encoder) raw(value []byte) *encoder {
+	fields.body = append(fields.body, value...)
+	return fields
+}
@@ -0,0 +1,175 @@
+// Package certs for loading the certificates used to connect to the broker
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// versions are the minimum tls versions that can be configured
+var versions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Paths are the files the credentials are loaded from, the certificate and key are
+// only needed when the broker requires clients to authenticate with a certificate
+type Paths struct {
+	CA          string
+	Certificate string
+	Key         string
+}
+
+// Credentials hold the broker certificate authorities and client certificate, they can be
+// reloaded while connected and are used from the next connection on
+type Credentials struct {
+	lock        sync.RWMutex
+	paths       Paths
+	roots       *x509.CertPool
+	certificate *tls.Certificate
+}
+
+// ParseVersion converts a configured version such as 1.2 to its tls constant
+func ParseVersion(version string) (uint16, error) {
+	value, found := versions[version]
+	if !found {
+		return 0, fmt.Errorf("unsupported tls version %q, expected 1.2 or 1.3", version)
+	}
+	return value, nil
+}
+
+// Load reads the credentials, failing when a file is missing, invalid or the key does not
+// belong to the certificate
+func Load(paths Paths) (*Credentials, error) {
+	credentials := &Credentials{paths: paths}
+	if err := credentials.Reload(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// Reload reads the credentials again, on failure the previous ones remain in use
+func (credentials *Credentials) Reload() error {
+	roots, err := loadRoots(credentials.paths.CA)
+	if err != nil {
+		return err
+	}
+
+	certificate, err := loadCertificate(credentials.paths.Certificate, credentials.paths.Key)
+	if err != nil {
+		return err
+	}
+
+	credentials.lock.Lock()
+	defer credentials.lock.Unlock()
+
+	credentials.roots = roots
+	credentials.certificate = certificate
+
+	return nil
+}
+
+// loadRoots reads the pem bundle of certificate authorities, the system pool is used without one
+func loadRoots(path string) (*x509.CertPool, error) {
+	if len(path) == 0 {
+		return x509.SystemCertPool()
+	}
+
+	bundle, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read broker ca bundle: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("broker ca bundle %s contains no pem certificates", path)
+	}
+	return roots, nil
+}
+
+// loadCertificate reads the client certificate and its key, both or neither have to be configured
+func loadCertificate(certificatePath, keyPath string) (*tls.Certificate, error) {
+	switch {
+	case len(certificatePath) == 0 && len(keyPath) == 0:
+		return nil, nil
+	case len(certificatePath) == 0, len(keyPath) == 0:
+		return nil, errors.New("broker client certificate and key have to be configured together")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(filepath.Clean(certificatePath), filepath.Clean(keyPath))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load broker client certificate %s with key %s: %v", certificatePath, keyPath, err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker client certificate %s: %v", certificatePath, err)
+	}
+
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("broker client certificate %s expired on %s", certificatePath, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	certificate.Leaf = leaf
+	return &certificate, nil
+}
+
+// Config returns the tls configuration for connecting to serverName, a host name or ip address.
+// the credentials are looked up on every handshake so a reload applies without rebuilding it
+func (credentials *Credentials) Config(serverName string, minVersion uint16) *tls.Config {
+	return &tls.Config{
+		ServerName: serverName,
+		MinVersion: minVersion,
+		// the chain is verified in verifyConnection against the current roots instead
+		InsecureSkipVerify: true, // #nosec G402
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return credentials.verifyConnection(state, serverName)
+		},
+		GetClientCertificate: credentials.clientCertificate,
+	}
+}
+
+// verifyConnection performs the verification the standard library would, using the current roots.
+// the configured server name is checked rather than the one in the state, which is empty for an ip
+func (credentials *Credentials) verifyConnection(state tls.ConnectionState, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("broker presented no certificate")
+	}
+
+	if len(serverName) == 0 {
+		return errors.New("no broker host name to verify the certificate against")
+	}
+
+	credentials.lock.RLock()
+	roots := credentials.roots
+	credentials.lock.RUnlock()
+
+	options := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, intermediate := range state.PeerCertificates[1:] {
+		options.Intermediates.AddCert(intermediate)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(options)
+	return err
+}
+
+// clientCertificate offers the current client certificate, or none when it is not configured
+func (credentials *Credentials) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	credentials.lock.RLock()
+	defer credentials.lock.RUnlock()
+
+	if credentials.certificate == nil {
+		return &tls.Certificate{}, nil
+	}
+	return credentials.certificate, nil
+}
@@ -0,0 +1,317 @@
+// Package certs for loading the certificates used to connect to the broker
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// authority is a certificate authority generated for a test
+type authority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+}
+
+var serial int64
+
+// newAuthority generates a self signed certificate authority
+func newAuthority(t *testing.T, name string) *authority {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &authority{certificate: certificate, key: key,
+		pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a certificate for the names, returning its certificate and key as pem
+func (ca *authority) issue(t *testing.T, usage x509.ExtKeyUsage, notAfter time.Time, names ...string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// write stores the contents in the directory, returning its path
+func write(t *testing.T, directory, name string, contents []byte) string {
+	t.Helper()
+
+	path := filepath.Join(directory, name)
+	if err := ioutil.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startBroker runs a tls server that requires a client certificate from the authority and
+// writes a byte to every client it accepts
+func startBroker(t *testing.T, ca *authority, certificate, key []byte) string {
+	t.Helper()
+
+	pair, err := tls.X509KeyPair(certificate, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := x509.NewCertPool()
+	clients.AddCert(ca.certificate)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer connection.Close()
+				if connection.(*tls.Conn).Handshake() == nil {
+					_, _ = connection.Write([]byte{1})
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// dial connects to the broker with the credentials, verifying it as serverName
+func dial(credentials *Credentials, address, serverName string) error {
+	connection, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", address,
+		credentials.Config(serverName, tls.VersionTLS12))
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	// the server only rejects a client certificate after the client has finished its handshake
+	_ = connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = connection.Read(make([]byte, 1))
+	return err
+}
+
+func TestVerification(t *testing.T) {
+	directory := t.TempDir()
+
+	ca := newAuthority(t, "test ca")
+	other := newAuthority(t, "other ca")
+
+	serverCertificate, serverKey := ca.issue(t, x509.ExtKeyUsageServerAuth, time.Now().Add(time.Hour), "broker.local", "127.0.0.1")
+	address := startBroker(t, ca, serverCertificate, serverKey)
+
+	clientCertificate, clientKey := ca.issue(t, x509.ExtKeyUsageClientAuth, time.Now().Add(time.Hour), "site")
+	untrustedCertificate, untrustedKey := other.issue(t, x509.ExtKeyUsageClientAuth, time.Now().Add(time.Hour), "site")
+
+	caPath := write(t, directory, "ca.pem", ca.pem)
+	otherPath := write(t, directory, "other.pem", other.pem)
+	clientPaths := Paths{CA: caPath, Certificate: write(t, directory, "client.pem", clientCertificate),
+		Key: write(t, directory, "client.key", clientKey)}
+
+	tests := []struct {
+		name       string
+		paths      Paths
+		serverName string
+		accepted   bool
+	}{
+		{"host name", clientPaths, "broker.local", true},
+		{"ip address", clientPaths, "127.0.0.1", true},
+		{"wrong host name", clientPaths, "other.local", false},
+		{"wrong ip address", clientPaths, "127.0.0.2", false},
+		{"no host name", clientPaths, "", false},
+		{"untrusted broker", Paths{CA: otherPath, Certificate: clientPaths.Certificate, Key: clientPaths.Key}, "broker.local", false},
+		{"no client certificate", Paths{CA: caPath}, "broker.local", false},
+		{"untrusted client certificate", Paths{CA: caPath, Certificate: write(t, directory, "untrusted.pem", untrustedCertificate),
+			Key: write(t, directory, "untrusted.key", untrustedKey)}, "broker.local", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			credentials, err := Load(test.paths)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = dial(credentials, address, test.serverName)
+			if test.accepted && err != nil {
+				t.Errorf("expected the connection to be accepted: %v", err)
+			}
+			if !test.accepted && err == nil {
+				t.Error("expected the connection to be rejected")
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	directory := t.TempDir()
+
+	ca := newAuthority(t, "test ca")
+	other := newAuthority(t, "other ca")
+
+	serverCertificate, serverKey := ca.issue(t, x509.ExtKeyUsageServerAuth, time.Now().Add(time.Hour), "broker.local")
+	address := startBroker(t, ca, serverCertificate, serverKey)
+
+	clientCertificate, clientKey := ca.issue(t, x509.ExtKeyUsageClientAuth, time.Now().Add(time.Hour), "site")
+	caPath := write(t, directory, "ca.pem", other.pem)
+	paths := Paths{CA: caPath, Certificate: write(t, directory, "client.pem", clientCertificate),
+		Key: write(t, directory, "client.key", clientKey)}
+
+	credentials, err := Load(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dial(credentials, address, "broker.local") == nil {
+		t.Fatal("expected the broker to be untrusted before the reload")
+	}
+
+	write(t, directory, "ca.pem", ca.pem)
+	if err = credentials.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err = dial(credentials, address, "broker.local"); err != nil {
+		t.Errorf("expected the broker to be trusted after the reload: %v", err)
+	}
+
+	// a failed reload keeps the previous credentials
+	write(t, directory, "ca.pem", []byte("not a certificate"))
+	if credentials.Reload() == nil {
+		t.Error("expected the reload of an invalid bundle to fail")
+	}
+	if err = dial(credentials, address, "broker.local"); err != nil {
+		t.Errorf("expected the previous credentials to remain in use: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	directory := t.TempDir()
+
+	ca := newAuthority(t, "test ca")
+	certificate, key := ca.issue(t, x509.ExtKeyUsageClientAuth, time.Now().Add(time.Hour), "site")
+	_, otherKey := ca.issue(t, x509.ExtKeyUsageClientAuth, time.Now().Add(time.Hour), "other")
+	expired, expiredKey := ca.issue(t, x509.ExtKeyUsageClientAuth, time.Now().Add(-time.Minute), "site")
+
+	caPath := write(t, directory, "ca.pem", ca.pem)
+	certificatePath := write(t, directory, "client.pem", certificate)
+	keyPath := write(t, directory, "client.key", key)
+
+	tests := []struct {
+		name  string
+		paths Paths
+		valid bool
+	}{
+		{"complete", Paths{CA: caPath, Certificate: certificatePath, Key: keyPath}, true},
+		{"ca only", Paths{CA: caPath}, true},
+		{"missing ca", Paths{CA: filepath.Join(directory, "missing.pem")}, false},
+		{"ca without certificates", Paths{CA: write(t, directory, "empty.pem", []byte("empty"))}, false},
+		{"certificate without key", Paths{CA: caPath, Certificate: certificatePath}, false},
+		{"key without certificate", Paths{CA: caPath, Key: keyPath}, false},
+		{"key of another certificate", Paths{CA: caPath, Certificate: certificatePath,
+			Key: write(t, directory, "other.key", otherKey)}, false},
+		{"expired certificate", Paths{CA: caPath, Certificate: write(t, directory, "expired.pem", expired),
+			Key: write(t, directory, "expired.key", expiredKey)}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Load(test.paths)
+			if test.valid && err != nil {
+				t.Errorf("expected the credentials to load: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Error("expected loading the credentials to fail")
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for version, expected := range map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13} {
+		if value, err := ParseVersion(version); err != nil || value != expected {
+			t.Errorf("ParseVersion(%q) = %d, %v", version, value, err)
+		}
+	}
+
+	for _, version := range []string{"", "1.0", "1.1", "tls1.2"} {
+		if _, err := ParseVersion(version); err == nil {
+			t.Errorf("expected ParseVersion(%q) to fail", version)
+		}
+	}
+}
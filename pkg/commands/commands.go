@@ -0,0 +1,189 @@
+// Package commands for sending commands to devices and waiting on their acknowledgement
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"site/pkg/topics"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Command types a device understands
+const (
+	// Capture asks the device to take an image immediately
+	Capture = "capture"
+	// Reboot asks the device to restart
+	Reboot = "reboot"
+	// ApplySettings pushes settings, the arguments are the settings object
+	ApplySettings = "apply_settings"
+	// StartVideo asks the device to begin recording video
+	StartVideo = "start_video"
+	// StopVideo asks the device to stop recording and send the video
+	StopVideo = "stop_video"
+)
+
+// Acknowledgement states reported by devices
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+const (
+	correlationIDLength = 16
+)
+
+// Types lists every command type that can be sent
+var Types = []string{Capture, Reboot, ApplySettings, StartVideo, StopVideo}
+
+// Command errors
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrCommandTimeout = errors.New("device did not acknowledge the command in time")
+)
+
+// Command is published to afm/v1/cmd/<device_id>
+type Command struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	Issued time.Time       `json:"issued"`
+}
+
+// Ack is published by the device to afm/v1/ack/<device_id> once it has handled a command
+type Ack struct {
+	ID      string          `json:"id"`
+	Status  string          `json:"status"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// pending is a command waiting on its acknowledgement
+type pending struct {
+	deviceID string
+	acks     chan *Ack
+}
+
+// Dispatcher publishes commands and matches the acknowledgements that come back to them
+type Dispatcher struct {
+	lock    sync.Mutex
+	pending map[string]*pending
+	publish topics.Publisher
+	timeout time.Duration
+}
+
+// NewDispatcher creates a dispatcher publishing through publish that waits up to timeout for acknowledgements
+func NewDispatcher(publish topics.Publisher, timeout time.Duration) *Dispatcher {
+	return &Dispatcher{pending: make(map[string]*pending), publish: publish, timeout: timeout}
+}
+
+// CommandTopic returns the topic commands for the device are published on
+func CommandTopic(deviceID string) string {
+	return topics.TopicPrefix + "/" + topics.CommandTopic + "/" + deviceID
+}
+
+// validate ensures the command is one the device understands with usable arguments
+func validate(commandType string, args json.RawMessage) error {
+	known := false
+	for _, candidate := range Types {
+		known = known || candidate == commandType
+	}
+
+	switch {
+	case !known:
+		return fmt.Errorf("%w: %q", ErrUnknownCommand, commandType)
+	case len(args) > 0 && !json.Valid(args):
+		return fmt.Errorf("arguments for %s are not valid json", commandType)
+	case commandType == ApplySettings:
+		settings := map[string]interface{}{}
+		if err := json.Unmarshal(args, &settings); err != nil {
+			return fmt.Errorf("%s requires a settings object: %v", ApplySettings, err)
+		}
+	}
+	return nil
+}
+
+func newCorrelationID() (string, error) {
+	identifier := make([]byte, correlationIDLength)
+	if _, err := rand.Read(identifier); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(identifier), nil
+}
+
+// Send publishes the command to the device and waits for it to be acknowledged, the
+// command is returned along with the ack so its correlation id is known even on timeout
+func (dispatcher *Dispatcher) Send(ctx context.Context, deviceID, commandType string, args json.RawMessage) (*Command, *Ack, error) {
+	if err := validate(commandType, args); err != nil {
+		return nil, nil, err
+	}
+
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	command := &Command{ID: correlationID, Type: commandType, Args: args, Issued: time.Now().UTC()}
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	waiting := &pending{deviceID: deviceID, acks: make(chan *Ack, 1)}
+	dispatcher.lock.Lock()
+	dispatcher.pending[correlationID] = waiting
+	dispatcher.lock.Unlock()
+
+	defer func() {
+		dispatcher.lock.Lock()
+		delete(dispatcher.pending, correlationID)
+		dispatcher.lock.Unlock()
+	}()
+
+	dispatcher.publish(CommandTopic(deviceID), payload)
+
+	ctx, cancel := context.WithTimeout(ctx, dispatcher.timeout)
+	defer cancel()
+
+	select {
+	case ack := <-waiting.acks:
+		return command, ack, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return command, nil, fmt.Errorf("%w: %s to %s", ErrCommandTimeout, commandType, deviceID)
+		}
+		return command, nil, ctx.Err()
+	}
+}
+
+// Acknowledge hands an acknowledgement from the device to the command waiting on it
+func (dispatcher *Dispatcher) Acknowledge(deviceID string, payload []byte) error {
+	ack := &Ack{}
+	if err := json.Unmarshal(payload, ack); err != nil {
+		return fmt.Errorf("invalid acknowledgement from %s: %v", deviceID, err)
+	}
+
+	dispatcher.lock.Lock()
+	waiting, found := dispatcher.pending[ack.ID]
+	dispatcher.lock.Unlock()
+
+	// a device can only acknowledge the commands sent to it. the rest are late, answer another
+	// dispatcher such as the device command or come from a replay, none of which is an error
+	if !found || waiting.deviceID != deviceID {
+		logrus.Debugf("ignoring acknowledgement from %s for unknown command %q", deviceID, ack.ID)
+		return nil
+	}
+
+	select {
+	case waiting.acks <- ack:
+	default:
+		// already acknowledged, the first one wins
+	}
+	return nil
+}
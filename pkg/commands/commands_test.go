@@ -0,0 +1,64 @@
+// Package commands for sending commands to devices and waiting on their acknowledgement
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcknowledge(t *testing.T) {
+	var dispatcher *Dispatcher
+	dispatcher = NewDispatcher(func(topic string, payload []byte) {
+		command := Command{}
+		if err := json.Unmarshal(payload, &command); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// another device cannot answer for the one commanded
+		reply, _ := json.Marshal(&Ack{ID: command.ID, Status: StatusError})
+		if err := dispatcher.Acknowledge("cam2", reply); err != nil {
+			t.Errorf("expected an ack for another device to be ignored: %v", err)
+		}
+
+		reply, _ = json.Marshal(&Ack{ID: command.ID, Status: StatusOK})
+		go func() {
+			if err := dispatcher.Acknowledge("cam1", reply); err != nil {
+				t.Error(err)
+			}
+		}()
+	}, time.Second)
+
+	command, ack, err := dispatcher.Send(context.Background(), "cam1", Capture, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.ID != command.ID || ack.Status != StatusOK {
+		t.Errorf("expected the ack from cam1, got %+v", ack)
+	}
+
+	// the command is no longer waiting, so a repeated ack is late rather than an error
+	late, _ := json.Marshal(&Ack{ID: command.ID, Status: StatusOK})
+	if err = dispatcher.Acknowledge("cam1", late); err != nil {
+		t.Errorf("expected a late ack to be ignored: %v", err)
+	}
+
+	if err = dispatcher.Acknowledge("cam1", []byte("not json")); err == nil {
+		t.Error("expected a malformed ack to be rejected")
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	dispatcher := NewDispatcher(func(topic string, payload []byte) {}, 10*time.Millisecond)
+
+	if _, _, err := dispatcher.Send(context.Background(), "cam1", Reboot, nil); !errors.Is(err, ErrCommandTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	if _, _, err := dispatcher.Send(context.Background(), "cam1", "explode", nil); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("expected an unknown command, got %v", err)
+	}
+}
@@ -2,54 +2,82 @@
 package database
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
 )
 
-const (
-	specificItemLoad = "select * from %s where id=%d"
-	createItem       = "insert into %s (%s) values (%s)"
-	updateItem       = "update %s set %s"
-	updateManyItems  = "update %s set %s where %s"
-	deleteItem       = "delete from %s where id=%d"
-	queryMany        = "select * from %s where %s"
-)
+const insertStatement = "insert into %s (%s) values (%s)"
+
+// ErrNotFound is returned when a requested item does not exist in the database
+var ErrNotFound = errors.New("item not found")
+
+// ErrTimeout is returned when a database operation did not complete within its timeout
+var ErrTimeout = errors.New("database operation timed out")
+
+// operationTimeout bounds each individual database operation, zero leaves it to the caller's context
+var operationTimeout time.Duration
+
+// Executor is anything a database object can be read from or written to,
+// either the database itself or a transaction on it
+type Executor interface {
+	sqlx.ExtContext
+}
 
 // Access interface for working with database objects
 type Access interface {
-	Populate(rows *sqlx.Rows) error
-	Load(database *sqlx.DB) error
-	LoadByField(database *sqlx.DB, field string) error
-	Create(database *sqlx.DB) error
-	Update(database *sqlx.DB) error
-	UpdateMany(database *sqlx.DB, values, criteria map[string]string) error
-	Remove(database *sqlx.DB) error
-	Query(database *sqlx.DB, criteria map[string]string) *[]Access
+	Load(ctx context.Context, database Executor) error
+	LoadByField(ctx context.Context, database Executor, field string) error
+	Create(ctx context.Context, database Executor) error
+	Update(ctx context.Context, database Executor) error
+	UpdateMany(ctx context.Context, database Executor, values, criteria map[string]string) error
+	Remove(ctx context.Context, database Executor) error
 }
 
-func getValues(values map[string]string) string {
-	first := true
-	valueUpdates := ""
-	for k, v := range values {
-		if first {
-			first = false
-		} else {
-			valueUpdates += ","
-		}
-		valueUpdates += k + "='" + v + "'"
+// SetOperationTimeout sets how long any single database operation may take
+func SetOperationTimeout(timeout time.Duration) {
+	operationTimeout = timeout
+}
+
+func operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if operationTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	return valueUpdates
+	return context.WithTimeout(ctx, operationTimeout)
 }
 
-func getCriteria(criteria map[string]string) string {
-	first := true
-	restrictions := ""
-	for k, v := range criteria {
-		if first {
-			first = false
-		} else {
-			restrictions += " and "
-		}
-		restrictions += k + "='" + v + "'"
+// wrapError marks failures caused by an expired deadline with ErrTimeout so callers can retry them
+func wrapError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return err
+}
+
+// IsTransient reports whether a failed operation may succeed when retried, as when it timed out
+// or the database was unreachable or busy
+func IsTransient(err error) bool {
+	var netErr net.Error
+	var sqliteErr sqlite3.Error
+
+	switch {
+	case errors.Is(err, ErrTimeout), errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn):
+		return true
+	case errors.As(err, &netErr):
+		return true
+	case errors.As(err, &sqliteErr):
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
 	}
-	return restrictions
+	return false
 }
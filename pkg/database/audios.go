@@ -0,0 +1,64 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// AudioObject for audio clips that will come from a database, a clip recorded
+// alongside a capture refers to its image or video, otherwise those are 0
+type AudioObject struct {
+	_          struct{}  `table:"audios"`
+	ID         int       `db:"id" access:"pk"`
+	UserID     int       `db:"user_id" access:"insert,update"`
+	DeviceID   int       `db:"device_id" access:"insert,update,lookup"`
+	Path       string    `db:"path" access:"insert,update"`
+	Name       string    `db:"name" access:"insert,update"`
+	Format     string    `db:"format" access:"insert,update"`
+	DurationMS int64     `db:"duration_ms" access:"insert,update"`
+	Size       int64     `db:"size" access:"insert,update"`
+	ImageID    int       `db:"image_id" access:"insert,update"`
+	VideoID    int       `db:"video_id" access:"insert,update"`
+	Created    time.Time `db:"created"`
+	Active     int       `db:"active" access:"insert,update"`
+}
+
+// Load the audio object from the database
+func (audio *AudioObject) Load(ctx context.Context, database Executor) error {
+	return loadItem(ctx, database, audio)
+}
+
+// LoadByField loads an audio clip by its device id
+func (audio *AudioObject) LoadByField(ctx context.Context, database Executor, field string) error {
+	return loadItemByLookup(ctx, database, audio, field)
+}
+
+// Create adds the item to the database, returning an error if failure
+func (audio *AudioObject) Create(ctx context.Context, database Executor) error {
+	audio.Active = activeValue
+	return createItem(ctx, database, audio)
+}
+
+// Update the item in the database, returning an error if failure
+func (audio *AudioObject) Update(ctx context.Context, database Executor) error {
+	return updateItem(ctx, database, audio)
+}
+
+// UpdateMany items in the database using specified criteria
+func (audio *AudioObject) UpdateMany(ctx context.Context, database Executor, values, criteria map[string]string) error {
+	return updateManyItems(ctx, database, audio, values, criteria)
+}
+
+// Remove the item from the database, returning an error if failure
+func (audio *AudioObject) Remove(ctx context.Context, database Executor) error {
+	return removeItem(ctx, database, audio)
+}
+
+// Query the audio clips matching the criteria from the database
+func (audio *AudioObject) Query(ctx context.Context, database Executor, criteria map[string]string) ([]AudioObject, error) {
+	audios := make([]AudioObject, 0)
+	err := queryItems(ctx, database, &audios, criteria)
+
+	return audios, err
+}
@@ -0,0 +1,59 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// DeadLetterObject for a device message that could not be processed
+type DeadLetterObject struct {
+	_           struct{}  `table:"dead_letters"`
+	ID          int       `db:"id" access:"pk"`
+	Topic       string    `db:"topic" access:"insert,update,lookup"`
+	Payload     []byte    `db:"payload" access:"insert,update"`
+	Error       string    `db:"error" access:"insert,update"`
+	Attempts    int       `db:"attempts" access:"insert,update"`
+	Created     time.Time `db:"created"`
+	LastAttempt time.Time `db:"last_attempt" access:"insert,update"`
+	Active      int       `db:"active" access:"insert,update"`
+}
+
+// Load the dead letter object from the database
+func (letter *DeadLetterObject) Load(ctx context.Context, database Executor) error {
+	return loadItem(ctx, database, letter)
+}
+
+// LoadByField loads a dead letter by its topic
+func (letter *DeadLetterObject) LoadByField(ctx context.Context, database Executor, field string) error {
+	return loadItemByLookup(ctx, database, letter, field)
+}
+
+// Create adds the item to the database, returning an error if failure
+func (letter *DeadLetterObject) Create(ctx context.Context, database Executor) error {
+	letter.Active = activeValue
+	return createItem(ctx, database, letter)
+}
+
+// Update the item in the database, returning an error if failure
+func (letter *DeadLetterObject) Update(ctx context.Context, database Executor) error {
+	return updateItem(ctx, database, letter)
+}
+
+// UpdateMany items in the database using specified criteria
+func (letter *DeadLetterObject) UpdateMany(ctx context.Context, database Executor, values, criteria map[string]string) error {
+	return updateManyItems(ctx, database, letter, values, criteria)
+}
+
+// Remove the item from the database, returning an error if failure
+func (letter *DeadLetterObject) Remove(ctx context.Context, database Executor) error {
+	return removeItem(ctx, database, letter)
+}
+
+// Query the dead letters matching the criteria from the database
+func (letter *DeadLetterObject) Query(ctx context.Context, database Executor, criteria map[string]string) ([]DeadLetterObject, error) {
+	letters := make([]DeadLetterObject, 0)
+	err := queryItems(ctx, database, &letters, criteria)
+
+	return letters, err
+}
@@ -2,154 +2,79 @@
 package database
 
 import (
-	"fmt"
-
-	"github.com/jmoiron/sqlx"
-	"github.com/sirupsen/logrus"
+	"context"
+	"database/sql"
 )
 
+// Device provisioning states
 const (
-	devicesTableName          = "devices"
-	devicesFieldSpecificQuery = "select * from %s where serial='%s'"
+	// DevicePending is a device that has registered but not been claimed by a user
+	DevicePending = "pending"
+	// DeviceClaimed is a device that belongs to a user
+	DeviceClaimed = "claimed"
 )
 
 // DeviceObject for devices that will come from a database
 type DeviceObject struct {
-	ID       int    `db:"id"`
-	Model    string `db:"model"`
-	Serial   string `db:"serial"`
-	Firmware string `db:"firmware"`
-	Active   int    `db:"active"`
+	_             struct{}     `table:"devices"`
+	ID            int          `db:"id" access:"pk"`
+	Model         string       `db:"model" access:"insert,update"`
+	Serial        string       `db:"serial" access:"insert,update,lookup"`
+	Firmware      string       `db:"firmware" access:"insert,update"`
+	State         string       `db:"state" access:"insert,update"`
+	ClaimCode     string       `db:"claim_code" access:"insert,update"`
+	ClaimAttempts int          `db:"claim_attempts" access:"insert,update"`
+	ClaimExpires  sql.NullTime `db:"claim_expires" access:"insert,update"`
+	Online        int          `db:"online" access:"insert,update"`
+	LastSeen      sql.NullTime `db:"last_seen" access:"insert,update"`
+	IP            string       `db:"ip" access:"insert,update"`
+	Active        int          `db:"active" access:"insert,update"`
 }
 
-// Populate populates the device object with the data from database row
-func (device *DeviceObject) Populate(rows *sqlx.Rows) error {
-	if rows.Next() {
-		err := rows.StructScan(device)
-		if err != nil {
-			logrus.Warnf("failed scanning results: %v", err)
-		}
-	} else {
-		err := rows.Err()
-		if err != nil {
-			return fmt.Errorf("failed to find any results - error: %v", err)
-		}
-	}
-	return nil
+// Load the device object from the database
+func (device *DeviceObject) Load(ctx context.Context, database Executor) error {
+	return loadItem(ctx, database, device)
 }
 
-// Load the device object from the database response
-func (device *DeviceObject) Load(database *sqlx.DB) error {
-	query := fmt.Sprintf(specificItemLoad, devicesTableName, device.ID)
-	results, err := database.Queryx(query)
-
-	if err != nil {
-		return err
-	}
-
-	err = device.Populate(results)
-	if err != nil {
-		logrus.Warnf("failed populating data: %v", err)
-	}
-
-	err = results.Close()
-	if err != nil {
-		logrus.Warnf("failed closing results: %v", err)
-	}
-	return nil
+// LoadByField loads a device by its serial number
+func (device *DeviceObject) LoadByField(ctx context.Context, database Executor, field string) error {
+	return loadItemByLookup(ctx, database, device, field)
 }
 
-// LoadByField loads an object by a specific device field know to said object
-func (device *DeviceObject) LoadByField(database *sqlx.DB, field string) error {
-	query := fmt.Sprintf(devicesFieldSpecificQuery, devicesTableName, field)
-	results, err := database.Queryx(query)
-
-	if err != nil {
-		return err
+// Create adds the device item to the database, pending unless a state is given, returning an error if failure
+func (device *DeviceObject) Create(ctx context.Context, database Executor) error {
+	device.Active = activeValue
+	if len(device.State) == 0 {
+		device.State = DevicePending
 	}
-
-	err = device.Populate(results)
-	if err != nil {
-		logrus.Warnf("failed populating data: %v", err)
-	}
-
-	err = results.Close()
-	if err != nil {
-		logrus.Warnf("failed closing results: %v", err)
-	}
-	return nil
-}
-
-// Create adds the device item to the database, returning an error if failure
-func (device *DeviceObject) Create(database *sqlx.DB) error {
-	values := fmt.Sprintf("'%s','%s','%s',1", device.Model, device.Serial, device.Firmware)
-	query := fmt.Sprintf(createItem, devicesTableName, "model,serial,firmware,active", values)
-
-	result, err := database.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	nextID, err := result.LastInsertId()
-
-	if err != nil {
-		return err
-	}
-
-	device.ID = int(nextID)
-
-	return nil
+	return createItem(ctx, database, device)
 }
 
 // Update the device item in the database, returning an error if failure
-func (device *DeviceObject) Update(database *sqlx.DB) error {
-	values := fmt.Sprintf("model='%s',serial='%s',firmware='%s',active=%d", device.Model, device.Serial, device.Firmware, device.Active)
-	query := fmt.Sprintf(updateItem, devicesTableName, values)
-
-	_, err := database.Exec(query)
+func (device *DeviceObject) Update(ctx context.Context, database Executor) error {
+	return updateItem(ctx, database, device)
+}
 
-	return err
+// UpdateIf updates the device item only while its row still matches the criteria, ErrNotFound
+// when it no longer does
+func (device *DeviceObject) UpdateIf(ctx context.Context, database Executor, criteria map[string]string) error {
+	return updateItemIf(ctx, database, device, criteria)
 }
 
 // UpdateMany device items in the database using specified criteria
-func (device *DeviceObject) UpdateMany(database *sqlx.DB, values, criteria map[string]string) error {
-	valueUpdates := getValues(values)
-	restrictions := getCriteria(criteria)
-
-	query := fmt.Sprintf(updateManyItems, devicesTableName, valueUpdates, restrictions)
-
-	_, err := database.Exec(query)
-
-	return err
+func (device *DeviceObject) UpdateMany(ctx context.Context, database Executor, values, criteria map[string]string) error {
+	return updateManyItems(ctx, database, device, values, criteria)
 }
 
 // Remove the device item from the database, returning an error if failure
-func (device *DeviceObject) Remove(database *sqlx.DB) error {
-	query := fmt.Sprintf(deleteItem, devicesTableName, device.ID)
-
-	_, err := database.Exec(query)
-
-	return err
+func (device *DeviceObject) Remove(ctx context.Context, database Executor) error {
+	return removeItem(ctx, database, device)
 }
 
-// Query the items from the database, returning an nil if failure
-func (device *DeviceObject) Query(database *sqlx.DB, criteria map[string]string) *[]Access {
-	objects := make([]Access, 0)
-	restrictions := getCriteria(criteria)
-
-	query := fmt.Sprintf(queryMany, devicesTableName, restrictions)
-
-	results, err := database.Queryx(query)
-	if err != nil {
-		return nil
-	}
-	for results.Next() {
-		var device = DeviceObject{}
-		err = results.StructScan(&device)
-		if err == nil {
-			objects = append(objects, &device)
-		}
-	}
+// Query the devices matching the criteria from the database
+func (device *DeviceObject) Query(ctx context.Context, database Executor, criteria map[string]string) ([]DeviceObject, error) {
+	devices := make([]DeviceObject, 0)
+	err := queryItems(ctx, database, &devices, criteria)
 
-	return &objects
+	return devices, err
 }
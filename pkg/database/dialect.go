@@ -0,0 +1,102 @@
+// Package database for all database assets
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	// Pulling in the database drivers for each supported dialect
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// MySQLDialect is the database type for a mysql or mariadb server
+	MySQLDialect = "mysql"
+	// SQLiteDialect is the database type for a local sqlite file
+	SQLiteDialect = "sqlite"
+
+	primaryKeyToken = "{{primarykey}}"
+	blobToken       = "{{blob}}"
+)
+
+// Dialect captures the differences between the supported database engines
+type Dialect struct {
+	name       string
+	driverName string
+	// namedDatabases is true when the server hosts several databases that must be created before use
+	namedDatabases bool
+	types          *strings.Replacer
+}
+
+var dialects = []*Dialect{
+	{
+		name:           MySQLDialect,
+		driverName:     "mysql",
+		namedDatabases: true,
+		types: strings.NewReplacer(
+			primaryKeyToken, "int not null auto_increment primary key",
+			blobToken, "longblob",
+		),
+	},
+	{
+		name:       SQLiteDialect,
+		driverName: "sqlite3",
+		types: strings.NewReplacer(
+			primaryKeyToken, "integer primary key autoincrement",
+			blobToken, "blob",
+		),
+	},
+}
+
+// LookupDialect finds the dialect for a configured database type
+func LookupDialect(name string) (*Dialect, error) {
+	for _, dialect := range dialects {
+		if dialect.name == name {
+			return dialect, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported database type: %q", name)
+}
+
+// dialectOf finds the dialect for an open database using its driver
+func dialectOf(database *sqlx.DB) (*Dialect, error) {
+	for _, dialect := range dialects {
+		if dialect.driverName == database.DriverName() {
+			return dialect, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported database driver: %q", database.DriverName())
+}
+
+// Name returns the configured name of the dialect
+func (dialect *Dialect) Name() string {
+	return dialect.name
+}
+
+// Open connects to the database using the dialect's driver
+func (dialect *Dialect) Open(dataSource string) (*sqlx.DB, error) {
+	return sqlx.Open(dialect.driverName, dataSource)
+}
+
+// CreateDatabase creates the named database if the engine requires it and it is missing
+func (dialect *Dialect) CreateDatabase(database *sqlx.DB, name string) error {
+	if !dialect.namedDatabases {
+		return nil
+	}
+
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("invalid database name: %q", name)
+	}
+
+	_, err := database.Exec("create database if not exists " + name)
+
+	return err
+}
+
+// Translate replaces the dialect specific tokens within a statement
+func (dialect *Dialect) Translate(statement string) string {
+	return dialect.types.Replace(statement)
+}
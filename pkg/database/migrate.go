@@ -0,0 +1,269 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	migrationsTableName = "schema_migrations"
+	createMigrations    = "create table if not exists " + migrationsTableName + " (" +
+		"version int not null primary key," +
+		"description varchar(255)," +
+		"applied_at datetime default current_timestamp)"
+	migrationVersionField = "version"
+)
+
+// alterColumn matches the statements adding or dropping a column, which are skipped when the
+// column is already there or already gone
+var alterColumn = regexp.MustCompile(`(?i)^\s*alter\s+table\s+(\w+)\s+(add|drop)\s+column\s+(\w+)`)
+
+// Migration is a single versioned step in the schema, each up statement
+// is reverted by the down statements.
+//
+// The statements run in a transaction, but mysql commits every create, alter and drop as it
+// runs, so on mysql a migration failing part way through leaves the statements before the
+// failure applied. Every statement must therefore be safe to run again: tables are created and
+// dropped with if not exists and if exists, columns added or dropped one per statement, which
+// is skipped when already done, and updates must leave rows as they were when repeated.
+type Migration struct {
+	Version     int
+	Description string
+	Up          []string
+	Down        []string
+}
+
+// MigrationState describes whether a migration has been applied to the database
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// appliedMigration is the record kept in the migrations table
+type appliedMigration struct {
+	Version     int       `db:"version"`
+	Description string    `db:"description"`
+	AppliedAt   time.Time `db:"applied_at"`
+}
+
+// LatestMigration returns the newest known migration version
+func LatestMigration() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func loadAppliedMigrations(database *sqlx.DB) (map[int]appliedMigration, error) {
+	_, err := database.Exec(createMigrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %v", err)
+	}
+
+	query, arguments, err := NewQueryBuilder(migrationsTableName).OrderBy(migrationVersionField, false).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]appliedMigration, 0)
+	err = database.Select(&records, query, arguments...)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// CurrentMigration returns the version of the most recently applied migration, 0 if none
+func CurrentMigration(database *sqlx.DB) (int, error) {
+	applied, err := loadAppliedMigrations(database)
+	if err != nil {
+		return 0, err
+	}
+
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current, nil
+}
+
+// MigrationStatus reports every known migration and if it has been applied
+func MigrationStatus(database *sqlx.DB) ([]MigrationState, error) {
+	applied, err := loadAppliedMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, len(migrations))
+	for index, migration := range migrations {
+		record, found := applied[migration.Version]
+		states[index] = MigrationState{Migration: migration, Applied: found, AppliedAt: record.AppliedAt}
+	}
+	return states, nil
+}
+
+// MigrateUp applies all pending migrations
+func MigrateUp(database *sqlx.DB) error {
+	return MigrateTo(database, LatestMigration())
+}
+
+// MigrateDown reverts the most recently applied migration
+func MigrateDown(database *sqlx.DB) error {
+	current, err := CurrentMigration(database)
+	if err != nil {
+		return err
+	}
+
+	if current == 0 {
+		return fmt.Errorf("no migrations have been applied")
+	}
+
+	target := 0
+	for _, migration := range migrations {
+		if migration.Version < current {
+			target = migration.Version
+		}
+	}
+	return MigrateTo(database, target)
+}
+
+// MigrateTo applies or reverts migrations until the schema is at the target version
+func MigrateTo(database *sqlx.DB, target int) error {
+	if target < 0 || target > LatestMigration() {
+		return fmt.Errorf("unknown migration version: %d", target)
+	}
+
+	applied, err := loadAppliedMigrations(database)
+	if err != nil {
+		return err
+	}
+
+	// apply in ascending order
+	for _, migration := range migrations {
+		if _, found := applied[migration.Version]; !found && migration.Version <= target {
+			err = applyMigration(database, migration, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// revert in descending order
+	for index := len(migrations) - 1; index >= 0; index-- {
+		migration := migrations[index]
+		if _, found := applied[migration.Version]; found && migration.Version > target {
+			err = applyMigration(database, migration, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func applyMigration(database *sqlx.DB, migration Migration, up bool) error {
+	statements := migration.Up
+	direction := "up"
+	if !up {
+		statements = migration.Down
+		direction = "down"
+	}
+
+	dialect, err := dialectOf(database)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("migrating %s: %d %s", direction, migration.Version, migration.Description)
+
+	transaction, err := database.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		done, err := alreadyApplied(transaction, statement)
+		if err == nil && done {
+			logrus.Infof("migration %d %s already applied: %s", migration.Version, direction, statement)
+			continue
+		}
+		if err == nil {
+			_, err = transaction.Exec(dialect.Translate(statement))
+		}
+		if err != nil {
+			_ = transaction.Rollback()
+			return fmt.Errorf("migration %d %s failed: %v", migration.Version, direction, err)
+		}
+	}
+
+	builder := NewQueryBuilder(migrationsTableName).Where(migrationVersionField, migration.Version)
+	query, arguments, err := builder.Delete()
+	if up {
+		builder = NewQueryBuilder(migrationsTableName).
+			Set(migrationVersionField, migration.Version).
+			Set("description", migration.Description)
+		query, arguments, err = builder.Insert()
+	}
+
+	if err == nil {
+		_, err = transaction.Exec(query, arguments...)
+	}
+
+	if err != nil {
+		_ = transaction.Rollback()
+		return fmt.Errorf("failed to record migration %d: %v", migration.Version, err)
+	}
+
+	return transaction.Commit()
+}
+
+// alreadyApplied reports whether the statement adds a column that exists or drops one that does
+// not, as it would after a mysql migration that failed part way through
+func alreadyApplied(database Executor, statement string) (bool, error) {
+	match := alterColumn.FindStringSubmatch(statement)
+	if match == nil {
+		return false, nil
+	}
+
+	exists, err := columnExists(database, match[1], match[3])
+	if err != nil {
+		return false, err
+	}
+	return exists == strings.EqualFold(match[2], "add"), nil
+}
+
+// columnExists reports whether the table has the column, by reading the columns of an empty result
+func columnExists(database Executor, table, column string) (bool, error) {
+	rows, err := database.QueryxContext(context.Background(), "select * from "+table+" where 1 = 0")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range columns {
+		if strings.EqualFold(name, column) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,83 @@
+// Package database for all database assets
+package database
+
+import (
+	"testing"
+)
+
+func TestMigrateUpAndDown(t *testing.T) {
+	database := openTestDatabase(t)
+
+	current, err := CurrentMigration(database)
+	if err != nil || current != LatestMigration() {
+		t.Fatalf("expected every migration to be applied, at %d: %v", current, err)
+	}
+
+	if err = MigrateTo(database, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = MigrateUp(database); err != nil {
+		t.Fatalf("expected the migrations to apply again after reverting them: %v", err)
+	}
+}
+
+func TestMigrateAfterPartialFailure(t *testing.T) {
+	database := openTestDatabase(t)
+	if err := MigrateTo(database, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// mysql keeps the columns added before a later statement of the migration failed
+	if _, err := database.Exec("alter table devices add column state varchar(16) default 'pending'"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateUp(database); err != nil {
+		t.Fatalf("expected the partly applied migration to complete: %v", err)
+	}
+
+	for _, column := range []string{"state", "claim_code", "claim_attempts", "online"} {
+		if exists, err := columnExists(database, "devices", column); err != nil || !exists {
+			t.Errorf("expected devices to have %s: %v", column, err)
+		}
+	}
+
+	// reverting is just as safe to repeat once a column is already gone
+	if _, err := database.Exec("alter table devices drop column ip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MigrateTo(database, 5); err != nil {
+		t.Fatalf("expected the partly reverted migration to complete: %v", err)
+	}
+	if exists, err := columnExists(database, "devices", "online"); err != nil || exists {
+		t.Errorf("expected online to be dropped: %v", err)
+	}
+}
+
+func TestAlreadyApplied(t *testing.T) {
+	database := openTestDatabase(t)
+
+	tests := []struct {
+		statement string
+		done      bool
+	}{
+		{statement: "alter table devices add column state varchar(16)", done: true},
+		{statement: "ALTER TABLE devices ADD COLUMN missing int", done: false},
+		{statement: "alter table devices drop column state", done: false},
+		{statement: "alter table devices drop column missing", done: true},
+		{statement: "create table if not exists devices (id int)", done: false},
+		{statement: "update devices set state = 'claimed'", done: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			done, err := alreadyApplied(database, test.statement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done != test.done {
+				t.Errorf("expected already applied to be %v", test.done)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// Package database for all database assets
+package database
+
+// migrations is the ordered list of schema changes compiled into the binary,
+// new migrations must be appended with an increasing version and stick to sql
+// that every dialect accepts, using the dialect tokens for anything that differs.
+// mysql does not roll back schema changes, so each statement must be safe to run
+// again after a failure, see Migration
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create initial tables",
+		Up: []string{
+			`create table if not exists users (
+				id {{primarykey}},
+				fname varchar(128),
+				lname varchar(128),
+				nname varchar(128),
+				uname varchar(128),
+				password varchar(128),
+				password_change datetime default current_timestamp,
+				email varchar(64),
+				phone varchar(32),
+				age int,
+				accepts_cookies smallint,
+				filter_content smallint,
+				last_login datetime default current_timestamp,
+				token varchar(128),
+				active smallint
+			)`,
+			`create table if not exists devices (
+				id {{primarykey}},
+				model varchar(128),
+				serial varchar(128),
+				firmware varchar(128),
+				active smallint
+			)`,
+			`create table if not exists device_user_mapping (
+				id {{primarykey}},
+				user_id int,
+				device_id int,
+				active smallint
+			)`,
+			`create table if not exists images (
+				id {{primarykey}},
+				user_id int,
+				device_id int,
+				path text,
+				active smallint
+			)`,
+			`create table if not exists settings (
+				id {{primarykey}},
+				user_device_mapping_id int,
+				name varchar(64),
+				value varchar(128),
+				active smallint
+			)`,
+		},
+		Down: []string{
+			"drop table if exists settings",
+			"drop table if exists images",
+			"drop table if exists device_user_mapping",
+			"drop table if exists devices",
+			"drop table if exists users",
+		},
+	},
+	{
+		Version:     2,
+		Description: "add videos",
+		Up: []string{
+			`create table if not exists videos (
+				id {{primarykey}},
+				user_id int,
+				device_id int,
+				path text,
+				duration_ms bigint,
+				container varchar(32),
+				codec varchar(32),
+				size bigint,
+				created datetime default current_timestamp,
+				active smallint
+			)`,
+		},
+		Down: []string{
+			"drop table if exists videos",
+		},
+	},
+	{
+		Version:     3,
+		Description: "add audio clips",
+		Up: []string{
+			`create table if not exists audios (
+				id {{primarykey}},
+				user_id int,
+				device_id int,
+				path text,
+				format varchar(16),
+				duration_ms bigint,
+				size bigint,
+				image_id int default 0,
+				video_id int default 0,
+				created datetime default current_timestamp,
+				active smallint
+			)`,
+		},
+		Down: []string{
+			"drop table if exists audios",
+		},
+	},
+	{
+		Version:     4,
+		Description: "track device provisioning state",
+		Up: []string{
+			"alter table devices add column state varchar(16) default 'pending'",
+			"alter table devices add column claim_code varchar(16) default ''",
+			"alter table devices add column claim_attempts int default 0",
+			// devices that already belong to someone were claimed before states existed
+			`update devices set state = 'claimed'
+				where id in (select device_id from device_user_mapping where active = 1)`,
+		},
+		Down: []string{
+			"alter table devices drop column claim_attempts",
+			"alter table devices drop column claim_code",
+			"alter table devices drop column state",
+		},
+	},
+	{
+		Version:     5,
+		Description: "record settings reported by devices",
+		Up: []string{
+			`create table if not exists reported_settings (
+				id {{primarykey}},
+				device_id int,
+				name varchar(64),
+				value varchar(128),
+				reported datetime default current_timestamp,
+				active smallint
+			)`,
+		},
+		Down: []string{
+			"drop table if exists reported_settings",
+		},
+	},
+	{
+		Version:     6,
+		Description: "track device presence",
+		Up: []string{
+			"alter table devices add column online smallint default 0",
+			"alter table devices add column last_seen datetime",
+			"alter table devices add column ip varchar(64) default ''",
+		},
+		Down: []string{
+			"alter table devices drop column ip",
+			"alter table devices drop column last_seen",
+			"alter table devices drop column online",
+		},
+	},
+	{
+		Version:     7,
+		Description: "keep messages that failed processing",
+		Up: []string{
+			`create table if not exists dead_letters (
+				id {{primarykey}},
+				topic varchar(255),
+				payload {{blob}},
+				error text,
+				attempts int,
+				created datetime default current_timestamp,
+				last_attempt datetime default current_timestamp,
+				active smallint
+			)`,
+		},
+		Down: []string{
+			"drop table if exists dead_letters",
+		},
+	},
+	{
+		Version:     8,
+		Description: "keep the file names devices gave their captures",
+		Up: []string{
+			"alter table images add column name varchar(255) default ''",
+			"alter table videos add column name varchar(255) default ''",
+			"alter table audios add column name varchar(255) default ''",
+		},
+		Down: []string{
+			"alter table audios drop column name",
+			"alter table videos drop column name",
+			"alter table images drop column name",
+		},
+	},
+	{
+		Version:     9,
+		Description: "expire the claim codes of pending devices",
+		Up: []string{
+			"alter table devices add column claim_expires datetime",
+		},
+		Down: []string{
+			"alter table devices drop column claim_expires",
+		},
+	},
+}
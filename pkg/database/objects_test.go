@@ -0,0 +1,157 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// openTestDatabase creates a migrated sqlite database that is removed with the test
+func openTestDatabase(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dialect, err := LookupDialect(SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := dialect.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err = MigrateUp(database); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+// fill sets every column the object inserts to the hostile value, strings and bytes to the text
+// and numbers to the number, leaving the primary key and the columns the database sets alone
+func fill(item interface{}, text string, number int64) {
+	value := reflect.ValueOf(item).Elem()
+	for index := 0; index < value.NumField(); index++ {
+		field := value.Type().Field(index)
+		if !strings.Contains(field.Tag.Get("access"), "insert") {
+			continue
+		}
+
+		switch target := value.Field(index); target.Kind() {
+		case reflect.String:
+			target.SetString(text)
+		case reflect.Int, reflect.Int64:
+			target.SetInt(number)
+		case reflect.Slice:
+			target.SetBytes([]byte(text))
+		}
+	}
+}
+
+// withoutTimes copies the object with its time columns cleared, sqlite keeps times at a
+// different precision and the database sets some of them itself
+func withoutTimes(item interface{}) interface{} {
+	value := reflect.New(reflect.TypeOf(item).Elem()).Elem()
+	value.Set(reflect.ValueOf(item).Elem())
+
+	for index := 0; index < value.NumField(); index++ {
+		target := value.Field(index)
+		if target.Type() == reflect.TypeOf(time.Time{}) || target.Type() == reflect.TypeOf(sql.NullTime{}) {
+			target.Set(reflect.Zero(target.Type()))
+		}
+	}
+	return value.Interface()
+}
+
+func TestObjectsRoundTrip(t *testing.T) {
+	database := openTestDatabase(t)
+	ctx := context.Background()
+
+	objects := []func() Access{
+		func() Access { return &UserObject{} },
+		func() Access { return &DeviceObject{} },
+		func() Access { return &DeviceUserMappingObject{} },
+		func() Access { return &ImageObject{} },
+		func() Access { return &SettingsObject{} },
+		func() Access { return &ReportedSettingObject{} },
+		func() Access { return &VideoObject{} },
+		func() Access { return &AudioObject{} },
+		func() Access { return &DeadLetterObject{LastAttempt: time.Now()} },
+	}
+
+	tests := []struct {
+		name   string
+		text   string
+		number int64
+	}{
+		{name: "quotes", text: `it's a "quoted" value`, number: 1},
+		{name: "statement", text: "'; drop table users; --", number: -1},
+		{name: "comment", text: "/* */ or 1=1 #", number: 0},
+		{name: "wildcards", text: "100% of_names", number: 2147483647},
+		{name: "backslashes", text: `C:\path\to\'file'\`, number: -2147483648},
+		{name: "unicode", text: "Grüße, 世界 🐱‍👤", number: 42},
+		{name: "control characters", text: "line\nbreak\ttab\r\x1b[31m\x00nul", number: 7},
+		{name: "placeholders", text: "? $1 :name {{primarykey}}", number: 3},
+		{name: "long", text: strings.Repeat("x", 120), number: 4},
+		{name: "empty", text: "", number: 5},
+	}
+
+	for _, test := range tests {
+		for _, newObject := range objects {
+			created := newObject()
+			name := test.name + "/" + reflect.TypeOf(created).Elem().Name()
+
+			t.Run(name, func(t *testing.T) {
+				fill(created, test.text, test.number)
+				if err := created.Create(ctx, database); err != nil {
+					t.Fatal(err)
+				}
+
+				loaded := newObject()
+				reflect.ValueOf(loaded).Elem().FieldByName("ID").Set(reflect.ValueOf(created).Elem().FieldByName("ID"))
+				if err := loaded.Load(ctx, database); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(withoutTimes(created), withoutTimes(loaded)) {
+					t.Fatalf("created %+v\nloaded  %+v", created, loaded)
+				}
+
+				// the lookup column finds the row by the hostile value itself
+				metadata, err := metadataFor(reflect.TypeOf(created).Elem())
+				if err != nil {
+					t.Fatal(err)
+				}
+				lookup := fmt.Sprint(reflect.ValueOf(created).Elem().Field(metadata.lookup.index).Interface())
+				looked := newObject()
+				if err = looked.LoadByField(ctx, database, lookup); err != nil {
+					t.Fatalf("lookup by %q: %v", lookup, err)
+				}
+				if !reflect.DeepEqual(withoutTimes(created), withoutTimes(looked)) {
+					t.Fatalf("created %+v\nlooked up %+v", created, looked)
+				}
+
+				// the value is stored rather than run, so removing the row only removes it
+				if err = loaded.Remove(ctx, database); err != nil {
+					t.Fatal(err)
+				}
+				if err = loaded.Load(ctx, database); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("expected the removed row to be gone, got %v", err)
+				}
+			})
+		}
+	}
+
+	// a statement hidden in a value must not have dropped or emptied anything
+	if err := (&UserObject{UserName: "survivor"}).Create(ctx, database); err != nil {
+		t.Fatalf("users table did not survive: %v", err)
+	}
+}
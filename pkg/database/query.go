@@ -0,0 +1,194 @@
+// Package database for all database assets
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ascendingOrder  = "asc"
+	descendingOrder = "desc"
+)
+
+// identifierPattern restricts table and column names as they cannot be bound
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// QueryBuilder generates placeholder bound statements for a given table
+// so that no caller supplied value is ever placed directly into the sql
+type QueryBuilder struct {
+	table     string
+	columns   []string
+	values    []interface{}
+	criteria  []string
+	arguments []interface{}
+	ordering  []string
+	limit     int
+	err       error
+}
+
+// NewQueryBuilder creates a query builder targeting the given table
+func NewQueryBuilder(table string) *QueryBuilder {
+	builder := &QueryBuilder{table: table}
+	builder.validate(table)
+
+	return builder
+}
+
+func (builder *QueryBuilder) validate(identifier string) bool {
+	if !identifierPattern.MatchString(identifier) {
+		if builder.err == nil {
+			builder.err = fmt.Errorf("invalid identifier: %q", identifier)
+		}
+		return false
+	}
+	return true
+}
+
+// Set adds a column and its value to be used for inserts and updates
+func (builder *QueryBuilder) Set(column string, value interface{}) *QueryBuilder {
+	if builder.validate(column) {
+		builder.columns = append(builder.columns, column)
+		builder.values = append(builder.values, value)
+	}
+	return builder
+}
+
+// SetMany adds each of the column/value pairs, sorted by column for a stable statement
+func (builder *QueryBuilder) SetMany(values map[string]string) *QueryBuilder {
+	for _, column := range sortedKeys(values) {
+		builder.Set(column, values[column])
+	}
+	return builder
+}
+
+// Where restricts the statement to rows where the column matches the value
+func (builder *QueryBuilder) Where(column string, value interface{}) *QueryBuilder {
+	if builder.validate(column) {
+		builder.criteria = append(builder.criteria, column+"=?")
+		builder.arguments = append(builder.arguments, value)
+	}
+	return builder
+}
+
+// WhereMany restricts the statement using each of the criteria, sorted by column
+func (builder *QueryBuilder) WhereMany(criteria map[string]string) *QueryBuilder {
+	for _, column := range sortedKeys(criteria) {
+		builder.Where(column, criteria[column])
+	}
+	return builder
+}
+
+// OrderBy orders a select by the given column
+func (builder *QueryBuilder) OrderBy(column string, descending bool) *QueryBuilder {
+	if builder.validate(column) {
+		direction := ascendingOrder
+		if descending {
+			direction = descendingOrder
+		}
+		builder.ordering = append(builder.ordering, column+" "+direction)
+	}
+	return builder
+}
+
+// Limit restricts a select to at most count rows
+func (builder *QueryBuilder) Limit(count int) *QueryBuilder {
+	builder.limit = count
+	return builder
+}
+
+func (builder *QueryBuilder) whereClause() string {
+	if len(builder.criteria) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(builder.criteria, " and ")
+}
+
+// Select returns a select statement and its arguments
+func (builder *QueryBuilder) Select() (string, []interface{}, error) {
+	if builder.err != nil {
+		return "", nil, builder.err
+	}
+
+	query := "select * from " + builder.table + builder.whereClause()
+	if len(builder.ordering) > 0 {
+		query += " order by " + strings.Join(builder.ordering, ",")
+	}
+
+	if builder.limit > 0 {
+		query += " limit " + strconv.Itoa(builder.limit)
+	}
+
+	return query, builder.arguments, nil
+}
+
+// Insert returns an insert statement and its arguments
+func (builder *QueryBuilder) Insert() (string, []interface{}, error) {
+	if builder.err != nil {
+		return "", nil, builder.err
+	}
+
+	if len(builder.columns) == 0 {
+		return "", nil, fmt.Errorf("no values to insert into %s", builder.table)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(builder.columns)), ",")
+	query := fmt.Sprintf(insertStatement, builder.table, strings.Join(builder.columns, ","), placeholders)
+
+	return query, builder.values, nil
+}
+
+// Update returns an update statement and its arguments, criteria is required
+// so that an update can never unintentionally rewrite the entire table
+func (builder *QueryBuilder) Update() (string, []interface{}, error) {
+	if builder.err != nil {
+		return "", nil, builder.err
+	}
+
+	if len(builder.columns) == 0 {
+		return "", nil, fmt.Errorf("no values to update in %s", builder.table)
+	}
+
+	if len(builder.criteria) == 0 {
+		return "", nil, fmt.Errorf("refusing to update %s without criteria", builder.table)
+	}
+
+	assignments := make([]string, len(builder.columns))
+	for index, column := range builder.columns {
+		assignments[index] = column + "=?"
+	}
+
+	query := "update " + builder.table + " set " + strings.Join(assignments, ",") + builder.whereClause()
+
+	arguments := make([]interface{}, 0, len(builder.values)+len(builder.arguments))
+	arguments = append(arguments, builder.values...)
+	arguments = append(arguments, builder.arguments...)
+
+	return query, arguments, nil
+}
+
+// Delete returns a delete statement and its arguments, criteria is required
+func (builder *QueryBuilder) Delete() (string, []interface{}, error) {
+	if builder.err != nil {
+		return "", nil, builder.err
+	}
+
+	if len(builder.criteria) == 0 {
+		return "", nil, fmt.Errorf("refusing to delete from %s without criteria", builder.table)
+	}
+
+	return "delete from " + builder.table + builder.whereClause(), builder.arguments, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,115 @@
+// Package database for all database assets
+package database
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryBuilderBindsValues(t *testing.T) {
+	hostile := []string{
+		`it's a "quoted" value`,
+		"'; drop table users; --",
+		"/* */ or 1=1 #",
+		`C:\path\to\'file'\`,
+		"? $1 :name",
+		"",
+	}
+
+	for _, value := range hostile {
+		tests := []struct {
+			name      string
+			build     func() (string, []interface{}, error)
+			query     string
+			arguments []interface{}
+		}{
+			{
+				name:      "select",
+				build:     NewQueryBuilder("users").Where("user_name", value).Where("active", 1).OrderBy("id", true).Select,
+				query:     "select * from users where user_name=? and active=? order by id desc",
+				arguments: []interface{}{value, 1},
+			},
+			{
+				name:      "insert",
+				build:     NewQueryBuilder("users").Set("user_name", value).Set("active", 1).Insert,
+				query:     "insert into users (user_name,active) values (?,?)",
+				arguments: []interface{}{value, 1},
+			},
+			{
+				name:      "update",
+				build:     NewQueryBuilder("users").Set("user_name", value).Where("id", value).Update,
+				query:     "update users set user_name=? where id=?",
+				arguments: []interface{}{value, value},
+			},
+			{
+				name:      "delete",
+				build:     NewQueryBuilder("users").Where("user_name", value).Delete,
+				query:     "delete from users where user_name=?",
+				arguments: []interface{}{value},
+			},
+			{
+				name:      "sorted criteria",
+				build:     NewQueryBuilder("users").WhereMany(map[string]string{"token": value, "active": "1"}).Select,
+				query:     "select * from users where active=? and token=?",
+				arguments: []interface{}{"1", value},
+			},
+			{
+				name:      "sorted values",
+				build:     NewQueryBuilder("users").SetMany(map[string]string{"token": value, "active": "1"}).Where("id", 1).Update,
+				query:     "update users set active=?,token=? where id=?",
+				arguments: []interface{}{"1", value, 1},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name+" "+value, func(t *testing.T) {
+				query, arguments, err := test.build()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if query != test.query {
+					t.Errorf("expected %q, got %q", test.query, query)
+				}
+				if !reflect.DeepEqual(arguments, test.arguments) {
+					t.Errorf("expected the arguments %v, got %v", test.arguments, arguments)
+				}
+			})
+		}
+	}
+}
+
+func TestQueryBuilderRejectsIdentifiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func() (string, []interface{}, error)
+		message string
+	}{
+		{name: "table", build: NewQueryBuilder("users; drop table users").Select, message: "invalid identifier"},
+		{name: "set", build: NewQueryBuilder("users").Set("name=name,active", 1).Insert, message: "invalid identifier"},
+		{name: "where", build: NewQueryBuilder("users").Where("1=1 or id", 1).Delete, message: "invalid identifier"},
+		{name: "order", build: NewQueryBuilder("users").OrderBy("id; --", false).Select, message: "invalid identifier"},
+		{name: "sorted criteria", build: NewQueryBuilder("users").WhereMany(map[string]string{"id or 1": "1"}).Select, message: "invalid identifier"},
+		{name: "empty", build: NewQueryBuilder("").Select, message: "invalid identifier"},
+		{name: "quoted", build: NewQueryBuilder("`users`").Select, message: "invalid identifier"},
+		{name: "insert without values", build: NewQueryBuilder("users").Insert, message: "no values to insert"},
+		{name: "update without values", build: NewQueryBuilder("users").Where("id", 1).Update, message: "no values to update"},
+		{name: "update without criteria", build: NewQueryBuilder("users").Set("active", 0).Update, message: "without criteria"},
+		{name: "delete without criteria", build: NewQueryBuilder("users").Delete, message: "without criteria"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, _, err := test.build()
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %q and %v", test.message, query, err)
+			}
+		})
+	}
+
+	// the first invalid identifier is reported even when later ones are valid
+	_, _, err := NewQueryBuilder("users").Where("bad column", 1).Where("id", 1).Select()
+	if err == nil || !strings.Contains(err.Error(), "bad column") {
+		t.Errorf("expected the bad column to be reported, got %v", err)
+	}
+}
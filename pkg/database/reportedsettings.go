@@ -0,0 +1,57 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// ReportedSettingObject for the settings a device last reported it is using
+type ReportedSettingObject struct {
+	_        struct{}  `table:"reported_settings"`
+	ID       int       `db:"id" access:"pk"`
+	DeviceID int       `db:"device_id" access:"insert,update,lookup"`
+	Name     string    `db:"name" access:"insert,update"`
+	Value    string    `db:"value" access:"insert,update"`
+	Reported time.Time `db:"reported"`
+	Active   int       `db:"active" access:"insert,update"`
+}
+
+// Load the reported setting object from the database
+func (setting *ReportedSettingObject) Load(ctx context.Context, database Executor) error {
+	return loadItem(ctx, database, setting)
+}
+
+// LoadByField loads a reported setting by its device id
+func (setting *ReportedSettingObject) LoadByField(ctx context.Context, database Executor, field string) error {
+	return loadItemByLookup(ctx, database, setting, field)
+}
+
+// Create adds the item to the database, returning an error if failure
+func (setting *ReportedSettingObject) Create(ctx context.Context, database Executor) error {
+	setting.Active = activeValue
+	return createItem(ctx, database, setting)
+}
+
+// Update the item in the database, returning an error if failure
+func (setting *ReportedSettingObject) Update(ctx context.Context, database Executor) error {
+	return updateItem(ctx, database, setting)
+}
+
+// UpdateMany items in the database using specified criteria
+func (setting *ReportedSettingObject) UpdateMany(ctx context.Context, database Executor, values, criteria map[string]string) error {
+	return updateManyItems(ctx, database, setting, values, criteria)
+}
+
+// Remove the item from the database, returning an error if failure
+func (setting *ReportedSettingObject) Remove(ctx context.Context, database Executor) error {
+	return removeItem(ctx, database, setting)
+}
+
+// Query the reported settings matching the criteria from the database
+func (setting *ReportedSettingObject) Query(ctx context.Context, database Executor, criteria map[string]string) ([]ReportedSettingObject, error) {
+	settings := make([]ReportedSettingObject, 0)
+	err := queryItems(ctx, database, &settings, criteria)
+
+	return settings, err
+}
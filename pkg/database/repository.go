@@ -0,0 +1,321 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	tableTag    = "table"
+	columnTag   = "db"
+	accessTag   = "access"
+	primaryKey  = "pk"
+	lookupKey   = "lookup"
+	insertable  = "insert"
+	updatable   = "update"
+	activeValue = 1
+)
+
+// column maps a database column to the struct field holding it
+type column struct {
+	name  string
+	index int
+}
+
+// tableMetadata is everything the repository needs to know about an object,
+// derived from the struct tags:
+//
+//	_      struct{} `table:"devices"`
+//	ID     int      `db:"id" access:"pk"`
+//	Serial string   `db:"serial" access:"insert,update,lookup"`
+type tableMetadata struct {
+	table         string
+	primaryKey    *column
+	lookup        *column
+	insertColumns []column
+	updateColumns []column
+}
+
+var metadataCache sync.Map
+
+func parseMetadata(objectType reflect.Type) (*tableMetadata, error) {
+	metadata := &tableMetadata{}
+
+	for index := 0; index < objectType.NumField(); index++ {
+		field := objectType.Field(index)
+
+		if table, found := field.Tag.Lookup(tableTag); found {
+			metadata.table = table
+			continue
+		}
+
+		name := field.Tag.Get(columnTag)
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+
+		fieldColumn := column{name: name, index: index}
+		for _, option := range strings.Split(field.Tag.Get(accessTag), ",") {
+			switch option {
+			case primaryKey:
+				metadata.primaryKey = &column{name: name, index: index}
+			case lookupKey:
+				metadata.lookup = &column{name: name, index: index}
+			case insertable:
+				metadata.insertColumns = append(metadata.insertColumns, fieldColumn)
+			case updatable:
+				metadata.updateColumns = append(metadata.updateColumns, fieldColumn)
+			}
+		}
+	}
+
+	if len(metadata.table) == 0 {
+		return nil, fmt.Errorf("%s has no table tag", objectType.Name())
+	}
+
+	if metadata.primaryKey == nil {
+		return nil, fmt.Errorf("%s has no primary key", objectType.Name())
+	}
+
+	return metadata, nil
+}
+
+// metadataFor returns the table details for a struct type, parsing the tags once
+func metadataFor(objectType reflect.Type) (*tableMetadata, error) {
+	for objectType.Kind() == reflect.Ptr || objectType.Kind() == reflect.Slice {
+		objectType = objectType.Elem()
+	}
+
+	if objectType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a database object", objectType)
+	}
+
+	if metadata, found := metadataCache.Load(objectType); found {
+		return metadata.(*tableMetadata), nil
+	}
+
+	metadata, err := parseMetadata(objectType)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataCache.Store(objectType, metadata)
+
+	return metadata, nil
+}
+
+func objectValue(object interface{}) (reflect.Value, *tableMetadata, error) {
+	value := reflect.ValueOf(object)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return value, nil, fmt.Errorf("database object must be a non nil pointer")
+	}
+
+	metadata, err := metadataFor(value.Type())
+
+	return value.Elem(), metadata, err
+}
+
+// getItem loads a single object with the built select, ErrNotFound if there is none
+func getItem(ctx context.Context, database Executor, object interface{}, builder *QueryBuilder) error {
+	query, arguments, err := builder.Select()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	err = sqlx.GetContext(ctx, database, object, query, arguments...)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return wrapError(ctx, err)
+}
+
+// loadItem loads the object using its primary key
+func loadItem(ctx context.Context, database Executor, object interface{}) error {
+	value, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	key := value.Field(metadata.primaryKey.index).Interface()
+
+	return getItem(ctx, database, object, NewQueryBuilder(metadata.table).Where(metadata.primaryKey.name, key))
+}
+
+// loadItemByLookup loads the object using its lookup column
+func loadItemByLookup(ctx context.Context, database Executor, object interface{}, field string) error {
+	_, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	if metadata.lookup == nil {
+		return fmt.Errorf("%s has no lookup column", metadata.table)
+	}
+
+	return getItem(ctx, database, object, NewQueryBuilder(metadata.table).Where(metadata.lookup.name, field))
+}
+
+// loadLatestItem loads the most recently created object matching the criteria
+func loadLatestItem(ctx context.Context, database Executor, object interface{}, criteria map[string]string) error {
+	_, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	builder := NewQueryBuilder(metadata.table).WhereMany(criteria).OrderBy(metadata.primaryKey.name, true).Limit(1)
+
+	return getItem(ctx, database, object, builder)
+}
+
+// createItem inserts the object and assigns its new primary key
+func createItem(ctx context.Context, database Executor, object interface{}) error {
+	value, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	builder := NewQueryBuilder(metadata.table)
+	for _, insertColumn := range metadata.insertColumns {
+		builder.Set(insertColumn.name, value.Field(insertColumn.index).Interface())
+	}
+
+	query, arguments, err := builder.Insert()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	result, err := database.ExecContext(ctx, query, arguments...)
+	if err != nil {
+		return wrapError(ctx, err)
+	}
+
+	nextID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	value.Field(metadata.primaryKey.index).SetInt(nextID)
+
+	return nil
+}
+
+// updateItem writes the object back to its own row
+func updateItem(ctx context.Context, database Executor, object interface{}) error {
+	value, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	builder := NewQueryBuilder(metadata.table)
+	for _, updateColumn := range metadata.updateColumns {
+		builder.Set(updateColumn.name, value.Field(updateColumn.index).Interface())
+	}
+	builder.Where(metadata.primaryKey.name, value.Field(metadata.primaryKey.index).Interface())
+
+	return execute(ctx, database, builder.Update)
+}
+
+// updateItemIf writes the object back to its own row only while the row still matches the criteria,
+// ErrNotFound when it no longer does, e.g. a concurrent update got there first
+func updateItemIf(ctx context.Context, database Executor, object interface{}, criteria map[string]string) error {
+	value, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	builder := NewQueryBuilder(metadata.table)
+	for _, updateColumn := range metadata.updateColumns {
+		builder.Set(updateColumn.name, value.Field(updateColumn.index).Interface())
+	}
+	builder.Where(metadata.primaryKey.name, value.Field(metadata.primaryKey.index).Interface()).WhereMany(criteria)
+
+	query, arguments, err := builder.Update()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	result, err := database.ExecContext(ctx, query, arguments...)
+	if err != nil {
+		return wrapError(ctx, err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// updateManyItems sets the values on every row of the object's table matching the criteria
+func updateManyItems(ctx context.Context, database Executor, object interface{}, values, criteria map[string]string) error {
+	_, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	return execute(ctx, database, NewQueryBuilder(metadata.table).SetMany(values).WhereMany(criteria).Update)
+}
+
+// removeItem deletes the object's row
+func removeItem(ctx context.Context, database Executor, object interface{}) error {
+	value, metadata, err := objectValue(object)
+	if err != nil {
+		return err
+	}
+
+	key := value.Field(metadata.primaryKey.index).Interface()
+
+	return execute(ctx, database, NewQueryBuilder(metadata.table).Where(metadata.primaryKey.name, key).Delete)
+}
+
+// queryItems fills objects, a pointer to a slice of database objects, with the rows matching the criteria
+func queryItems(ctx context.Context, database Executor, objects interface{}, criteria map[string]string) error {
+	metadata, err := metadataFor(reflect.TypeOf(objects))
+	if err != nil {
+		return err
+	}
+
+	query, arguments, err := NewQueryBuilder(metadata.table).WhereMany(criteria).Select()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	return wrapError(ctx, sqlx.SelectContext(ctx, database, objects, query, arguments...))
+}
+
+func execute(ctx context.Context, database Executor, statement func() (string, []interface{}, error)) error {
+	query, arguments, err := statement()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	_, err = database.ExecContext(ctx, query, arguments...)
+
+	return wrapError(ctx, err)
+}
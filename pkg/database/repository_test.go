@@ -0,0 +1,300 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// widget is a row of a table made for the tests, with a column that is inserted but never
+// updated and fields the repository must leave alone
+type widget struct {
+	_        struct{} `table:"widgets"`
+	ID       int      `db:"id" access:"pk"`
+	Model    string   `db:"model" access:"insert"`
+	Serial   string   `db:"serial" access:"insert,update,lookup"`
+	Firmware string   `db:"firmware" access:"insert,update"`
+	State    string   `db:"state" access:"insert"`
+	Ignored  string   `db:"-"`
+	Untagged string
+}
+
+func TestRepositoryMetadata(t *testing.T) {
+	names := func(columns []column) []string {
+		names := make([]string, 0, len(columns))
+		for _, column := range columns {
+			names = append(names, column.name)
+		}
+		return names
+	}
+
+	// the slice a query fills and a pointer to it describe the same table
+	for _, object := range []interface{}{&widget{}, widget{}, &[]widget{}, []*widget{}} {
+		metadata, err := metadataFor(reflect.TypeOf(object))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if metadata.table != "widgets" || metadata.primaryKey.name != "id" || metadata.lookup.name != "serial" {
+			t.Errorf("expected the widgets table keyed by id and looked up by serial, got %+v", metadata)
+		}
+		if inserted := names(metadata.insertColumns); !reflect.DeepEqual(inserted, []string{"model", "serial", "firmware", "state"}) {
+			t.Errorf("unexpected insert columns %v", inserted)
+		}
+		if updated := names(metadata.updateColumns); !reflect.DeepEqual(updated, []string{"serial", "firmware"}) {
+			t.Errorf("unexpected update columns %v", updated)
+		}
+		if field := reflect.TypeOf(widget{}).Field(metadata.lookup.index); field.Name != "Serial" {
+			t.Errorf("expected the lookup column to be held by Serial, got %s", field.Name)
+		}
+	}
+}
+
+// openWidgets creates a test database with the widgets table
+func openWidgets(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	database := openTestDatabase(t)
+	if _, err := database.Exec(`create table widgets (
+		id integer primary key autoincrement,
+		model varchar(128),
+		serial varchar(128),
+		firmware varchar(128),
+		state varchar(16)
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestRepositoryPrimaryKey(t *testing.T) {
+	database := openWidgets(t)
+	ctx := context.Background()
+
+	first := &widget{Model: "m1", Serial: "one", State: "new", Ignored: "kept", Untagged: "kept"}
+	second := &widget{Model: "m1", Serial: "two", State: "new"}
+	for _, row := range []*widget{first, second} {
+		if err := createItem(ctx, database, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first.ID == 0 || second.ID <= first.ID {
+		t.Fatalf("expected increasing primary keys to be assigned, got %d and %d", first.ID, second.ID)
+	}
+	if first.Ignored != "kept" || first.Untagged != "kept" {
+		t.Error("fields without a column were changed")
+	}
+
+	loaded := &widget{ID: second.ID}
+	if err := loadItem(ctx, database, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Serial != "two" {
+		t.Errorf("loaded %+v for primary key %d", loaded, second.ID)
+	}
+
+	latest := &widget{}
+	if err := loadLatestItem(ctx, database, latest, map[string]string{"model": "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != second.ID {
+		t.Errorf("expected the latest row to be %d, got %d", second.ID, latest.ID)
+	}
+}
+
+func TestRepositoryLookup(t *testing.T) {
+	database := openWidgets(t)
+	ctx := context.Background()
+
+	for index := 0; index < 3; index++ {
+		row := &widget{Model: "m1", Serial: "serial" + strconv.Itoa(index), State: "new"}
+		if err := createItem(ctx, database, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found := &widget{}
+	if err := loadItemByLookup(ctx, database, found, "serial1"); err != nil {
+		t.Fatal(err)
+	}
+	if found.Serial != "serial1" || found.ID == 0 {
+		t.Errorf("lookup found %+v", found)
+	}
+
+	rows := make([]widget, 0)
+	if err := queryItems(ctx, database, &rows, map[string]string{"model": "m1", "serial": "serial2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Serial != "serial2" {
+		t.Errorf("expected only serial2 to match, got %+v", rows)
+	}
+
+	rows = make([]widget, 0)
+	if err := queryItems(ctx, database, &rows, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Errorf("expected every row without criteria, got %d", len(rows))
+	}
+}
+
+func TestRepositoryInsertAndUpdate(t *testing.T) {
+	database := openWidgets(t)
+	ctx := context.Background()
+
+	row := &widget{Model: "m1", Serial: "s1", Firmware: "1.0", State: "new"}
+	if err := createItem(ctx, database, row); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the update columns are written back
+	row.Model = "m2"
+	row.Firmware = "2.0"
+	row.State = "retired"
+	if err := updateItem(ctx, database, row); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &widget{ID: row.ID}
+	if err := loadItem(ctx, database, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Firmware != "2.0" || loaded.Model != "m1" || loaded.State != "new" {
+		t.Errorf("expected only the firmware to be updated, got %+v", loaded)
+	}
+
+	other := &widget{Model: "m1", Serial: "s2", Firmware: "1.0", State: "new"}
+	if err := createItem(ctx, database, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateManyItems(ctx, database, row, map[string]string{"firmware": "3.0"}, map[string]string{"serial": "s2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		id       int
+		firmware string
+	}{{row.ID, "2.0"}, {other.ID, "3.0"}} {
+		loaded = &widget{ID: test.id}
+		if err := loadItem(ctx, database, loaded); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Firmware != test.firmware {
+			t.Errorf("row %d: expected firmware %s, got %s", test.id, test.firmware, loaded.Firmware)
+		}
+	}
+
+	if err := removeItem(ctx, database, row); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadItem(ctx, database, &widget{ID: other.ID}); err != nil {
+		t.Errorf("removing one row removed another: %v", err)
+	}
+}
+
+func TestRepositoryMissingRows(t *testing.T) {
+	database := openWidgets(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		load func() error
+	}{
+		{name: "primary key", load: func() error { return loadItem(ctx, database, &widget{ID: 404}) }},
+		{name: "lookup", load: func() error { return loadItemByLookup(ctx, database, &widget{}, "missing") }},
+		{name: "latest", load: func() error {
+			return loadLatestItem(ctx, database, &widget{}, map[string]string{"serial": "missing"})
+		}},
+		{name: "object", load: func() error { return (&UserObject{ID: 404}).Load(ctx, database) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.load(); !errors.Is(err, ErrNotFound) {
+				t.Errorf("expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+
+	rows := make([]widget, 0)
+	if err := queryItems(ctx, database, &rows, map[string]string{"serial": "missing"}); err != nil || len(rows) != 0 {
+		t.Errorf("expected no rows and no error, got %d rows and %v", len(rows), err)
+	}
+
+	// removing or updating a row that is not there changes nothing rather than failing
+	if err := removeItem(ctx, database, &widget{ID: 404}); err != nil {
+		t.Error(err)
+	}
+	if err := updateItem(ctx, database, &widget{ID: 404, Serial: "s"}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRepositoryBadTags runs without a database, as objects the tags cannot describe must be
+// refused before any statement is sent
+func TestRepositoryBadTags(t *testing.T) {
+	ctx := context.Background()
+
+	type noTable struct {
+		ID int `db:"id" access:"pk"`
+	}
+	type noPrimaryKey struct {
+		_      struct{} `table:"widgets"`
+		Serial string   `db:"serial" access:"insert,lookup"`
+	}
+	type noLookup struct {
+		_  struct{} `table:"widgets"`
+		ID int      `db:"id" access:"pk"`
+	}
+	type badTable struct {
+		_  struct{} `table:"widgets; drop table users"`
+		ID int      `db:"id" access:"pk"`
+	}
+	type badColumn struct {
+		_      struct{} `table:"widgets"`
+		ID     int      `db:"id" access:"pk"`
+		Serial string   `db:"serial or 1=1" access:"insert,update,lookup"`
+	}
+
+	tests := []struct {
+		name    string
+		run     func() error
+		message string
+	}{
+		{name: "no table", run: func() error { return loadItem(ctx, nil, &noTable{}) }, message: "has no table tag"},
+		{name: "no primary key", run: func() error { return createItem(ctx, nil, &noPrimaryKey{}) }, message: "has no primary key"},
+		{name: "no lookup", run: func() error { return loadItemByLookup(ctx, nil, &noLookup{}, "s") }, message: "has no lookup column"},
+		{name: "not a pointer", run: func() error { return loadItem(ctx, nil, widget{}) }, message: "non nil pointer"},
+		{name: "nil pointer", run: func() error { return createItem(ctx, nil, (*widget)(nil)) }, message: "non nil pointer"},
+		{name: "not a struct", run: func() error {
+			values := make([]string, 0)
+			return queryItems(ctx, nil, &values, nil)
+		}, message: "is not a database object"},
+		{name: "bad table", run: func() error { return loadItem(ctx, nil, &badTable{}) }, message: "invalid identifier"},
+		{name: "bad column", run: func() error { return createItem(ctx, nil, &badColumn{Serial: "s"}) }, message: "invalid identifier"},
+		{name: "bad criteria", run: func() error {
+			return updateManyItems(ctx, nil, &widget{}, map[string]string{"firmware": "1"}, map[string]string{"1=1 or serial": "s"})
+		}, message: "invalid identifier"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.run()
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+
+	if _, err := metadataFor(reflect.TypeOf(0)); err == nil {
+		t.Error("expected an int to be rejected as a database object")
+	}
+}
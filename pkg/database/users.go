@@ -2,162 +2,65 @@
 package database
 
 import (
-	"fmt"
+	"context"
 	"time"
-
-	"github.com/jmoiron/sqlx"
-	"github.com/sirupsen/logrus"
 )
 
-const userTableName = "users"
-
 // UserObject for users that will come from a database
 type UserObject struct {
-	ID             int       `db:"id"`
-	FirstName      string    `db:"fname"`
-	LastName       string    `db:"lname"`
-	NickName       string    `db:"nname"`
-	UserName       string    `db:"uname"`
-	Password       string    `db:"password"`
+	_              struct{}  `table:"users"`
+	ID             int       `db:"id" access:"pk"`
+	FirstName      string    `db:"fname" access:"insert,update"`
+	LastName       string    `db:"lname" access:"insert,update"`
+	NickName       string    `db:"nname" access:"insert,update"`
+	UserName       string    `db:"uname" access:"insert,update,lookup"`
+	Password       string    `db:"password" access:"insert,update"`
 	PasswordChange time.Time `db:"password_change"`
-	EmailAddress   string    `db:"email"`
-	Phone          string    `db:"phone"`
-	Age            int       `db:"age"`
-	AcceptsCookies int       `db:"accepts_cookies"`
-	FilterContent  int       `db:"filter_content"`
+	EmailAddress   string    `db:"email" access:"insert,update"`
+	Phone          string    `db:"phone" access:"insert,update"`
+	Age            int       `db:"age" access:"insert,update"`
+	AcceptsCookies int       `db:"accepts_cookies" access:"insert,update"`
+	FilterContent  int       `db:"filter_content" access:"insert,update"`
 	LastLogin      time.Time `db:"last_login"`
-	Token          string    `db:"token"`
-	Active         int       `db:"active"`
-}
-
-// Populate populates the settings object with the data from database row
-func (user *UserObject) Populate(rows *sqlx.Rows) error {
-	if rows.Next() {
-		err := rows.StructScan(user)
-		if err != nil {
-			logrus.Warnf("failed scanning results: %v", err)
-		}
-	} else {
-		err := rows.Err()
-		if err != nil {
-			return fmt.Errorf("failed to find any results - error: %v", err)
-		}
-	}
-	return nil
+	Token          string    `db:"token" access:"insert,update"`
+	Active         int       `db:"active" access:"insert,update"`
 }
 
-// Load the user object from the database response
-func (user *UserObject) Load(database *sqlx.DB) error {
-	query := fmt.Sprintf(specificItemLoad, userTableName, user.ID)
-	results, err := database.Queryx(query)
-
-	if err != nil {
-		return err
-	}
-
-	err = user.Populate(results)
-	if err != nil {
-		logrus.Warnf("failed populating data: %v", err)
-	}
-
-	err = results.Close()
-	if err != nil {
-		logrus.Warnf("failed closing results: %v", err)
-	}
-	return nil
+// Load the user object from the database
+func (user *UserObject) Load(ctx context.Context, database Executor) error {
+	return loadItem(ctx, database, user)
 }
 
-// LoadByField loads an object by a specific field know to said object
-func (user *UserObject) LoadByField(database *sqlx.DB, field string) error {
-	// query := fmt.Sprintf("select * from %s where uname='%s'", userTableName, field)
-	results, err := database.Queryx("select * from ? where uname='?'", userTableName, field)
-
-	if err != nil {
-		return err
-	}
-
-	err = user.Populate(results)
-	if err != nil {
-		logrus.Warnf("failed populating data: %v", err)
-	}
-
-	err = results.Close()
-	if err != nil {
-		logrus.Warnf("failed closing results: %v", err)
-	}
-	return nil
+// LoadByField loads a user by their user name
+func (user *UserObject) LoadByField(ctx context.Context, database Executor, field string) error {
+	return loadItemByLookup(ctx, database, user, field)
 }
 
 // Create adds the item to the database, returning an error if failure
-func (user *UserObject) Create(database *sqlx.DB) error {
-	values := fmt.Sprintf("'%s,'%s','%s','%s','%s','%s',%d,%d,%d,1", user.FirstName, user.LastName, user.NickName, user.UserName, user.EmailAddress, user.Phone, user.Age, user.AcceptsCookies, user.FilterContent)
-	query := fmt.Sprintf(createItem, userTableName, "fname,lname,nname,uname,email,phone,age,accepts_cookie,filter_content,active", values)
-
-	result, err := database.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	nextID, err := result.LastInsertId()
-
-	if err != nil {
-		return err
-	}
-
-	user.ID = int(nextID)
-
-	return nil
+func (user *UserObject) Create(ctx context.Context, database Executor) error {
+	user.Active = activeValue
+	return createItem(ctx, database, user)
 }
 
 // Update the item in the database, returning an error if failure
-func (user *UserObject) Update(database *sqlx.DB) error {
-	values := fmt.Sprintf("fname='%s',lname='%s',nname='%s',uname='%s',email='%s',phone='%s',age=%d,accepts_cookies=%d,filter_content=%d,active=%d", user.FirstName, user.LastName, user.NickName, user.UserName, user.EmailAddress, user.Phone, user.Age, user.AcceptsCookies, user.FilterContent, user.Active)
-	query := fmt.Sprintf(updateItem, userTableName, values)
-
-	_, err := database.Exec(query)
-
-	return err
+func (user *UserObject) Update(ctx context.Context, database Executor) error {
+	return updateItem(ctx, database, user)
 }
 
 // UpdateMany items in the database using specified criteria
-func (user *UserObject) UpdateMany(database *sqlx.DB, values, criteria map[string]string) error {
-	valueUpdates := getValues(values)
-	restrictions := getCriteria(criteria)
-
-	query := fmt.Sprintf(updateManyItems, userTableName, valueUpdates, restrictions)
-
-	_, err := database.Exec(query)
-
-	return err
+func (user *UserObject) UpdateMany(ctx context.Context, database Executor, values, criteria map[string]string) error {
+	return updateManyItems(ctx, database, user, values, criteria)
 }
 
 // Remove the item from the database, returning an error if failure
-func (user *UserObject) Remove(database *sqlx.DB) error {
-	query := fmt.Sprintf(deleteItem, userTableName, user.ID)
-
-	_, err := database.Exec(query)
-
-	return err
+func (user *UserObject) Remove(ctx context.Context, database Executor) error {
+	return removeItem(ctx, database, user)
 }
 
-// Query the items from the database, returning an nil if failure
-func (user *UserObject) Query(database *sqlx.DB, criteria map[string]string) *[]Access {
-	objects := make([]Access, 0)
-	restrictions := getCriteria(criteria)
-
-	query := fmt.Sprintf(queryMany, userTableName, restrictions)
-
-	results, err := database.Queryx(query)
-	if err != nil {
-		return nil
-	}
-	for results.Next() {
-		var userObj = UserObject{}
-		err = results.StructScan(&userObj)
-		if err == nil {
-			objects = append(objects, &userObj)
-		}
-	}
+// Query the users matching the criteria from the database
+func (user *UserObject) Query(ctx context.Context, database Executor, criteria map[string]string) ([]UserObject, error) {
+	users := make([]UserObject, 0)
+	err := queryItems(ctx, database, &users, criteria)
 
-	return &objects
+	return users, err
 }
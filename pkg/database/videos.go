@@ -0,0 +1,67 @@
+// Package database for all database assets
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// VideoObject for videos that will come from a database
+type VideoObject struct {
+	_          struct{}  `table:"videos"`
+	ID         int       `db:"id" access:"pk"`
+	UserID     int       `db:"user_id" access:"insert,update"`
+	DeviceID   int       `db:"device_id" access:"insert,update,lookup"`
+	Path       string    `db:"path" access:"insert,update"`
+	Name       string    `db:"name" access:"insert,update"`
+	DurationMS int64     `db:"duration_ms" access:"insert,update"`
+	Container  string    `db:"container" access:"insert,update"`
+	Codec      string    `db:"codec" access:"insert,update"`
+	Size       int64     `db:"size" access:"insert,update"`
+	Created    time.Time `db:"created"`
+	Active     int       `db:"active" access:"insert,update"`
+}
+
+// Load the video object from the database
+func (video *VideoObject) Load(ctx context.Context, database Executor) error {
+	return loadItem(ctx, database, video)
+}
+
+// LoadByField loads a video by its device id
+func (video *VideoObject) LoadByField(ctx context.Context, database Executor, field string) error {
+	return loadItemByLookup(ctx, database, video, field)
+}
+
+// LoadLatest loads the most recent video matching the criteria
+func (video *VideoObject) LoadLatest(ctx context.Context, database Executor, criteria map[string]string) error {
+	return loadLatestItem(ctx, database, video, criteria)
+}
+
+// Create adds the item to the database, returning an error if failure
+func (video *VideoObject) Create(ctx context.Context, database Executor) error {
+	video.Active = activeValue
+	return createItem(ctx, database, video)
+}
+
+// Update the item in the database, returning an error if failure
+func (video *VideoObject) Update(ctx context.Context, database Executor) error {
+	return updateItem(ctx, database, video)
+}
+
+// UpdateMany items in the database using specified criteria
+func (video *VideoObject) UpdateMany(ctx context.Context, database Executor, values, criteria map[string]string) error {
+	return updateManyItems(ctx, database, video, values, criteria)
+}
+
+// Remove the item from the database, returning an error if failure
+func (video *VideoObject) Remove(ctx context.Context, database Executor) error {
+	return removeItem(ctx, database, video)
+}
+
+// Query the videos matching the criteria from the database
+func (video *VideoObject) Query(ctx context.Context, database Executor, criteria map[string]string) ([]VideoObject, error) {
+	videos := make([]VideoObject, 0)
+	err := queryItems(ctx, database, &videos, criteria)
+
+	return videos, err
+}
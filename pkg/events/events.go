@@ -0,0 +1,104 @@
+// Package events for notifying interested parties of what happens to a user's devices
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event types
+const (
+	// ImageCaptured is published when an image from a device has been stored
+	ImageCaptured = "image"
+	// VideoCaptured is published when a video from a device has been stored
+	VideoCaptured = "video"
+	// AudioCaptured is published when an audio clip from a device has been stored
+	AudioCaptured = "audio"
+	// DeviceOnline is published when a device connects or is heard from again
+	DeviceOnline = "device_online"
+	// DeviceOffline is published when a device disconnects or stops checking in
+	DeviceOffline = "device_offline"
+	// SettingsChanged is published when the settings of a device change
+	SettingsChanged = "settings_changed"
+
+	// SubscriberBuffer is how many events a subscriber can fall behind before events are dropped
+	SubscriberBuffer = 32
+)
+
+// Event is something that happened to a device of a user
+type Event struct {
+	Type     string      `json:"type"`
+	UserID   int         `json:"-"`
+	DeviceID int         `json:"device_id,omitempty"`
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// Subscription receives the events of a single user
+type Subscription struct {
+	userID int
+	events chan Event
+}
+
+// Events returns the channel the events of the subscription arrive on
+func (subscription *Subscription) Events() <-chan Event {
+	return subscription.events
+}
+
+// Bus delivers published events to the subscribers of the user they belong to
+type Bus struct {
+	lock        sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Publish delivers the event without blocking, a subscriber that has fallen
+// too far behind misses it rather than holding up the publisher
+func (bus *Bus) Publish(event Event) {
+	if bus == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+
+	for subscription := range bus.subscribers {
+		if subscription.userID != event.UserID {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			logrus.Debugf("dropped %s event for user %d, subscriber is behind", event.Type, event.UserID)
+		}
+	}
+}
+
+// Subscribe starts receiving the events of the user
+func (bus *Bus) Subscribe(userID int) *Subscription {
+	subscription := &Subscription{userID: userID, events: make(chan Event, SubscriberBuffer)}
+
+	bus.lock.Lock()
+	bus.subscribers[subscription] = struct{}{}
+	bus.lock.Unlock()
+
+	return subscription
+}
+
+// Unsubscribe stops delivering events to the subscription
+func (bus *Bus) Unsubscribe(subscription *Subscription) {
+	bus.lock.Lock()
+	delete(bus.subscribers, subscription)
+	bus.lock.Unlock()
+}
@@ -0,0 +1,120 @@
+// Package health for reporting the state of the services the site depends on
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// Broker connection states
+const (
+	Connecting   = "connecting"
+	Connected    = "connected"
+	Disconnected = "disconnected"
+)
+
+// pingTimeout bounds how long the database check of a health request may take
+const pingTimeout = 2 * time.Second
+
+// Broker metrics, published with the rest of the expvar variables on /debug/vars
+var (
+	BrokerConnected      = expvar.NewInt("broker_connected")
+	BrokerConnects       = expvar.NewInt("broker_connects")
+	BrokerConnectFailed  = expvar.NewInt("broker_connect_failures")
+	BrokerConnectionLost = expvar.NewInt("broker_connections_lost")
+	MessagesReceived     = expvar.NewInt("messages_received")
+	MessagesPublished    = expvar.NewInt("messages_published")
+	PublishFailures      = expvar.NewInt("publish_failures")
+)
+
+// ComponentStatus is the state of a single dependency
+type ComponentStatus struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Report is the body of the health endpoint
+type Report struct {
+	Healthy  bool            `json:"healthy"`
+	Broker   ComponentStatus `json:"broker"`
+	Database ComponentStatus `json:"database"`
+}
+
+// Monitor tracks the broker connection and checks the database on request
+type Monitor struct {
+	lock     sync.RWMutex
+	broker   ComponentStatus
+	database *sqlx.DB
+}
+
+// NewMonitor creates a monitor for a broker that is yet to connect
+func NewMonitor(db *sqlx.DB) *Monitor {
+	return &Monitor{database: db, broker: ComponentStatus{State: Connecting, Since: time.Now()}}
+}
+
+// SetBroker records a change of the broker connection, err being the reason it changed if any
+func (monitor *Monitor) SetBroker(state string, err error) {
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+
+	if state != monitor.broker.State {
+		monitor.broker.Since = time.Now()
+	}
+	monitor.broker.State = state
+	monitor.broker.Error = ""
+	if err != nil {
+		monitor.broker.Error = err.Error()
+	}
+
+	connected := int64(0)
+	if state == Connected {
+		connected = 1
+	}
+	BrokerConnected.Set(connected)
+}
+
+// Check reports the state of the broker and database
+func (monitor *Monitor) Check(ctx context.Context) *Report {
+	monitor.lock.RLock()
+	report := &Report{Broker: monitor.broker}
+	monitor.lock.RUnlock()
+
+	report.Database = ComponentStatus{State: Connected, Since: time.Now()}
+	if monitor.database != nil {
+		ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+
+		if err := monitor.database.PingContext(ctx); err != nil {
+			report.Database.State = Disconnected
+			report.Database.Error = err.Error()
+		}
+	}
+
+	report.Healthy = report.Broker.State == Connected && report.Database.State == Connected
+	return report
+}
+
+// ServeHTTP answers with the health report, as service unavailable when a dependency is down
+func (monitor *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := monitor.Check(r.Context())
+
+	code := http.StatusOK
+	if !report.Healthy {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logrus.Warnf("failed to write health report: %v", err)
+	}
+}
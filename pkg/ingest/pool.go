@@ -0,0 +1,278 @@
+// Package ingest for processing device messages concurrently
+package ingest
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"site/pkg/topics"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Policy decides what happens to a message arriving for a full queue
+type Policy string
+
+// Overflow policies
+const (
+	// Block waits for room in the queue, slowing down the broker connection
+	Block Policy = "block"
+	// DropOldest discards the message that has been waiting the longest
+	DropOldest Policy = "drop_oldest"
+	// DeadLetter sets the arriving message aside for later inspection
+	DeadLetter Policy = "dead_letter"
+)
+
+// Reasons given to the overflow handler for the messages it is passed
+var (
+	ErrQueueFull    = errors.New("ingestion queue is full")
+	ErrShuttingDown = errors.New("still queued when the drain on shutdown ran out of time")
+)
+
+// Ingestion metrics, published with the rest of the expvar variables on /debug/vars
+var (
+	QueueLength      = expvar.NewInt("ingest_queue_length")
+	Processed        = expvar.NewInt("ingest_processed")
+	Dropped          = expvar.NewInt("ingest_dropped")
+	DeadLettered     = expvar.NewInt("ingest_dead_lettered")
+	WaitTotal        = expvar.NewInt("ingest_wait_ms_total")
+	ProcessingTotal  = expvar.NewInt("ingest_processing_ms_total")
+	ProcessingLatest = expvar.NewInt("ingest_processing_ms_latest")
+)
+
+// Handler processes a message taken from a queue
+type Handler func(ctx context.Context, message *topics.Message)
+
+// OverflowHandler receives the messages set aside by the DeadLetter policy, and those left queued
+// on shutdown whatever the policy
+type OverflowHandler func(message *topics.Message, reason error)
+
+// queued is a message waiting for its worker and when it was queued
+type queued struct {
+	message *topics.Message
+	queued  time.Time
+}
+
+// overflowed is a message set aside by Submit waiting for the overflow handler
+type overflowed struct {
+	message *topics.Message
+	reason  error
+}
+
+// Pool processes messages on a fixed number of workers, each with its own bounded queue.
+// Messages with the same key always go to the same worker so they are handled in the
+// order they arrived, while messages with different keys are handled in parallel.
+type Pool struct {
+	queues     []chan queued
+	policy     Policy
+	handler    Handler
+	overflow   OverflowHandler
+	overflowed chan overflowed
+}
+
+// ParsePolicy validates a configured overflow policy
+func ParsePolicy(policy string) (Policy, error) {
+	switch Policy(policy) {
+	case Block, DropOldest, DeadLetter:
+		return Policy(policy), nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q, expected %s, %s or %s", policy, Block, DropOldest, DeadLetter)
+}
+
+// NewPool creates a pool of workers each queuing up to depth messages, overflow keeps what the
+// DeadLetter policy sets aside and what is left queued on shutdown, without it both are lost.
+// Up to as many messages as the queues hold can wait for the overflow handler.
+func NewPool(workers, depth int, policy Policy, handler Handler, overflow OverflowHandler) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+
+	pool := &Pool{queues: make([]chan queued, workers), policy: policy, handler: handler, overflow: overflow,
+		overflowed: make(chan overflowed, workers*depth)}
+	for index := range pool.queues {
+		pool.queues[index] = make(chan queued, depth)
+	}
+	return pool
+}
+
+// queue returns the queue of the worker responsible for the key
+func (pool *Pool) queue(key string) chan queued {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return pool.queues[hash.Sum32()%uint32(len(pool.queues))]
+}
+
+// Submit queues the message on the worker for the key, applying the overflow policy when its
+// queue is full. It only blocks with the Block policy, until there is room or ctx is done, the
+// messages the DeadLetter policy sets aside are passed to the overflow handler by Run.
+func (pool *Pool) Submit(ctx context.Context, key string, message *topics.Message) {
+	queue := pool.queue(key)
+	next := queued{message: message, queued: time.Now()}
+
+	select {
+	case queue <- next:
+		QueueLength.Add(1)
+		return
+	default:
+	}
+
+	switch pool.policy {
+	case DropOldest:
+		for {
+			select {
+			case oldest := <-queue:
+				QueueLength.Add(-1)
+				Dropped.Add(1)
+				logrus.Warnf("queue for %s is full, dropped message on %s", key, oldest.message.Topic)
+			default:
+			}
+
+			// the worker may have made room in the meantime, so only drop again when still full
+			select {
+			case queue <- next:
+				QueueLength.Add(1)
+				return
+			default:
+			}
+		}
+	case DeadLetter:
+		if pool.overflow == nil {
+			Dropped.Add(1)
+			logrus.Warnf("queue for %s is full, discarding message on %s", key, message.Topic)
+			return
+		}
+
+		// the overflow handler may be slow, so it must never hold up the messages of other devices
+		select {
+		case pool.overflowed <- overflowed{message: message, reason: fmt.Errorf("%w for %s", ErrQueueFull, key)}:
+			DeadLettered.Add(1)
+		default:
+			Dropped.Add(1)
+			logrus.Warnf("queue for %s is full and too many messages are set aside, discarding message on %s",
+				key, message.Topic)
+		}
+	default:
+		select {
+		case queue <- next:
+			QueueLength.Add(1)
+		case <-ctx.Done():
+			logrus.Warnf("abandoned message on %s while waiting for room in the queue", message.Topic)
+		}
+	}
+}
+
+// Run processes queued messages until ctx is done. The messages still queued then are processed
+// for up to drain longer, on a context of their own, and any left after that go to the overflow
+// handler rather than being abandoned.
+func (pool *Pool) Run(ctx context.Context, drain time.Duration) {
+	stop := make(chan struct{})
+	overflowing := &sync.WaitGroup{}
+	overflowing.Add(1)
+	go func() {
+		defer overflowing.Done()
+		pool.setAside(stop)
+	}()
+
+	workers := &sync.WaitGroup{}
+	workers.Add(len(pool.queues))
+
+	for _, queue := range pool.queues {
+		go func(queue chan queued) {
+			defer workers.Done()
+			pool.work(ctx, queue)
+		}(queue)
+	}
+
+	workers.Wait()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+
+	workers.Add(len(pool.queues))
+	for _, queue := range pool.queues {
+		go func(queue chan queued) {
+			defer workers.Done()
+			pool.drain(drainCtx, queue)
+		}(queue)
+	}
+
+	workers.Wait()
+
+	close(stop)
+	overflowing.Wait()
+}
+
+// setAside passes the messages Submit sets aside to the overflow handler until stopped, then
+// passes on whatever is still waiting
+func (pool *Pool) setAside(stop chan struct{}) {
+	for {
+		select {
+		case next := <-pool.overflowed:
+			pool.overflow(next.message, next.reason)
+		case <-stop:
+			for {
+				select {
+				case next := <-pool.overflowed:
+					pool.overflow(next.message, next.reason)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (pool *Pool) work(ctx context.Context, queue chan queued) {
+	// once shut down, what is queued is left for the drain rather than failed on a cancelled context
+	for ctx.Err() == nil {
+		select {
+		case next := <-queue:
+			pool.handle(ctx, next)
+		case <-ctx.Done():
+		}
+	}
+}
+
+// drain empties the queue, processing messages until ctx is done and setting the rest aside
+func (pool *Pool) drain(ctx context.Context, queue chan queued) {
+	for {
+		select {
+		case next := <-queue:
+			if ctx.Err() == nil {
+				pool.handle(ctx, next)
+				continue
+			}
+
+			QueueLength.Add(-1)
+			DeadLettered.Add(1)
+			if pool.overflow == nil {
+				logrus.Warnf("discarding message on %s still queued on shutdown", next.message.Topic)
+				continue
+			}
+			pool.overflow(next.message, ErrShuttingDown)
+		default:
+			return
+		}
+	}
+}
+
+// handle processes a message taken from the queue, recording how long it waited and took
+func (pool *Pool) handle(ctx context.Context, next queued) {
+	QueueLength.Add(-1)
+	started := time.Now()
+
+	pool.handler(ctx, next.message)
+
+	took := time.Since(started).Milliseconds()
+	Processed.Add(1)
+	WaitTotal.Add(started.Sub(next.queued).Milliseconds())
+	ProcessingTotal.Add(took)
+	ProcessingLatest.Set(took)
+}
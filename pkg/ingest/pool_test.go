@@ -0,0 +1,193 @@
+// Package ingest for processing device messages concurrently
+package ingest
+
+import (
+	"context"
+	"errors"
+	"site/pkg/topics"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder keeps what a pool handled and set aside
+type recorder struct {
+	lock     sync.Mutex
+	handled  []string
+	overflow []string
+	reasons  []error
+}
+
+func (recorder *recorder) handle(ctx context.Context, message *topics.Message) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.handled = append(recorder.handled, message.Topic)
+}
+
+func (recorder *recorder) setAside(message *topics.Message, reason error) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.overflow = append(recorder.overflow, message.Topic)
+	recorder.reasons = append(recorder.reasons, reason)
+}
+
+// submit queues count messages for the same device, so they share a worker
+func submit(pool *Pool, count int) {
+	for index := 0; index < count; index++ {
+		pool.Submit(context.Background(), "cam1", &topics.Message{Topic: "afm/v1/image/cam1/" + strconv.Itoa(index)})
+	}
+}
+
+func TestRunProcessesInOrder(t *testing.T) {
+	recorder := &recorder{}
+	pool := NewPool(2, 10, Block, recorder.handle, recorder.setAside)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pool.Run(ctx, time.Second)
+		close(done)
+	}()
+
+	submit(pool, 5)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		recorder.lock.Lock()
+		handled := len(recorder.handled)
+		recorder.lock.Unlock()
+		if handled == 5 {
+			break
+		}
+	}
+	cancel()
+	<-done
+
+	for index, topic := range recorder.handled {
+		if topic != "afm/v1/image/cam1/"+strconv.Itoa(index) {
+			t.Fatalf("expected messages for a device in the order they arrived, got %v", recorder.handled)
+		}
+	}
+	if len(recorder.handled) != 5 {
+		t.Errorf("expected 5 messages to be handled, got %d", len(recorder.handled))
+	}
+}
+
+func TestRunDrainsOnShutdown(t *testing.T) {
+	recorder := &recorder{}
+	pool := NewPool(1, 10, Block, recorder.handle, recorder.setAside)
+	submit(pool, 5)
+
+	// already shut down, so the queued messages are only processed by the drain
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool.Run(ctx, time.Second)
+
+	if len(recorder.handled) != 5 || len(recorder.overflow) != 0 {
+		t.Errorf("expected every queued message to be processed, handled %d and set aside %d",
+			len(recorder.handled), len(recorder.overflow))
+	}
+}
+
+func TestRunSetsAsideWhatTheDrainMisses(t *testing.T) {
+	recorder := &recorder{}
+
+	// each message takes until its context is done, so the drain runs out of time on the first
+	slow := func(ctx context.Context, message *topics.Message) {
+		<-ctx.Done()
+		recorder.handle(ctx, message)
+	}
+	pool := NewPool(1, 10, Block, slow, recorder.setAside)
+	submit(pool, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pool.Run(ctx, 20*time.Millisecond)
+
+	if len(recorder.handled)+len(recorder.overflow) != 3 || len(recorder.overflow) < 2 {
+		t.Fatalf("expected the messages left after the drain to be set aside, handled %d and set aside %d",
+			len(recorder.handled), len(recorder.overflow))
+	}
+	for _, reason := range recorder.reasons {
+		if !errors.Is(reason, ErrShuttingDown) {
+			t.Errorf("expected messages to be set aside for shutting down, got %v", reason)
+		}
+	}
+}
+
+func TestSubmitOverflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		queued   int
+		overflow int
+	}{
+		{name: "drop oldest", policy: DropOldest, queued: 2, overflow: 0},
+		// only as many as the queues hold are set aside, the rest are discarded
+		{name: "dead letter", policy: DeadLetter, queued: 2, overflow: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := &recorder{}
+			pool := NewPool(1, 2, test.policy, recorder.handle, recorder.setAside)
+			submit(pool, 5)
+
+			if len(pool.queues[0]) != test.queued || len(pool.overflowed) != test.overflow {
+				t.Errorf("expected %d queued and %d set aside, got %d and %d",
+					test.queued, test.overflow, len(pool.queues[0]), len(pool.overflowed))
+			}
+
+			// what was set aside reaches the overflow handler once the pool runs
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			pool.Run(ctx, time.Second)
+			if len(recorder.overflow) != test.overflow {
+				t.Errorf("expected %d messages passed to the overflow handler, got %d", test.overflow, len(recorder.overflow))
+			}
+		})
+	}
+}
+
+func TestSubmitWithBlockedOverflow(t *testing.T) {
+	release := make(chan struct{})
+	blocked := func(ctx context.Context, message *topics.Message) { <-release }
+	overflowing := make(chan struct{}, 1)
+	setAside := func(message *topics.Message, reason error) {
+		select {
+		case overflowing <- struct{}{}:
+		default:
+		}
+		<-release
+	}
+	pool := NewPool(1, 2, DeadLetter, blocked, setAside)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pool.Run(ctx, time.Second)
+		close(done)
+	}()
+
+	// fill the queue and wait until the overflow handler is stuck on a message
+	submit(pool, 4)
+	select {
+	case <-overflowing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a message to be passed to the overflow handler")
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		submit(pool, 100)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		t.Error("expected submitting to carry on while the overflow handler is blocked")
+	}
+
+	close(release)
+	cancel()
+	<-done
+}
@@ -0,0 +1,189 @@
+// Package presence for tracking which devices are online
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"site/pkg/database"
+	"site/pkg/events"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Online is the state a device reports on connecting
+	Online = "online"
+	// Offline is the state a device reports on disconnecting, and its broker last will
+	Offline = "offline"
+
+	// timeFormat is understood as a datetime by every dialect
+	timeFormat = "2006-01-02 15:04:05"
+	// maxAddressLength matches the devices ip column
+	maxAddressLength = 64
+)
+
+// Report is sent by a device on afm/v1/heartbeat/<device_id> and afm/v1/status/<device_id>,
+// heartbeats leave out the state
+type Report struct {
+	State    string `json:"state,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+}
+
+// Tracker marks devices online as they are heard from and offline once they go quiet
+type Tracker struct {
+	database database.Executor
+	events   *events.Bus
+	timeout  time.Duration
+}
+
+// NewTracker creates a tracker that considers devices silent for longer than timeout offline
+func NewTracker(db database.Executor, bus *events.Bus, timeout time.Duration) *Tracker {
+	return &Tracker{database: db, events: bus, timeout: timeout}
+}
+
+// ParseReport decodes the report sent by a device, an empty payload is a bare heartbeat
+func ParseReport(payload []byte) (*Report, error) {
+	report := &Report{}
+	if len(payload) == 0 {
+		return report, nil
+	}
+
+	err := json.Unmarshal(payload, report)
+	return report, err
+}
+
+// Seen records that the device was heard from at the given time, marking it online if it was not
+func (tracker *Tracker) Seen(ctx context.Context, serial string, report *Report, seen time.Time) error {
+	if report.State == Offline {
+		return tracker.setOffline(ctx, serial)
+	}
+
+	device, err := tracker.load(ctx, serial)
+	if device == nil {
+		return err
+	}
+
+	if seen.IsZero() {
+		seen = time.Now()
+	}
+
+	values := map[string]string{"online": "1", "last_seen": seen.UTC().Format(timeFormat)}
+	if len(report.IP) > 0 && len(report.IP) <= maxAddressLength {
+		values["ip"] = report.IP
+	}
+	if len(report.Firmware) > 0 {
+		values["firmware"] = report.Firmware
+	}
+
+	// only the presence columns are written so a concurrent claim or registration is not undone
+	err = device.UpdateMany(ctx, tracker.database, values, map[string]string{"id": strconv.Itoa(device.ID)})
+	if err != nil {
+		return err
+	}
+
+	if device.Online == 0 {
+		logrus.Infof("device %s is online", serial)
+		tracker.notify(ctx, device, events.DeviceOnline)
+	}
+	return nil
+}
+
+// setOffline marks the device offline, e.g. when the broker publishes its last will
+func (tracker *Tracker) setOffline(ctx context.Context, serial string) error {
+	device, err := tracker.load(ctx, serial)
+	if device == nil {
+		return err
+	}
+
+	return tracker.markOffline(ctx, device)
+}
+
+// load retrieves the device, devices that have not registered yet are ignored
+func (tracker *Tracker) load(ctx context.Context, serial string) (*database.DeviceObject, error) {
+	device := database.DeviceObject{}
+	err := device.LoadByField(ctx, tracker.database, serial)
+	if err == database.ErrNotFound {
+		logrus.Debugf("ignoring presence of unknown device %s", serial)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+func (tracker *Tracker) markOffline(ctx context.Context, device *database.DeviceObject) error {
+	if device.Online == 0 {
+		return nil
+	}
+
+	err := device.UpdateMany(ctx, tracker.database, map[string]string{"online": "0"},
+		map[string]string{"id": strconv.Itoa(device.ID), "online": "1"})
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("device %s is offline", device.Serial)
+	tracker.notify(ctx, device, events.DeviceOffline)
+	return nil
+}
+
+// Sweep marks devices that have not been heard from within the timeout offline
+func (tracker *Tracker) Sweep(ctx context.Context) error {
+	devices, err := (&database.DeviceObject{}).Query(ctx, tracker.database, map[string]string{"online": "1"})
+	if err != nil {
+		return err
+	}
+
+	for index := range devices {
+		device := &devices[index]
+		if device.LastSeen.Valid && time.Since(device.LastSeen.Time) <= tracker.timeout {
+			continue
+		}
+
+		if err = tracker.markOffline(ctx, device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run sweeps for silent devices until the context is done
+func (tracker *Tracker) Run(ctx context.Context) {
+	if tracker.timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(tracker.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := tracker.Sweep(ctx); err != nil {
+				logrus.Warnf("failed to sweep for offline devices: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notify publishes the presence change to the owner of the device, if it has one
+func (tracker *Tracker) notify(ctx context.Context, device *database.DeviceObject, eventType string) {
+	if device.State != database.DeviceClaimed {
+		return
+	}
+
+	mapping := database.DeviceUserMappingObject{}
+	if err := mapping.LoadByField(ctx, tracker.database, strconv.Itoa(device.ID)); err != nil {
+		logrus.Warnf("unable to notify owner of device %s: %v", device.Serial, err)
+		return
+	}
+
+	tracker.events.Publish(events.Event{Type: eventType, UserID: mapping.UserID, DeviceID: device.ID, Data: map[string]string{"serial": device.Serial}})
+}
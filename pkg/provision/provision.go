@@ -0,0 +1,213 @@
+// Package provision for registering devices and letting users claim them
+package provision
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"site/pkg/database"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ClaimCodeLength is the length of the codes generated for devices without a label
+	ClaimCodeLength = 8
+	// MaxClaimCodeLength is the longest code a device can register with
+	MaxClaimCodeLength = 16
+	// MaxClaimAttempts is how many wrong codes a device accepts before its claim code expires
+	MaxClaimAttempts = 10
+	// ClaimCodeLifetime is how long a pending device keeps its claim code and failed attempts,
+	// registering again only starts afresh once it has passed
+	ClaimCodeLifetime = 24 * time.Hour
+
+	// claimAlphabet leaves out characters that are easily confused on a label
+	claimAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// Provisioning errors
+var (
+	ErrNotClaimed     = errors.New("device has not been claimed")
+	ErrAlreadyClaimed = errors.New("device has already been claimed")
+	ErrInvalidCode    = errors.New("claim code does not match")
+	ErrTooManyClaims  = errors.New("too many failed claims, the claim code must expire first")
+	ErrClaimExpired   = errors.New("claim code has expired, the device must register again")
+	ErrClaimConflict  = errors.New("another claim of the device is in progress")
+)
+
+// Registration is sent by a device on afm/v1/register/<device_id> when it starts,
+// the claim code is the one on its label, if any
+type Registration struct {
+	Model     string `json:"model"`
+	Firmware  string `json:"firmware"`
+	ClaimCode string `json:"claim_code,omitempty"`
+}
+
+// Registered is sent back on afm/v1/registered/<device_id>, a pending device shows the claim code
+type Registered struct {
+	State     string `json:"state"`
+	ClaimCode string `json:"claim_code,omitempty"`
+}
+
+// NewClaimCode generates a random code for a device to display
+func NewClaimCode() (string, error) {
+	var code strings.Builder
+
+	limit := big.NewInt(int64(len(claimAlphabet)))
+	for index := 0; index < ClaimCodeLength; index++ {
+		position, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(claimAlphabet[position.Int64()])
+	}
+
+	return code.String(), nil
+}
+
+// normalizeCode makes codes typed by a user comparable with the code on the label
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
+
+// Register records the device, unknown devices are added as pending with the claim code
+// they registered with, or a generated one. A pending device keeps its claim code and failed
+// attempts until the code expires, so registering again can neither choose a new code nor
+// reset the attempts. Claimed devices only have their details updated.
+func Register(ctx context.Context, db database.Executor, serial string, registration *Registration) (*database.DeviceObject, error) {
+	if len(registration.ClaimCode) > MaxClaimCodeLength {
+		return nil, fmt.Errorf("claim code from %s exceeds %d characters", serial, MaxClaimCodeLength)
+	}
+
+	device := database.DeviceObject{}
+	err := device.LoadByField(ctx, db, serial)
+	if err != nil && err != database.ErrNotFound {
+		return nil, err
+	}
+
+	found := err == nil
+	device.Serial = serial
+	if len(registration.Model) > 0 {
+		device.Model = registration.Model
+	}
+	if len(registration.Firmware) > 0 {
+		device.Firmware = registration.Firmware
+	}
+
+	now := time.Now().UTC()
+	if device.State != database.DeviceClaimed && (device.State != database.DevicePending || claimExpired(&device, now)) {
+		device.State = database.DevicePending
+		device.ClaimAttempts = 0
+		device.ClaimExpires = sql.NullTime{Time: now.Add(ClaimCodeLifetime), Valid: true}
+		device.ClaimCode = normalizeCode(registration.ClaimCode)
+		if len(device.ClaimCode) == 0 {
+			if device.ClaimCode, err = NewClaimCode(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if found {
+		err = device.Update(ctx, db)
+	} else {
+		logrus.Infof("device %s registered, waiting to be claimed", serial)
+		err = device.Create(ctx, db)
+	}
+
+	return &device, err
+}
+
+// claimExpired reports whether the claim code of the device can no longer be used
+func claimExpired(device *database.DeviceObject, now time.Time) bool {
+	return !device.ClaimExpires.Valid || !now.Before(device.ClaimExpires.Time)
+}
+
+// Reply returns the registered message for the device
+func Reply(device *database.DeviceObject) ([]byte, error) {
+	registered := Registered{State: device.State}
+	if device.State == database.DevicePending {
+		registered.ClaimCode = device.ClaimCode
+	}
+
+	return json.Marshal(&registered)
+}
+
+// Claim gives the pending device to the user when the code matches the one it registered with
+func Claim(ctx context.Context, db *sqlx.DB, userID int, serial, code string) (*database.DeviceObject, error) {
+	transaction, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := claim(ctx, transaction, userID, serial, code)
+
+	// failed attempts are counted even though the claim is refused
+	if err == nil || errors.Is(err, ErrInvalidCode) {
+		if commitErr := transaction.Commit(); commitErr != nil {
+			return nil, commitErr
+		}
+		return device, err
+	}
+
+	if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+		logrus.Warnf("failed to roll back claim of %s: %v", serial, rollbackErr)
+	}
+	return nil, err
+}
+
+func claim(ctx context.Context, transaction database.Executor, userID int, serial, code string) (*database.DeviceObject, error) {
+	device := database.DeviceObject{}
+	err := device.LoadByField(ctx, transaction, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case device.State == database.DeviceClaimed:
+		return nil, ErrAlreadyClaimed
+	case claimExpired(&device, time.Now().UTC()):
+		return nil, ErrClaimExpired
+	case device.ClaimAttempts >= MaxClaimAttempts:
+		return nil, ErrTooManyClaims
+	}
+
+	// the attempt is counted before the code is compared, only while the device is as it was
+	// loaded, so concurrent claims can neither both take the device nor guess past the limit
+	criteria := map[string]string{"state": database.DevicePending, "claim_attempts": strconv.Itoa(device.ClaimAttempts)}
+	device.ClaimAttempts++
+	err = device.UpdateIf(ctx, transaction, criteria)
+	if err == database.ErrNotFound {
+		return nil, ErrClaimConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(device.ClaimCode) == 0 || subtle.ConstantTimeCompare([]byte(device.ClaimCode), []byte(normalizeCode(code))) != 1 {
+		return nil, ErrInvalidCode
+	}
+
+	device.State = database.DeviceClaimed
+	device.ClaimCode = ""
+	device.ClaimAttempts = 0
+	device.ClaimExpires = sql.NullTime{}
+	if err = device.Update(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	mapping := database.DeviceUserMappingObject{UserID: userID, DeviceID: device.ID}
+	if err = mapping.Create(ctx, transaction); err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
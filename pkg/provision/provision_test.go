@@ -0,0 +1,224 @@
+// Package provision for registering devices and letting users claim them
+package provision
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"site/pkg/database"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func openDatabase(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dialect, err := database.LookupDialect(database.SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := dialect.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// register registers the device with the claim code, failing the test on error
+func register(t *testing.T, db *sqlx.DB, serial, code string) *database.DeviceObject {
+	t.Helper()
+
+	device, err := Register(context.Background(), db, serial, &Registration{Model: "cam", ClaimCode: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return device
+}
+
+func TestRegisterKeepsPendingClaim(t *testing.T) {
+	db := openDatabase(t)
+	ctx := context.Background()
+
+	first := register(t, db, "cam1", "")
+	if first.State != database.DevicePending || len(first.ClaimCode) != ClaimCodeLength {
+		t.Fatalf("expected a pending device with a generated code, got %+v", first)
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := Claim(ctx, db, 1, "cam1", "WRONG"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("expected the wrong code to be refused, got %v", err)
+		}
+	}
+
+	// registering again neither picks a new code nor forgets the failed attempts
+	again := register(t, db, "cam1", "CHOSEN")
+	if again.ClaimCode != first.ClaimCode || again.ClaimAttempts != 3 {
+		t.Errorf("expected the code %s and 3 attempts to be kept, got %s and %d", first.ClaimCode, again.ClaimCode, again.ClaimAttempts)
+	}
+
+	// once the code has expired the device starts afresh
+	if err := again.UpdateMany(ctx, db, map[string]string{"claim_expires": "2000-01-01 00:00:00"},
+		map[string]string{"serial": "cam1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Claim(ctx, db, 1, "cam1", first.ClaimCode); !errors.Is(err, ErrClaimExpired) {
+		t.Errorf("expected the expired code to be refused, got %v", err)
+	}
+
+	renewed := register(t, db, "cam1", "chosen")
+	if renewed.ClaimCode != "CHOSEN" || renewed.ClaimAttempts != 0 {
+		t.Errorf("expected a new code and no attempts after expiry, got %s and %d", renewed.ClaimCode, renewed.ClaimAttempts)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	db := openDatabase(t)
+	ctx := context.Background()
+	device := register(t, db, "cam1", "abcd-1234")
+
+	tests := []struct {
+		name string
+		code string
+		err  error
+	}{
+		{name: "wrong code", code: "ABCD1235", err: ErrInvalidCode},
+		{name: "typed code", code: " abcd-1234 ", err: nil},
+		{name: "claimed", code: "ABCD1234", err: ErrAlreadyClaimed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Claim(ctx, db, 1, "cam1", test.code)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	mappings, err := (&database.DeviceUserMappingObject{}).Query(ctx, db, map[string]string{"device_id": strconv.Itoa(device.ID)})
+	if err != nil || len(mappings) != 1 {
+		t.Errorf("expected the device to be given to the user once, have %d mappings: %v", len(mappings), err)
+	}
+
+	if claimed := register(t, db, "cam1", "OTHER"); claimed.State != database.DeviceClaimed || len(claimed.ClaimCode) != 0 {
+		t.Errorf("expected registering again to leave the device claimed, got %+v", claimed)
+	}
+}
+
+func TestClaimAttemptsLimit(t *testing.T) {
+	db := openDatabase(t)
+	register(t, db, "cam1", "RIGHT")
+
+	for attempt := 0; attempt < MaxClaimAttempts; attempt++ {
+		if _, err := Claim(context.Background(), db, 1, "cam1", "WRONG"); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("attempt %d: expected the wrong code to be refused, got %v", attempt, err)
+		}
+	}
+
+	if _, err := Claim(context.Background(), db, 1, "cam1", "RIGHT"); !errors.Is(err, ErrTooManyClaims) {
+		t.Errorf("expected the device to refuse claims after %d failures, got %v", MaxClaimAttempts, err)
+	}
+}
+
+func TestClaimConcurrently(t *testing.T) {
+	db := openDatabase(t)
+	device := register(t, db, "cam1", "RIGHT")
+
+	// every user has the right code, only one of them gets the device
+	const users = 8
+	results := make(chan error, users)
+	started := &sync.WaitGroup{}
+	started.Add(users)
+	for user := 1; user <= users; user++ {
+		go func(user int) {
+			started.Done()
+			started.Wait()
+			_, err := Claim(context.Background(), db, user, "cam1", "RIGHT")
+			results <- err
+		}(user)
+	}
+
+	claimed := 0
+	for user := 0; user < users; user++ {
+		if err := <-results; err == nil {
+			claimed++
+		} else {
+			t.Logf("claim failed: %v", err)
+		}
+	}
+
+	mappings, err := (&database.DeviceUserMappingObject{}).Query(context.Background(), db, map[string]string{"device_id": strconv.Itoa(device.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed != 1 || len(mappings) != 1 {
+		t.Errorf("expected exactly one claim to succeed, %d did with %d mappings", claimed, len(mappings))
+	}
+}
+
+// racing runs before ahead of its first statement, standing in for a claim that gets there first
+type racing struct {
+	database.Executor
+	before func()
+}
+
+func (racing *racing) ExecContext(ctx context.Context, query string, arguments ...interface{}) (sql.Result, error) {
+	if racing.before != nil {
+		racing.before()
+		racing.before = nil
+	}
+	return racing.Executor.ExecContext(ctx, query, arguments...)
+}
+
+func TestClaimLosesRace(t *testing.T) {
+	db := openDatabase(t)
+	device := register(t, db, "cam1", "RIGHT")
+
+	// the device is claimed by someone else after this claim loaded it as pending
+	executor := &racing{Executor: db, before: func() {
+		if _, err := Claim(context.Background(), db, 1, "cam1", "RIGHT"); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	if _, err := claim(context.Background(), executor, 2, "cam1", "RIGHT"); !errors.Is(err, ErrClaimConflict) {
+		t.Errorf("expected the claim to find the device taken, got %v", err)
+	}
+
+	mappings, err := (&database.DeviceUserMappingObject{}).Query(context.Background(), db, map[string]string{"device_id": strconv.Itoa(device.ID)})
+	if err != nil || len(mappings) != 1 || mappings[0].UserID != 1 {
+		t.Errorf("expected the device to stay with the first user, got %+v: %v", mappings, err)
+	}
+}
+
+func TestClaimExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		expires sql.NullTime
+		expired bool
+	}{
+		{name: "never set", expired: true},
+		{name: "passed", expires: sql.NullTime{Time: now.Add(-time.Second), Valid: true}, expired: true},
+		{name: "ahead", expires: sql.NullTime{Time: now.Add(time.Second), Valid: true}, expired: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if expired := claimExpired(&database.DeviceObject{ClaimExpires: test.expires}, now); expired != test.expired {
+				t.Errorf("expected expired to be %v", test.expired)
+			}
+		})
+	}
+}
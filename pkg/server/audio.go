@@ -0,0 +1,96 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"site/pkg/database"
+	"site/pkg/topics"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// audioContentTypes maps the formats accepted from devices to their content type
+var audioContentTypes = map[string]string{
+	topics.WAVFormat:  "audio/wav",
+	topics.OpusFormat: "audio/ogg; codecs=opus",
+}
+
+// AudioEntry describes an audio clip in the listing
+type AudioEntry struct {
+	ID         int       `json:"id"`
+	DeviceID   int       `json:"device_id"`
+	Name       string    `json:"name"`
+	Format     string    `json:"format"`
+	DurationMS int64     `json:"duration_ms"`
+	Size       int64     `json:"size"`
+	ImageID    int       `json:"image_id,omitempty"`
+	VideoID    int       `json:"video_id,omitempty"`
+	Created    time.Time `json:"created"`
+	URL        string    `json:"url"`
+}
+
+// ListAudio returns the audio clips of the logged in user, newest first
+func (srv *Server) ListAudio(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	clips, err := (&database.AudioObject{}).Query(r.Context(), srv.Database,
+		map[string]string{"user_id": strconv.Itoa(user.ID), "active": "1"})
+	if err != nil {
+		logrus.Errorf("failed to list audio: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	sort.Slice(clips, func(i, j int) bool { return clips[i].ID > clips[j].ID })
+
+	entries := make([]AudioEntry, 0, len(clips))
+	for _, clip := range clips {
+		entries = append(entries, AudioEntry{
+			ID:         clip.ID,
+			DeviceID:   clip.DeviceID,
+			Name:       filepath.Base(clip.Path),
+			Format:     clip.Format,
+			DurationMS: clip.DurationMS,
+			Size:       clip.Size,
+			ImageID:    clip.ImageID,
+			VideoID:    clip.VideoID,
+			Created:    clip.Created,
+			URL:        "/audio/" + strconv.Itoa(clip.ID),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// ServeAudio streams an audio clip of the logged in user with the content type of its format
+func (srv *Server) ServeAudio(w http.ResponseWriter, r *http.Request) {
+	clipID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: "invalid audio id"})
+		return
+	}
+
+	clip := database.AudioObject{ID: clipID}
+	err = clip.Load(r.Context(), srv.Database)
+	if err == database.ErrNotFound || (err == nil && (clip.UserID != CurrentUser(r).ID || clip.Active != 1)) {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotFound})
+		return
+	}
+
+	if err != nil {
+		logrus.Errorf("failed to load audio %d: %v", clipID, err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if contentType, found := audioContentTypes[clip.Format]; found {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	serveFile(w, r, clip.Path)
+}
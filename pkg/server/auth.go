@@ -0,0 +1,137 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"site/pkg/database"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TokenCookie is the cookie holding the user token for browsers
+	TokenCookie = "afm_token"
+
+	bearerPrefix = "Bearer "
+	maxLoginSize = 4096
+)
+
+// userKey is the request context key of the authenticated user
+type userKey struct{}
+
+// requestToken returns the token from the authorization header, falling back to the token cookie
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, bearerPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix))
+	}
+
+	if cookie, err := r.Cookie(TokenCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// Authenticate only passes requests carrying the token of an active user to next,
+// the user is available to it through CurrentUser
+func (srv *Server) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if len(token) == 0 {
+			writeResponse(w, &HTTPResponse{Code: http.StatusUnauthorized, Message: "missing token"})
+			return
+		}
+
+		user, err := srv.tokenUser(r.Context(), token)
+		if err != nil {
+			logrus.Errorf("failed to look up user token: %v", err)
+			writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+			return
+		}
+
+		if user == nil {
+			writeResponse(w, &HTTPResponse{Code: http.StatusUnauthorized, Message: "invalid token"})
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// tokenUser returns the active user with the token, nil if there is none
+func (srv *Server) tokenUser(ctx context.Context, token string) (*database.UserObject, error) {
+	users, err := (&database.UserObject{}).Query(ctx, srv.Database, map[string]string{"token": token, "active": "1"})
+	if err != nil || len(users) != 1 {
+		return nil, err
+	}
+	return &users[0], nil
+}
+
+// LoginRequest is the body of a login, the token is the one the user was given
+type LoginRequest struct {
+	Token string `json:"token"`
+}
+
+// Login checks the token and keeps it in a cookie, so the pages and the requests they make are
+// authenticated without having to send it themselves
+func (srv *Server) Login(w http.ResponseWriter, r *http.Request) {
+	request := LoginRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLoginSize)).Decode(&request); err != nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: "invalid login: " + err.Error()})
+		return
+	}
+
+	user, err := srv.tokenUser(r.Context(), strings.TrimSpace(request.Token))
+	if err != nil {
+		logrus.Errorf("failed to look up user token: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if user == nil || len(request.Token) == 0 {
+		writeResponse(w, &HTTPResponse{Code: http.StatusUnauthorized, Message: "invalid token"})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: TokenCookie, Value: user.Token, Path: "/", HttpOnly: true,
+		Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+
+	logrus.Infof("user %d logged in", user.ID)
+	writeResponse(w, &HTTPResponse{Code: http.StatusOK})
+}
+
+// Logout removes the token cookie
+func (srv *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: TokenCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true,
+		Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+
+	writeResponse(w, &HTTPResponse{Code: http.StatusOK})
+}
+
+// CurrentUser returns the user authenticated for the request, nil if there is none
+func CurrentUser(r *http.Request) *database.UserObject {
+	user, _ := r.Context().Value(userKey{}).(*database.UserObject)
+	return user
+}
+
+// writeResponse sends the response as json with its status code
+func writeResponse(w http.ResponseWriter, response *HTTPResponse) {
+	if len(response.Message) == 0 {
+		response.Message = http.StatusText(response.Code)
+	}
+
+	writeJSON(w, response.Code, response)
+}
+
+// writeJSON sends value as json with the status code
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logrus.Errorf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"site/pkg/commands"
+	"site/pkg/database"
+	"site/pkg/provision"
+	"site/pkg/topics"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxCommandSize limits the body of a command request
+	maxCommandSize = 64 * 1024
+)
+
+// DeviceEntry describes a device in the listing
+type DeviceEntry struct {
+	ID       int        `json:"id"`
+	Serial   string     `json:"serial"`
+	Model    string     `json:"model"`
+	Firmware string     `json:"firmware"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	IP       string     `json:"ip,omitempty"`
+}
+
+// newDeviceEntry describes the device for the api
+func newDeviceEntry(device *database.DeviceObject) *DeviceEntry {
+	entry := &DeviceEntry{ID: device.ID, Serial: device.Serial, Model: device.Model, Firmware: device.Firmware,
+		Online: device.Online != 0, IP: device.IP}
+	if device.LastSeen.Valid {
+		entry.LastSeen = &device.LastSeen.Time
+	}
+	return entry
+}
+
+// CommandRequest is the body of a command sent through the api
+type CommandRequest struct {
+	Type string          `json:"type"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// CommandResponse reports the outcome of a command
+type CommandResponse struct {
+	ID  string        `json:"id"`
+	Ack *commands.Ack `json:"ack,omitempty"`
+	Err string        `json:"error,omitempty"`
+}
+
+// ClaimRequest is the body of a claim, the code is the one shown on or printed on the device
+type ClaimRequest struct {
+	Serial    string `json:"serial"`
+	ClaimCode string `json:"claim_code"`
+}
+
+// ownedDevice loads the device with the serial and its mapping if it belongs to the logged in user, nil if it does not
+func (srv *Server) ownedDevice(r *http.Request, serial string) (*database.DeviceObject, *database.DeviceUserMappingObject, error) {
+	device := database.DeviceObject{}
+	err := device.LoadByField(r.Context(), srv.Database, serial)
+	if err == database.ErrNotFound {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mappings, err := (&database.DeviceUserMappingObject{}).Query(r.Context(), srv.Database,
+		map[string]string{"device_id": strconv.Itoa(device.ID), "user_id": strconv.Itoa(CurrentUser(r).ID), "active": "1"})
+	if err != nil || len(mappings) == 0 {
+		return nil, nil, err
+	}
+
+	return &device, &mappings[0], nil
+}
+
+// ListDevices returns the devices of the logged in user
+func (srv *Server) ListDevices(w http.ResponseWriter, r *http.Request) {
+	mappings, err := (&database.DeviceUserMappingObject{}).Query(r.Context(), srv.Database,
+		map[string]string{"user_id": strconv.Itoa(CurrentUser(r).ID), "active": "1"})
+	if err != nil {
+		logrus.Errorf("failed to list devices: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	entries := make([]DeviceEntry, 0, len(mappings))
+	for _, mapping := range mappings {
+		device := database.DeviceObject{ID: mapping.DeviceID}
+		if err = device.Load(r.Context(), srv.Database); err != nil {
+			logrus.Warnf("failed to load device %d: %v", mapping.DeviceID, err)
+			continue
+		}
+
+		entries = append(entries, *newDeviceEntry(&device))
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// SendCommand sends a command to a device of the logged in user and waits for the device to acknowledge it
+func (srv *Server) SendCommand(w http.ResponseWriter, r *http.Request) {
+	if srv.Commands == nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotImplemented, Message: "commands are not available"})
+		return
+	}
+
+	device, _, err := srv.ownedDevice(r, mux.Vars(r)["deviceSerial"])
+	if err != nil {
+		logrus.Errorf("failed to look up device: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if device == nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotFound})
+		return
+	}
+
+	request := CommandRequest{}
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCommandSize)).Decode(&request); err != nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: "invalid command: " + err.Error()})
+		return
+	}
+
+	command, ack, err := srv.Commands.Send(r.Context(), device.Serial, request.Type, request.Args)
+	switch {
+	case errors.Is(err, commands.ErrUnknownCommand), command == nil && err != nil:
+		writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: err.Error()})
+	case errors.Is(err, commands.ErrCommandTimeout):
+		writeJSON(w, http.StatusGatewayTimeout, &CommandResponse{ID: command.ID, Err: err.Error()})
+	case err != nil:
+		// the client went away before the device answered
+		logrus.Debugf("command %s abandoned: %v", command.ID, err)
+	default:
+		logrus.Infof("command %s %s to %s acknowledged in %v: %s", command.ID, command.Type, device.Serial,
+			time.Since(command.Issued).Round(time.Millisecond), ack.Status)
+		writeJSON(w, http.StatusOK, &CommandResponse{ID: command.ID, Ack: ack})
+	}
+}
+
+// ClaimDevice gives a pending device to the logged in user and tells the device it has been registered
+func (srv *Server) ClaimDevice(w http.ResponseWriter, r *http.Request) {
+	request := ClaimRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCommandSize)).Decode(&request); err != nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: "invalid claim: " + err.Error()})
+		return
+	}
+
+	device, err := provision.Claim(r.Context(), srv.Database, CurrentUser(r).ID, request.Serial, request.ClaimCode)
+	switch {
+	case err == database.ErrNotFound, errors.Is(err, provision.ErrInvalidCode):
+		// unknown devices and wrong codes look the same so serials cannot be probed
+		writeResponse(w, &HTTPResponse{Code: http.StatusForbidden, Message: "unknown device or claim code"})
+		return
+	case errors.Is(err, provision.ErrAlreadyClaimed), errors.Is(err, provision.ErrTooManyClaims),
+		errors.Is(err, provision.ErrClaimExpired), errors.Is(err, provision.ErrClaimConflict):
+		writeResponse(w, &HTTPResponse{Code: http.StatusConflict, Message: err.Error()})
+		return
+	case err != nil:
+		logrus.Errorf("failed to claim %s: %v", request.Serial, err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	logrus.Infof("device %s claimed by user %d", device.Serial, CurrentUser(r).ID)
+
+	if reply, err := provision.Reply(device); err == nil && srv.Publish != nil {
+		srv.Publish(topics.TopicPrefix+"/"+topics.RegisteredTopic+"/"+device.Serial, reply)
+	}
+
+	writeJSON(w, http.StatusOK, newDeviceEntry(device))
+}
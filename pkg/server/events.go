@@ -0,0 +1,66 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"site/pkg/events"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// keepAliveInterval keeps idle event streams from being closed by proxies
+	keepAliveInterval = 30 * time.Second
+)
+
+// StreamEvents sends the events of the logged in user as server sent events until the client disconnects
+func (srv *Server) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || srv.Events == nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotImplemented, Message: "streaming is not supported"})
+		return
+	}
+
+	subscription := srv.Events.Subscribe(CurrentUser(r).ID)
+	defer srv.Events.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+
+		select {
+		case event := <-subscription.Events():
+			err = writeEvent(w, &event)
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err != nil {
+			logrus.Debugf("event subscriber went away: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event *events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
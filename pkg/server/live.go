@@ -0,0 +1,156 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"site/pkg/database"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StaleHeader is set when the image served is a placeholder because the device has gone quiet
+	StaleHeader = "X-Live-Stale"
+	// LastCaptureHeader carries the time of the latest image when a placeholder is served
+	LastCaptureHeader = "X-Last-Capture"
+
+	placeholderWidth  = 320
+	placeholderHeight = 240
+	placeholderShade  = 0x80
+	sniffLength       = 512
+)
+
+var (
+	placeholderOnce  sync.Once
+	placeholderImage []byte
+)
+
+// placeholder returns a plain grey png shown in place of a stale or missing capture
+func placeholder() []byte {
+	placeholderOnce.Do(func() {
+		canvas := image.NewGray(image.Rect(0, 0, placeholderWidth, placeholderHeight))
+		for index := range canvas.Pix {
+			canvas.Pix[index] = placeholderShade
+		}
+
+		var buffer bytes.Buffer
+		if err := png.Encode(&buffer, canvas); err != nil {
+			logrus.Errorf("failed to encode placeholder: %v", err)
+		}
+		placeholderImage = buffer.Bytes()
+	})
+	return placeholderImage
+}
+
+// servePlaceholder sends the placeholder, marking the response as stale
+func servePlaceholder(w http.ResponseWriter, lastCapture time.Time) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set(StaleHeader, "true")
+	if !lastCapture.IsZero() {
+		w.Header().Set(LastCaptureHeader, lastCapture.UTC().Format(http.TimeFormat))
+	}
+
+	data := placeholder()
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if _, err := w.Write(data); err != nil {
+		logrus.Debugf("failed to send placeholder: %v", err)
+	}
+}
+
+// liveCriteria returns the image criteria for the request, false if the requested
+// device does not belong to the logged in user
+func (srv *Server) liveCriteria(r *http.Request) (map[string]string, bool, error) {
+	user := CurrentUser(r)
+	criteria := map[string]string{"user_id": strconv.Itoa(user.ID), "active": "1"}
+
+	serial, found := mux.Vars(r)["deviceSerial"]
+	if !found {
+		return criteria, true, nil
+	}
+
+	device, _, err := srv.ownedDevice(r, serial)
+	if err != nil || device == nil {
+		return nil, false, err
+	}
+
+	criteria["device_id"] = strconv.Itoa(device.ID)
+	return criteria, true, nil
+}
+
+// RetrieveLiveImage serves the latest image from the device, or any device of the user,
+// falling back to a placeholder when there is none or it is older than the staleness threshold
+func (srv *Server) RetrieveLiveImage(w http.ResponseWriter, r *http.Request) {
+	criteria, allowed, err := srv.liveCriteria(r)
+	if err != nil {
+		logrus.Errorf("failed to look up live device: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if !allowed {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotFound})
+		return
+	}
+
+	latest := database.ImageObject{}
+	err = latest.LoadLatest(r.Context(), srv.Database, criteria)
+	if err == database.ErrNotFound {
+		servePlaceholder(w, time.Time{})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("failed to load the latest image: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	file, err := os.Open(filepath.Clean(latest.Path))
+	if err != nil {
+		logrus.Errorf("unable to open image %d: %v", latest.ID, err)
+		servePlaceholder(w, time.Time{})
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		logrus.Errorf("failed to stat image %d: %v", latest.ID, err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if srv.LiveStaleness > 0 && time.Since(fileInfo.ModTime()) > srv.LiveStaleness {
+		servePlaceholder(w, fileInfo.ModTime())
+		return
+	}
+
+	// images are never rewritten so the id identifies the content
+	w.Header().Set("ETag", `"image-`+strconv.Itoa(latest.ID)+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", detectContentType(file))
+
+	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
+}
+
+// detectContentType sniffs the content of the file, leaving it positioned at the start
+func detectContentType(file io.ReadSeeker) string {
+	header := make([]byte, sniffLength)
+	count, _ := io.ReadFull(file, header)
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		logrus.Warnf("failed to rewind after sniffing: %v", err)
+	}
+
+	return http.DetectContentType(header[:count])
+}
@@ -0,0 +1,217 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"site/pkg/database"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultFrameRate is the frame rate viewers are capped at when none is configured
+	DefaultFrameRate = 5
+)
+
+// LiveFrame is an image as it arrives from a device
+type LiveFrame struct {
+	UserID      int
+	DeviceID    int
+	ContentType string
+	Data        []byte
+}
+
+// viewer is a connected stream, frames holds only the most recent frame so a slow
+// viewer skips frames rather than holding up ingestion or other viewers
+type viewer struct {
+	userID   int
+	deviceID int
+	frames   chan *LiveFrame
+}
+
+// Broadcaster fans the frames arriving from devices out to the viewers of them
+type Broadcaster struct {
+	lock    sync.RWMutex
+	viewers map[*viewer]struct{}
+}
+
+// NewBroadcaster creates a broadcaster without viewers
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{viewers: make(map[*viewer]struct{})}
+}
+
+// Publish hands the frame to every viewer of the device without blocking,
+// replacing any frame a viewer has not yet sent
+func (broadcaster *Broadcaster) Publish(frame *LiveFrame) {
+	broadcaster.lock.RLock()
+	defer broadcaster.lock.RUnlock()
+
+	for current := range broadcaster.viewers {
+		if current.userID != frame.UserID || (current.deviceID != 0 && current.deviceID != frame.DeviceID) {
+			continue
+		}
+
+		select {
+		case current.frames <- frame:
+			continue
+		default:
+		}
+
+		// drop the pending frame in favor of the newer one
+		select {
+		case <-current.frames:
+		default:
+		}
+
+		select {
+		case current.frames <- frame:
+		default:
+		}
+	}
+}
+
+// subscribe adds a viewer of the user, limited to a device unless deviceID is 0
+func (broadcaster *Broadcaster) subscribe(userID, deviceID int) *viewer {
+	current := &viewer{userID: userID, deviceID: deviceID, frames: make(chan *LiveFrame, 1)}
+
+	broadcaster.lock.Lock()
+	broadcaster.viewers[current] = struct{}{}
+	broadcaster.lock.Unlock()
+
+	return current
+}
+
+func (broadcaster *Broadcaster) unsubscribe(current *viewer) {
+	broadcaster.lock.Lock()
+	delete(broadcaster.viewers, current)
+	broadcaster.lock.Unlock()
+}
+
+// Viewers returns the number of connected viewers
+func (broadcaster *Broadcaster) Viewers() int {
+	broadcaster.lock.RLock()
+	defer broadcaster.lock.RUnlock()
+
+	return len(broadcaster.viewers)
+}
+
+// frameInterval returns the time between frames for the viewer, who may ask for
+// fewer frames per second than the server allows with the fps parameter
+func (srv *Server) frameInterval(r *http.Request) time.Duration {
+	frameRate := srv.LiveFrameRate
+	if frameRate <= 0 {
+		frameRate = DefaultFrameRate
+	}
+
+	if requested, err := strconv.Atoi(r.URL.Query().Get("fps")); err == nil && requested > 0 && requested < frameRate {
+		frameRate = requested
+	}
+
+	return time.Second / time.Duration(frameRate)
+}
+
+// latestFrame loads the most recent stored image so a viewer does not start with a blank stream
+func (srv *Server) latestFrame(r *http.Request, criteria map[string]string) *LiveFrame {
+	latest := database.ImageObject{}
+	if err := latest.LoadLatest(r.Context(), srv.Database, criteria); err != nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Clean(latest.Path))
+	if err != nil {
+		return nil
+	}
+
+	return &LiveFrame{UserID: latest.UserID, DeviceID: latest.DeviceID, ContentType: http.DetectContentType(data), Data: data}
+}
+
+// StreamLive pushes each new image from the device, or any device of the user, as
+// a multipart/x-mixed-replace stream until the viewer disconnects
+func (srv *Server) StreamLive(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || srv.Broadcaster == nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotImplemented, Message: "streaming is not supported"})
+		return
+	}
+
+	criteria, allowed, err := srv.liveCriteria(r)
+	if err != nil {
+		logrus.Errorf("failed to look up live device: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if !allowed {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotFound})
+		return
+	}
+
+	deviceID, _ := strconv.Atoi(criteria["device_id"])
+	current := srv.Broadcaster.subscribe(CurrentUser(r).ID, deviceID)
+	defer srv.Broadcaster.unsubscribe(current)
+
+	parts := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+parts.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if frame := srv.latestFrame(r, criteria); frame != nil {
+		if err = writeFrame(parts, frame); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	streamFrames(r.Context(), srv.frameInterval(r), current, func(frame *LiveFrame) error {
+		if err := writeFrame(parts, frame); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
+
+// streamFrames sends the frames of the viewer no faster than the interval until the context is done
+func streamFrames(ctx context.Context, interval time.Duration, current *viewer, send func(frame *LiveFrame) error) {
+	for {
+		select {
+		case frame := <-current.frames:
+			if err := send(frame); err != nil {
+				logrus.Debugf("live viewer went away: %v", err)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		// the mailbox keeps the newest frame while waiting out the interval
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func writeFrame(parts *multipart.Writer, frame *LiveFrame) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", frame.ContentType)
+	header.Set("Content-Length", strconv.Itoa(len(frame.Data)))
+
+	part, err := parts.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = part.Write(frame.Data)
+	return err
+}
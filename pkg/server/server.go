@@ -2,11 +2,14 @@
 package server
 
 import (
-	"io"
 	"net/http"
-	"os"
-	"strconv"
+	"site/pkg/commands"
+	"site/pkg/events"
+	"site/pkg/settings"
+	"site/pkg/topics"
+	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,6 +19,28 @@ type HTTPResponse struct {
 	Message string `json:"message,omitempty"`
 }
 
+// Server serves the media and state of the logged in user
+type Server struct {
+	Database *sqlx.DB
+	// LiveStaleness is how old the latest image may be before live view reports the device as quiet
+	LiveStaleness time.Duration
+	// LiveFrameRate caps the frames per second sent to each live stream viewer
+	LiveFrameRate int
+	Broadcaster   *Broadcaster
+	Events        *events.Bus
+	Commands      *commands.Dispatcher
+	// Publish sends messages to devices through the broker
+	Publish topics.Publisher
+	// PublishRetained sends messages the broker keeps for devices that connect later
+	PublishRetained topics.Publisher
+	Schemas         *settings.Schemas
+}
+
+// NewServer creates a server reading from the database
+func NewServer(db *sqlx.DB) *Server {
+	return &Server{Database: db}
+}
+
 // GenerateHomePage generates the home page for this site
 func GenerateHomePage(w http.ResponseWriter, r *http.Request) {
 	homePage := Page{title: "AFM"}
@@ -28,34 +53,6 @@ func GenerateHomePage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// RetrieveLiveImage returns the live image from the camera
-func RetrieveLiveImage(w http.ResponseWriter, r *http.Request) {
-	logrus.Info("serving up an image")
-	file, err := os.Open("web/images/avatar.webp")
-	if err != nil {
-		logrus.Errorf("unable to open image")
-		return
-	}
-
-	defer file.Close()
-
-	w.Header().Set("Content-Type", "image/webp")
-	fileInfo, err := file.Stat()
-	if err != nil {
-		logrus.Error("failed to stat file")
-		return
-	}
-	w.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
-	bytesWritten, err := io.Copy(w, file)
-	if err != nil {
-		logrus.Error("failed to serve file")
-		return
-	}
-	if bytesWritten != fileInfo.Size() {
-		logrus.Error("incorrect amount of data copied")
-	}
-}
-
 // HandleFavoriteIcon will serve up a favorite as desired
 func HandleFavoriteIcon(w http.ResponseWriter, r *http.Request) {
 	logrus.Error("favicon served w/ chocolate")
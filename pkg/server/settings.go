@@ -0,0 +1,94 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"site/pkg/events"
+	"site/pkg/settings"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// SettingsResponse is what the owner wants the device to use against what it reports using
+type SettingsResponse struct {
+	Desired  map[string]string `json:"desired"`
+	Reported map[string]string `json:"reported"`
+	Pending  map[string]string `json:"pending"`
+	Schema   settings.Schema   `json:"schema"`
+}
+
+// settingsResponse builds the settings of the device for the owner
+func (srv *Server) settingsResponse(r *http.Request, deviceID, mappingID int, schema settings.Schema) (*SettingsResponse, error) {
+	desired, err := settings.Desired(r.Context(), srv.Database, mappingID)
+	if err != nil {
+		return nil, err
+	}
+
+	reported, err := settings.Reported(r.Context(), srv.Database, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SettingsResponse{Desired: desired, Reported: reported, Pending: settings.Diff(desired, reported), Schema: schema}, nil
+}
+
+// DeviceSettings returns the desired and reported settings of a device of the logged in user
+// and on PUT replaces the desired settings, sending them to the device
+func (srv *Server) DeviceSettings(w http.ResponseWriter, r *http.Request) {
+	device, mapping, err := srv.ownedDevice(r, mux.Vars(r)["deviceSerial"])
+	if err != nil {
+		logrus.Errorf("failed to look up device: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if device == nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotFound})
+		return
+	}
+
+	schema := srv.Schemas.For(device.Model)
+
+	if r.Method == http.MethodPut {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCommandSize))
+		if err != nil {
+			writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: "invalid settings: " + err.Error()})
+			return
+		}
+
+		// values are accepted as strings or as json numbers and booleans
+		values, err := settings.ParseReported(body)
+		if err != nil {
+			writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: "invalid settings: " + err.Error()})
+			return
+		}
+
+		if err = schema.Validate(values); err != nil {
+			writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+
+		if err = settings.SetDesired(r.Context(), srv.Database, mapping.ID, schema, values); err != nil {
+			logrus.Errorf("failed to store settings for %s: %v", device.Serial, err)
+			writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+			return
+		}
+
+		if payload, err := settings.Payload(schema, values); err == nil && srv.PublishRetained != nil {
+			srv.PublishRetained(settings.DeviceConfigTopic(device.Serial), payload)
+		}
+
+		srv.Events.Publish(events.Event{Type: events.SettingsChanged, UserID: mapping.UserID, DeviceID: device.ID, Data: values})
+	}
+
+	response, err := srv.settingsResponse(r, device.ID, mapping.ID, schema)
+	if err != nil {
+		logrus.Errorf("failed to load settings for %s: %v", device.Serial, err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
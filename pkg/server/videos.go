@@ -0,0 +1,119 @@
+// Package server is made up of modules related to the web server
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"site/pkg/database"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// videoContentTypes maps the containers reported by devices to their content type
+var videoContentTypes = map[string]string{
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+	"mkv":  "video/x-matroska",
+	"mov":  "video/quicktime",
+	"avi":  "video/x-msvideo",
+	"ts":   "video/mp2t",
+}
+
+// VideoEntry describes a video in the listing
+type VideoEntry struct {
+	ID         int       `json:"id"`
+	DeviceID   int       `json:"device_id"`
+	Name       string    `json:"name"`
+	DurationMS int64     `json:"duration_ms"`
+	Container  string    `json:"container"`
+	Codec      string    `json:"codec"`
+	Size       int64     `json:"size"`
+	Created    time.Time `json:"created"`
+	URL        string    `json:"url"`
+}
+
+// ListVideos returns the videos of the logged in user, newest first
+func (srv *Server) ListVideos(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	videos, err := (&database.VideoObject{}).Query(r.Context(), srv.Database,
+		map[string]string{"user_id": strconv.Itoa(user.ID), "active": "1"})
+	if err != nil {
+		logrus.Errorf("failed to list videos: %v", err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	sort.Slice(videos, func(i, j int) bool { return videos[i].ID > videos[j].ID })
+
+	entries := make([]VideoEntry, 0, len(videos))
+	for _, video := range videos {
+		entries = append(entries, VideoEntry{
+			ID:         video.ID,
+			DeviceID:   video.DeviceID,
+			Name:       filepath.Base(video.Path),
+			DurationMS: video.DurationMS,
+			Container:  video.Container,
+			Codec:      video.Codec,
+			Size:       video.Size,
+			Created:    video.Created,
+			URL:        "/videos/" + strconv.Itoa(video.ID),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// ServeVideo streams a video of the logged in user, supporting range requests for seeking
+func (srv *Server) ServeVideo(w http.ResponseWriter, r *http.Request) {
+	videoID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeResponse(w, &HTTPResponse{Code: http.StatusBadRequest, Message: "invalid video id"})
+		return
+	}
+
+	video := database.VideoObject{ID: videoID}
+	err = video.Load(r.Context(), srv.Database)
+	if err == database.ErrNotFound || (err == nil && (video.UserID != CurrentUser(r).ID || video.Active != 1)) {
+		// other users videos are indistinguishable from missing ones
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotFound})
+		return
+	}
+
+	if err != nil {
+		logrus.Errorf("failed to load video %d: %v", videoID, err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	if contentType, found := videoContentTypes[video.Container]; found {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	serveFile(w, r, video.Path)
+}
+
+// serveFile sends the file using http.ServeContent which handles range and conditional requests
+func serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		logrus.Errorf("unable to open %s: %v", path, err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusNotFound})
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		logrus.Errorf("failed to stat %s: %v", path, err)
+		writeResponse(w, &HTTPResponse{Code: http.StatusInternalServerError})
+		return
+	}
+
+	http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
+}
@@ -0,0 +1,158 @@
+// Package settings for keeping the settings of a device in line with what its owner wants
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Field types a schema can declare
+const (
+	IntField    = "int"
+	BoolField   = "bool"
+	StringField = "string"
+	EnumField   = "enum"
+
+	// MaxNameLength is the longest setting name that can be stored
+	MaxNameLength = 64
+	// MaxValueLength is the longest value that can be stored for a setting
+	MaxValueLength = 128
+
+	schemaExtension = ".json"
+)
+
+// Field describes a single setting and the values it accepts
+type Field struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Min         *int64   `json:"min,omitempty"`
+	Max         *int64   `json:"max,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Default     string   `json:"default,omitempty"`
+}
+
+// Schema is every setting a model supports by name
+type Schema map[string]Field
+
+func bound(value int64) *int64 {
+	return &value
+}
+
+// defaultSchema applies to models without a schema of their own
+var defaultSchema = Schema{
+	"capture_interval": {Type: IntField, Description: "Seconds between captures", Min: bound(1), Max: bound(86400), Default: "60"},
+	"resolution":       {Type: EnumField, Description: "Image resolution", Values: []string{"640x480", "1280x720", "1920x1080"}, Default: "1280x720"},
+	"night_mode":       {Type: BoolField, Description: "Use the infrared illuminator in low light", Default: "false"},
+	"motion_sensitivity": {
+		Type: IntField, Description: "Motion trigger sensitivity, 0 disables motion capture", Min: bound(0), Max: bound(100), Default: "50",
+	},
+}
+
+// validateValue checks a single value against its field
+func (field *Field) validateValue(value string) error {
+	if len(value) > MaxValueLength {
+		return fmt.Errorf("exceeds %d characters", MaxValueLength)
+	}
+
+	switch field.Type {
+	case IntField:
+		number, err := strconv.ParseInt(value, 10, 64)
+		switch {
+		case err != nil:
+			return fmt.Errorf("%q is not a whole number", value)
+		case field.Min != nil && number < *field.Min:
+			return fmt.Errorf("%d is below the minimum of %d", number, *field.Min)
+		case field.Max != nil && number > *field.Max:
+			return fmt.Errorf("%d is above the maximum of %d", number, *field.Max)
+		}
+	case BoolField:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+	case EnumField:
+		for _, allowed := range field.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(field.Values, ", "))
+	case StringField:
+	default:
+		return fmt.Errorf("unknown field type %q", field.Type)
+	}
+	return nil
+}
+
+// Validate checks each value against the schema, rejecting settings the model does not have
+func (schema Schema) Validate(values map[string]string) error {
+	for name, value := range values {
+		field, found := schema[name]
+		if !found {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+
+		if err := field.validateValue(value); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Schemas holds the schema of each model
+type Schemas struct {
+	models map[string]Schema
+}
+
+// LoadSchemas reads <model>.json from the directory, a missing directory leaves every model on the default schema
+func LoadSchemas(directory string) (*Schemas, error) {
+	schemas := &Schemas{models: make(map[string]Schema)}
+
+	files, err := ioutil.ReadDir(directory)
+	if os.IsNotExist(err) {
+		return schemas, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != schemaExtension {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		schema := Schema{}
+		if err = json.Unmarshal(data, &schema); err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %v", file.Name(), err)
+		}
+
+		model := strings.TrimSuffix(file.Name(), schemaExtension)
+		schemas.models[model] = schema
+		logrus.Infof("loaded settings schema for %s", model)
+	}
+
+	return schemas, nil
+}
+
+// For returns the schema of the model
+func (schemas *Schemas) For(model string) Schema {
+	if schemas == nil {
+		return defaultSchema
+	}
+
+	if schema, found := schemas.models[model]; found {
+		return schema
+	}
+	return defaultSchema
+}
@@ -0,0 +1,107 @@
+// Package settings for keeping the settings of a device in line with what its owner wants
+package settings
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	schema := Schema{
+		"interval": {Type: IntField, Min: bound(1), Max: bound(60)},
+		"offset":   {Type: IntField},
+		"enabled":  {Type: BoolField},
+		"mode":     {Type: EnumField, Values: []string{"day", "night"}},
+		"label":    {Type: StringField},
+		"broken":   {Type: "float"},
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{name: "interval", value: "1", valid: true},
+		{name: "interval", value: "60", valid: true},
+		{name: "interval", value: "0", valid: false},
+		{name: "interval", value: "61", valid: false},
+		{name: "interval", value: "1.5", valid: false},
+		{name: "interval", value: "", valid: false},
+		{name: "interval", value: "99999999999999999999", valid: false},
+		{name: "offset", value: "-9223372036854775808", valid: true},
+		{name: "enabled", value: "true", valid: true},
+		{name: "enabled", value: "0", valid: true},
+		{name: "enabled", value: "yes", valid: false},
+		{name: "mode", value: "night", valid: true},
+		{name: "mode", value: "Night", valid: false},
+		{name: "mode", value: "", valid: false},
+		{name: "label", value: "", valid: true},
+		{name: "label", value: "front door", valid: true},
+		{name: "label", value: strings.Repeat("l", MaxValueLength), valid: true},
+		{name: "label", value: strings.Repeat("l", MaxValueLength+1), valid: false},
+		{name: "broken", value: "1", valid: false},
+		{name: "unknown", value: "1", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name+" "+test.value, func(t *testing.T) {
+			err := schema.Validate(map[string]string{test.name: test.value})
+			if test.valid && err != nil {
+				t.Errorf("expected %q to be valid, got %v", test.value, err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected %q to be rejected", test.value)
+			}
+		})
+	}
+
+	if err := schema.Validate(map[string]string{"interval": "5", "mode": "dusk"}); err == nil {
+		t.Error("expected a single invalid value to reject the settings")
+	}
+}
+
+func TestDefaultSchema(t *testing.T) {
+	for name, field := range defaultSchema {
+		if err := field.validateValue(field.Default); err != nil {
+			t.Errorf("default of %s is invalid: %v", name, err)
+		}
+	}
+}
+
+func TestLoadSchemas(t *testing.T) {
+	directory := t.TempDir()
+	files := map[string]string{
+		"cam-x.json": `{"zoom": {"type": "int", "min": 1, "max": 4, "default": "1"}}`,
+		"notes.txt":  "not a schema",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	schemas, err := LoadSchemas(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if field, found := schemas.For("cam-x")["zoom"]; !found || *field.Max != 4 {
+		t.Errorf("expected the schema of cam-x to be loaded, got %+v", schemas.For("cam-x"))
+	}
+	if _, found := schemas.For("other")["resolution"]; !found {
+		t.Error("expected other models to use the default schema")
+	}
+
+	if schemas, err = LoadSchemas(filepath.Join(directory, "missing")); err != nil || len(schemas.models) != 0 {
+		t.Errorf("expected a missing directory to leave the default schema, got %v", err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(directory, "bad.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadSchemas(directory); err == nil {
+		t.Error("expected an invalid schema to be rejected")
+	}
+}
@@ -0,0 +1,182 @@
+// Package settings for keeping the settings of a device in line with what its owner wants
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"site/pkg/database"
+	"site/pkg/topics"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ConfigTopic is the retained topic each device receives its desired settings on
+	ConfigTopic = "config"
+)
+
+// DeviceConfigTopic returns the retained topic the desired settings of the device are published on
+func DeviceConfigTopic(serial string) string {
+	return topics.TopicPrefix + "/" + ConfigTopic + "/" + serial
+}
+
+// ParseReported converts the settings object sent by a device into string values,
+// anything other than a string is kept as its json text, e.g. 60 or true
+func ParseReported(payload []byte) (map[string]string, error) {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		var text string
+		if json.Unmarshal(value, &text) != nil {
+			text = string(value)
+		}
+		values[name] = text
+	}
+	return values, nil
+}
+
+// Desired loads the settings the owner wants the device of the mapping to use
+func Desired(ctx context.Context, db database.Executor, mappingID int) (map[string]string, error) {
+	rows, err := (&database.SettingsObject{}).Query(ctx, db,
+		map[string]string{"user_device_mapping_id": strconv.Itoa(mappingID), "active": "1"})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(rows))
+	for _, row := range rows {
+		values[row.Name] = row.Value
+	}
+	return values, nil
+}
+
+// Reported loads the settings the device last reported
+func Reported(ctx context.Context, db database.Executor, deviceID int) (map[string]string, error) {
+	rows, err := (&database.ReportedSettingObject{}).Query(ctx, db,
+		map[string]string{"device_id": strconv.Itoa(deviceID), "active": "1"})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(rows))
+	for _, row := range rows {
+		values[row.Name] = row.Value
+	}
+	return values, nil
+}
+
+// Diff returns the desired values the device does not report using
+func Diff(desired, reported map[string]string) map[string]string {
+	changes := make(map[string]string)
+	for name, value := range desired {
+		if current, found := reported[name]; !found || current != value {
+			changes[name] = value
+		}
+	}
+	return changes
+}
+
+// within runs the changes in a transaction
+func within(ctx context.Context, db *sqlx.DB, changes func(transaction database.Executor) error) error {
+	transaction, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = changes(transaction); err != nil {
+		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+			logrus.Warnf("failed to roll back settings: %v", rollbackErr)
+		}
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// SetDesired replaces the desired settings of the mapping after validating them against the schema
+func SetDesired(ctx context.Context, db *sqlx.DB, mappingID int, schema Schema, values map[string]string) error {
+	if err := schema.Validate(values); err != nil {
+		return err
+	}
+
+	return within(ctx, db, func(transaction database.Executor) error {
+		rows, err := (&database.SettingsObject{}).Query(ctx, transaction,
+			map[string]string{"user_device_mapping_id": strconv.Itoa(mappingID)})
+		if err != nil {
+			return err
+		}
+
+		for index := range rows {
+			if err = rows[index].Remove(ctx, transaction); err != nil {
+				return err
+			}
+		}
+
+		for name, value := range values {
+			row := database.SettingsObject{UserDeviceMappingID: mappingID, Name: name, Value: value}
+			if err = row.Create(ctx, transaction); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Record replaces the settings the device reported, a setting too long to store is left out
+// rather than losing the rest of the report
+func Record(ctx context.Context, db *sqlx.DB, deviceID int, values map[string]string) error {
+	return within(ctx, db, func(transaction database.Executor) error {
+		rows, err := (&database.ReportedSettingObject{}).Query(ctx, transaction, map[string]string{"device_id": strconv.Itoa(deviceID)})
+		if err != nil {
+			return err
+		}
+
+		for index := range rows {
+			if err = rows[index].Remove(ctx, transaction); err != nil {
+				return err
+			}
+		}
+
+		for name, value := range values {
+			if len(name) > MaxNameLength || len(value) > MaxValueLength {
+				logrus.Warnf("ignoring setting %.64q reported by device %d, names are limited to %d characters and values to %d",
+					name, deviceID, MaxNameLength, MaxValueLength)
+				continue
+			}
+
+			row := database.ReportedSettingObject{DeviceID: deviceID, Name: name, Value: value}
+			if err = row.Create(ctx, transaction); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Payload encodes the desired settings for the device, typed by the schema so numbers
+// and booleans are sent as json numbers and booleans
+func Payload(schema Schema, values map[string]string) ([]byte, error) {
+	typed := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		typed[name] = value
+
+		switch schema[name].Type {
+		case IntField:
+			if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+				typed[name] = number
+			}
+		case BoolField:
+			if flag, err := strconv.ParseBool(value); err == nil {
+				typed[name] = flag
+			}
+		}
+	}
+
+	return json.Marshal(typed)
+}
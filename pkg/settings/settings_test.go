@@ -0,0 +1,190 @@
+// Package settings for keeping the settings of a device in line with what its owner wants
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"site/pkg/database"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func openDatabase(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dialect, err := database.LookupDialect(database.SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := dialect.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  map[string]string
+		reported map[string]string
+		changes  map[string]string
+	}{
+		{name: "in line", desired: map[string]string{"fps": "10"}, reported: map[string]string{"fps": "10"}, changes: map[string]string{}},
+		{name: "changed", desired: map[string]string{"fps": "10"}, reported: map[string]string{"fps": "5"}, changes: map[string]string{"fps": "10"}},
+		{name: "not reported", desired: map[string]string{"fps": "10"}, reported: map[string]string{}, changes: map[string]string{"fps": "10"}},
+		{name: "reported empty", desired: map[string]string{"label": ""}, reported: map[string]string{}, changes: map[string]string{"label": ""}},
+		{name: "only reported", desired: map[string]string{}, reported: map[string]string{"fps": "5"}, changes: map[string]string{}},
+		{name: "nothing stored", desired: nil, reported: nil, changes: map[string]string{}},
+		{
+			name:     "mixed",
+			desired:  map[string]string{"fps": "10", "mode": "night", "label": "door"},
+			reported: map[string]string{"fps": "10", "mode": "day", "extra": "1"},
+			changes:  map[string]string{"mode": "night", "label": "door"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if changes := Diff(test.desired, test.reported); !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("expected %v, got %v", test.changes, changes)
+			}
+		})
+	}
+}
+
+func TestParseReported(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		values  map[string]string
+		invalid bool
+	}{
+		{name: "typed", payload: `{"fps": 10, "night": true, "mode": "day"}`, values: map[string]string{"fps": "10", "night": "true", "mode": "day"}},
+		{name: "nested", payload: `{"zone": {"x": 1}}`, values: map[string]string{"zone": `{"x": 1}`}},
+		{name: "null", payload: `{"label": null}`, values: map[string]string{"label": ""}},
+		{name: "empty", payload: `{}`, values: map[string]string{}},
+		{name: "not an object", payload: `[1, 2]`, invalid: true},
+		{name: "not json", payload: `fps=10`, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := ParseReported([]byte(test.payload))
+			if test.invalid {
+				if err == nil {
+					t.Errorf("expected %s to be rejected", test.payload)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(values, test.values) {
+				t.Errorf("expected %v, got %v: %v", test.values, values, err)
+			}
+		})
+	}
+}
+
+func TestPayload(t *testing.T) {
+	schema := Schema{
+		"fps":   {Type: IntField},
+		"night": {Type: BoolField},
+		"mode":  {Type: EnumField, Values: []string{"day"}},
+	}
+
+	tests := []struct {
+		name    string
+		values  map[string]string
+		payload string
+	}{
+		{name: "typed", values: map[string]string{"fps": "10", "night": "true", "mode": "day"}, payload: `{"fps":10,"mode":"day","night":true}`},
+		{name: "not in the schema", values: map[string]string{"other": "10"}, payload: `{"other":"10"}`},
+		{name: "not a number", values: map[string]string{"fps": "ten"}, payload: `{"fps":"ten"}`},
+		{name: "not a flag", values: map[string]string{"night": "maybe"}, payload: `{"night":"maybe"}`},
+		{name: "empty", values: map[string]string{}, payload: `{}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := Payload(schema, test.values)
+			if err != nil || string(payload) != test.payload {
+				t.Errorf("expected %s, got %s: %v", test.payload, payload, err)
+			}
+
+			// what is sent to the device is read back the same way the device reports it
+			reported, err := ParseReported(payload)
+			if err != nil || !reflect.DeepEqual(reported, test.values) {
+				t.Errorf("expected %v to be reported back, got %v: %v", test.values, reported, err)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db := openDatabase(t)
+	ctx := context.Background()
+
+	reported := map[string]string{
+		"fps":                                "10",
+		"mode":                               "day",
+		"label":                              strings.Repeat("l", MaxValueLength+1),
+		strings.Repeat("n", MaxNameLength+1): "1",
+	}
+	if err := Record(ctx, db, 1, reported); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := Reported(ctx, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"fps": "10", "mode": "day"}; !reflect.DeepEqual(stored, expected) {
+		t.Errorf("expected the settings that fit to be kept, got %v", stored)
+	}
+
+	// a new report replaces the previous one
+	if err = Record(ctx, db, 1, map[string]string{"fps": "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err = Reported(ctx, db, 1); err != nil || !reflect.DeepEqual(stored, map[string]string{"fps": "5"}) {
+		t.Errorf("expected only the latest report, got %v: %v", stored, err)
+	}
+}
+
+func TestSetDesired(t *testing.T) {
+	db := openDatabase(t)
+	ctx := context.Background()
+
+	if err := SetDesired(ctx, db, 1, defaultSchema, map[string]string{"capture_interval": "30", "night_mode": "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDesired(ctx, db, 1, defaultSchema, map[string]string{"capture_interval": "0"}); err == nil {
+		t.Error("expected a value outside the schema to be rejected")
+	}
+
+	desired, err := Desired(ctx, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"capture_interval": "30", "night_mode": "true"}; !reflect.DeepEqual(desired, expected) {
+		t.Errorf("expected the rejected change to leave the settings as they were, got %v", desired)
+	}
+
+	payload, err := Payload(defaultSchema, Diff(desired, map[string]string{"capture_interval": "30"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := map[string]interface{}{}
+	if err = json.Unmarshal(payload, &changes); err != nil || changes["night_mode"] != true || len(changes) != 1 {
+		t.Errorf("expected only night mode to be sent, got %s: %v", payload, err)
+	}
+}
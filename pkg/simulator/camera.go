@@ -0,0 +1,316 @@
+// Package simulator for virtual cameras that behave like devices on the broker
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"site/pkg/commands"
+	"site/pkg/provision"
+	"site/pkg/settings"
+	"site/pkg/topics"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Model is the model the cameras register as
+	Model = "simulated"
+	// Firmware is the firmware the cameras report running
+	Firmware = "0.0.0-sim"
+
+	// publishTimeout is how long a camera waits for the broker to accept a publish
+	publishTimeout = 10 * time.Second
+	// inboxSize is how many received messages a camera holds, more arriving while it is busy are dropped
+	inboxSize = 16
+	// jpegQuality keeps the generated images close to what a camera sends
+	jpegQuality = 80
+)
+
+// Stats counts what every camera has done, safe to read while they run
+type Stats struct {
+	Images     int64
+	Bytes      int64
+	Heartbeats int64
+	Commands   int64
+	Failures   int64
+}
+
+// Snapshot returns a copy of the counters
+func (stats *Stats) Snapshot() Stats {
+	return Stats{
+		Images:     atomic.LoadInt64(&stats.Images),
+		Bytes:      atomic.LoadInt64(&stats.Bytes),
+		Heartbeats: atomic.LoadInt64(&stats.Heartbeats),
+		Commands:   atomic.LoadInt64(&stats.Commands),
+		Failures:   atomic.LoadInt64(&stats.Failures),
+	}
+}
+
+// Camera is a virtual device with its own client id. It registers through the settings topic,
+// sends images and heartbeats at fixed intervals and acknowledges the commands it is sent.
+type Camera struct {
+	Serial    string
+	ClaimCode string
+
+	image      []byte
+	settings   map[string]json.RawMessage
+	registered bool
+	inbox      chan topics.Message
+	client     MQTT.Client
+	stats      *Stats
+}
+
+// NewImage generates a jpeg of the given size for the cameras to send, tinted by seed so
+// images from different runs are told apart
+func NewImage(width, height int, seed byte) ([]byte, error) {
+	picture := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			picture.Set(x, y, color.RGBA{R: byte(x * 255 / width), G: byte(y * 255 / height), B: seed, A: 255})
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, picture, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// LabelCode is the claim code printed on the label of the camera with the serial, it is
+// derived from the serial so a camera registered by an earlier simulation can still be claimed
+func LabelCode(serial string) string {
+	sum := sha256.Sum256([]byte(serial))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))[:provision.ClaimCodeLength]
+}
+
+// NewCamera creates a camera that connects with the options, which it takes over to set its
+// last will and handlers
+func NewCamera(serial string, opts *MQTT.ClientOptions, picture []byte, stats *Stats) *Camera {
+	camera := &Camera{Serial: serial, ClaimCode: LabelCode(serial), image: picture, stats: stats,
+		settings: map[string]json.RawMessage{}, inbox: make(chan topics.Message, inboxSize)}
+
+	// the broker announces the camera going away when it does not disconnect cleanly
+	opts.SetWill(camera.topic(topics.StatusTopic), `{"state":"offline"}`, 1, false)
+	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(camera.onConnect)
+	opts.SetDefaultPublishHandler(func(client MQTT.Client, msg MQTT.Message) {
+		// the handler must not wait on the camera, which may itself be waiting on the client
+		select {
+		case camera.inbox <- topics.Message{Topic: msg.Topic(), Payload: msg.Payload(), QoS: msg.Qos(),
+			Retained: msg.Retained(), Received: time.Now(), MessageID: msg.MessageID()}:
+		default:
+			logrus.Warnf("camera %s is busy, dropped message on %s", camera.Serial, msg.Topic())
+			atomic.AddInt64(&camera.stats.Failures, 1)
+		}
+	})
+
+	camera.client = MQTT.NewClient(opts)
+	return camera
+}
+
+// topic returns the topic of the action for this camera
+func (camera *Camera) topic(action string) string {
+	return topics.TopicPrefix + "/" + action + "/" + camera.Serial
+}
+
+// onConnect subscribes to what the site sends the camera, on every connect as sessions are clean
+func (camera *Camera) onConnect(client MQTT.Client) {
+	filters := map[string]byte{
+		camera.topic(topics.CommandTopic):         1,
+		camera.topic(topics.RegisteredTopic):      1,
+		settings.DeviceConfigTopic(camera.Serial): 1,
+	}
+
+	if token := client.SubscribeMultiple(filters, nil); token.Wait() && token.Error() != nil {
+		logrus.Errorf("camera %s failed to subscribe: %v", camera.Serial, token.Error())
+		atomic.AddInt64(&camera.stats.Failures, 1)
+	}
+}
+
+// Run connects the camera, announces it and sends images and heartbeats until ctx is done,
+// when it goes offline and disconnects. An interval of 0 turns that kind of message off.
+func (camera *Camera) Run(ctx context.Context, imageInterval, heartbeatInterval time.Duration) error {
+	if token := camera.client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("camera %s unable to connect: %v", camera.Serial, token.Error())
+	}
+
+	camera.publish(camera.topic(topics.StatusTopic), camera.status("online"))
+	camera.reportSettings()
+
+	images := newTicker(imageInterval)
+	defer images.Stop()
+	heartbeats := newTicker(heartbeatInterval)
+	defer heartbeats.Stop()
+
+	for {
+		select {
+		case <-images.C:
+			camera.sendImage()
+		case <-heartbeats.C:
+			if camera.publish(camera.topic(topics.HeartbeatTopic), camera.status("")) {
+				atomic.AddInt64(&camera.stats.Heartbeats, 1)
+			}
+
+			// the site may not have been listening yet, so keep registering until it answers
+			if !camera.registered {
+				camera.reportSettings()
+			}
+		case message := <-camera.inbox:
+			camera.handle(&message)
+		case <-ctx.Done():
+			camera.publish(camera.topic(topics.StatusTopic), camera.status("offline"))
+			camera.client.Disconnect(uint(time.Second.Milliseconds()))
+			return nil
+		}
+	}
+}
+
+// newTicker returns a ticker for the interval, or one that never fires for 0
+func newTicker(interval time.Duration) *time.Ticker {
+	if interval <= 0 {
+		ticker := time.NewTicker(time.Hour)
+		ticker.Stop()
+		return ticker
+	}
+	return time.NewTicker(interval)
+}
+
+// status is the payload of a heartbeat, or of a status report with the state
+func (camera *Camera) status(state string) []byte {
+	report := map[string]string{"ip": "127.0.0.1", "firmware": Firmware}
+	if len(state) > 0 {
+		report["state"] = state
+	}
+
+	payload, _ := json.Marshal(report)
+	return payload
+}
+
+// publish sends the payload with qos 1, reporting whether the broker accepted it
+func (camera *Camera) publish(topic string, payload []byte) bool {
+	token := camera.client.Publish(topic, 1, false, payload)
+	if !token.WaitTimeout(publishTimeout) || token.Error() != nil {
+		logrus.Warnf("camera %s failed to publish on %s: %v", camera.Serial, topic, token.Error())
+		atomic.AddInt64(&camera.stats.Failures, 1)
+		return false
+	}
+	return true
+}
+
+// reportSettings publishes the settings in use, until the site has answered it also carries
+// the registration so an unknown camera is added as pending with its claim code
+func (camera *Camera) reportSettings() {
+	report := make(map[string]interface{}, len(camera.settings))
+	for name, value := range camera.settings {
+		report[name] = value
+	}
+
+	if !camera.registered {
+		report["model"] = Model
+		report["firmware"] = Firmware
+		report["claim_code"] = camera.ClaimCode
+	}
+
+	payload, err := json.Marshal(report)
+	if err != nil {
+		logrus.Errorf("camera %s failed to encode its settings: %v", camera.Serial, err)
+		return
+	}
+	camera.publish(camera.topic(topics.SettingsTopic), payload)
+}
+
+// sendImage publishes the image in the frame format with a new file name
+func (camera *Camera) sendImage() {
+	frame := topics.ImageFrame{FileName: fmt.Sprintf("%s-%d.jpg", camera.Serial, time.Now().UnixNano()), Data: camera.image}
+	payload, err := frame.Encode()
+	if err != nil {
+		logrus.Errorf("camera %s failed to encode an image: %v", camera.Serial, err)
+		return
+	}
+
+	if camera.publish(camera.topic(topics.ImageTopic), payload) {
+		atomic.AddInt64(&camera.stats.Images, 1)
+		atomic.AddInt64(&camera.stats.Bytes, int64(len(payload)))
+	}
+}
+
+// handle acts on a message from the site
+func (camera *Camera) handle(message *topics.Message) {
+	switch message.Topic {
+	case camera.topic(topics.RegisteredTopic):
+		registered := provision.Registered{}
+		if err := json.Unmarshal(message.Payload, &registered); err != nil {
+			logrus.Warnf("camera %s received an invalid registration reply: %v", camera.Serial, err)
+			return
+		}
+		camera.registered = true
+		logrus.Debugf("camera %s is %s", camera.Serial, registered.State)
+	case settings.DeviceConfigTopic(camera.Serial):
+		if len(message.Payload) > 0 {
+			camera.registered = true
+			camera.apply(message.Payload)
+		}
+	case camera.topic(topics.CommandTopic):
+		atomic.AddInt64(&camera.stats.Commands, 1)
+		camera.command(message.Payload)
+	}
+}
+
+// apply takes on the settings object and reports what is now in use
+func (camera *Camera) apply(payload []byte) bool {
+	changes := map[string]json.RawMessage{}
+	if err := json.Unmarshal(payload, &changes); err != nil {
+		logrus.Warnf("camera %s received invalid settings: %v", camera.Serial, err)
+		return false
+	}
+
+	for name, value := range changes {
+		camera.settings[name] = value
+	}
+	camera.reportSettings()
+	return true
+}
+
+// command carries out the command and acknowledges it
+func (camera *Camera) command(payload []byte) {
+	command := commands.Command{}
+	if err := json.Unmarshal(payload, &command); err != nil {
+		logrus.Warnf("camera %s received an invalid command: %v", camera.Serial, err)
+		return
+	}
+
+	ack := commands.Ack{ID: command.ID, Status: commands.StatusOK}
+	switch command.Type {
+	case commands.Capture:
+		camera.sendImage()
+	case commands.ApplySettings:
+		if !camera.apply(command.Args) {
+			ack.Status, ack.Message = commands.StatusError, "invalid settings"
+		}
+	case commands.Reboot:
+		// nothing to restart, the camera carries on as if it came back straight away
+	default:
+		ack.Status, ack.Message = commands.StatusError, command.Type+" is not simulated"
+	}
+
+	logrus.Debugf("camera %s handled %s %s: %s", camera.Serial, command.Type, command.ID, ack.Status)
+
+	reply, err := json.Marshal(&ack)
+	if err != nil {
+		logrus.Errorf("camera %s failed to encode an acknowledgement: %v", camera.Serial, err)
+		return
+	}
+	camera.publish(camera.topic(topics.AckTopic), reply)
+}
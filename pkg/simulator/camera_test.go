@@ -0,0 +1,154 @@
+// Package simulator for virtual cameras that behave like devices on the broker
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"site/pkg/broker"
+	"site/pkg/commands"
+	"site/pkg/topics"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// startBroker runs the embedded broker on a loopback port until the test ends
+func startBroker(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	embedded := broker.New()
+	go func() {
+		if err := embedded.Serve(listener); err != nil {
+			t.Errorf("broker stopped: %v", err)
+		}
+	}()
+	t.Cleanup(embedded.Close)
+
+	return "tcp://" + listener.Addr().String()
+}
+
+// connect creates a client for the test, standing in for the site
+func connect(t *testing.T, address, clientID string, handler MQTT.MessageHandler) MQTT.Client {
+	t.Helper()
+
+	opts := MQTT.NewClientOptions().AddBroker(address).SetClientID(clientID).SetDefaultPublishHandler(handler)
+	client := MQTT.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(100) })
+	return client
+}
+
+// waitFor returns the next message on the topic, skipping any others
+func waitFor(t *testing.T, messages chan topics.Message, topic string) topics.Message {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message := <-messages:
+			if message.Topic == topic {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("nothing published on %s", topic)
+		}
+	}
+}
+
+func TestCameraOnBroker(t *testing.T) {
+	address := startBroker(t)
+
+	messages := make(chan topics.Message, 1000)
+	var dispatcher *commands.Dispatcher
+	site := connect(t, address, "site", func(client MQTT.Client, msg MQTT.Message) {
+		if msg.Topic() == topics.TopicPrefix+"/"+topics.AckTopic+"/cam1" {
+			if err := dispatcher.Acknowledge("cam1", msg.Payload()); err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		select {
+		case messages <- topics.Message{Topic: msg.Topic(), Payload: msg.Payload()}:
+		default:
+		}
+	})
+	if token := site.Subscribe(topics.TopicPrefix+"/#", 1, nil); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	dispatcher = commands.NewDispatcher(func(topic string, payload []byte) {
+		site.Publish(topic, 1, false, payload).Wait()
+	}, 500*time.Millisecond)
+
+	picture, err := NewImage(32, 24, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := &Stats{}
+	opts := MQTT.NewClientOptions().AddBroker(address).SetClientID("cam1")
+	camera := NewCamera("cam1", opts, picture, stats)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- camera.Run(ctx, 20*time.Millisecond, 20*time.Millisecond)
+	}()
+
+	prefix := topics.TopicPrefix + "/"
+	if status := waitFor(t, messages, prefix+topics.StatusTopic+"/cam1"); !bytes.Contains(status.Payload, []byte("online")) {
+		t.Errorf("expected the camera to announce itself online, got %s", status.Payload)
+	}
+
+	registration := map[string]interface{}{}
+	if err = json.Unmarshal(waitFor(t, messages, prefix+topics.SettingsTopic+"/cam1").Payload, &registration); err != nil {
+		t.Fatal(err)
+	}
+	if registration["claim_code"] != LabelCode("cam1") || registration["model"] != Model {
+		t.Errorf("expected the settings to carry the registration, got %v", registration)
+	}
+
+	frame := topics.ImageFrame{}
+	if err = frame.Decode(waitFor(t, messages, prefix+topics.ImageTopic+"/cam1").Payload); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Data, picture) {
+		t.Error("the image sent is not the picture the camera was given")
+	}
+
+	waitFor(t, messages, prefix+topics.HeartbeatTopic+"/cam1")
+
+	// the camera subscribes once connected, so a command sent before then goes unanswered
+	var ack *commands.Ack
+	for attempt := 0; attempt < 10 && ack == nil; attempt++ {
+		_, ack, err = dispatcher.Send(context.Background(), "cam1", commands.Reboot, nil)
+		if err != nil && !errors.Is(err, commands.ErrCommandTimeout) {
+			t.Fatal(err)
+		}
+	}
+	if ack == nil || ack.Status != commands.StatusOK {
+		t.Fatalf("expected the camera to acknowledge the command, got %+v: %v", ack, err)
+	}
+
+	cancel()
+	if err = <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if status := waitFor(t, messages, prefix+topics.StatusTopic+"/cam1"); !bytes.Contains(status.Payload, []byte("offline")) {
+		t.Errorf("expected the camera to go offline, got %s", status.Payload)
+	}
+
+	if snapshot := stats.Snapshot(); snapshot.Images == 0 || snapshot.Heartbeats == 0 || snapshot.Commands == 0 {
+		t.Errorf("expected images, heartbeats and commands to be counted, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,143 @@
+// Package storage for writing captured media to disk alongside its database record
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"site/pkg/database"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TemporaryPrefix marks files that are still being ingested and are not yet in place
+	TemporaryPrefix   = ".ingest-"
+	directoryOptions  = 0750
+	temporaryTemplate = TemporaryPrefix + "*"
+	maxNameAttempts   = 1000
+)
+
+// Record adds the database entry describing the file stored at path using the given transaction
+type Record func(ctx context.Context, transaction database.Executor, path string) error
+
+// StoreFile atomically writes data to path and records it in the database, returning
+// the path the file was stored at. The data is written and synced to a temporary file,
+// linked into place and recorded in a transaction. Devices reuse their file names, so
+// a file that already exists is never replaced, even one created a moment before by
+// another ingestion, and the data is stored under the next free name instead, e.g.
+// capture-1.jpg. Any failure rolls back the transaction and removes the file so
+// neither an orphan row nor a partial file is left.
+func StoreFile(ctx context.Context, db *sqlx.DB, path string, data []byte, record Record) (string, error) {
+	path = filepath.Clean(path)
+	directory := filepath.Dir(path)
+
+	err := os.MkdirAll(directory, directoryOptions)
+	if err != nil {
+		return "", err
+	}
+
+	temporaryPath, err := writeTemporary(directory, data)
+	if err != nil {
+		return "", err
+	}
+
+	path, err = linkUnique(temporaryPath, path)
+	removeFile(temporaryPath)
+	if err != nil {
+		return "", err
+	}
+
+	transaction, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		removeFile(path)
+		return "", err
+	}
+
+	err = record(ctx, transaction, path)
+	if err != nil {
+		if rollbackErr := transaction.Rollback(); rollbackErr != nil {
+			logrus.Warnf("failed to roll back ingestion of %s: %v", path, rollbackErr)
+		}
+		removeFile(path)
+		return "", err
+	}
+
+	syncDirectory(directory)
+
+	err = transaction.Commit()
+	if err != nil {
+		removeFile(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// linkUnique links the temporary file at path, or at the first free numbered name beside it
+func linkUnique(temporaryPath, path string) (string, error) {
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+
+	candidate := path
+	for attempt := 1; attempt <= maxNameAttempts; attempt++ {
+		err := os.Link(temporaryPath, candidate)
+		if !os.IsExist(err) {
+			return candidate, err
+		}
+		candidate = base + "-" + strconv.Itoa(attempt) + extension
+	}
+
+	return "", fmt.Errorf("no free name for %s after %d attempts", path, maxNameAttempts)
+}
+
+// writeTemporary writes and syncs the data to a new temporary file within the directory
+func writeTemporary(directory string, data []byte) (string, error) {
+	file, err := ioutil.TempFile(directory, temporaryTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		removeFile(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// syncDirectory persists the link within the directory, failure only risks the link on a crash
+func syncDirectory(directory string) {
+	handle, err := os.Open(filepath.Clean(directory))
+	if err != nil {
+		logrus.Warnf("unable to open directory for sync: %v", err)
+		return
+	}
+
+	if err = handle.Sync(); err != nil {
+		logrus.Warnf("unable to sync directory: %v", err)
+	}
+
+	_ = handle.Close()
+}
+
+func removeFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("failed to remove %s: %v", path, err)
+	}
+}
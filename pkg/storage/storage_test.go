@@ -0,0 +1,159 @@
+// Package storage for writing captured media to disk alongside its database record
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"site/pkg/database"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func openDatabase(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dialect, err := database.LookupDialect(database.SQLiteDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := dialect.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// store keeps the data at path, or the next free name, with an image row for it
+func store(db *sqlx.DB, path string, data []byte) (string, error) {
+	image := database.ImageObject{UserID: 1, DeviceID: 1, Name: filepath.Base(path)}
+	return StoreFile(context.Background(), db, path, data, func(ctx context.Context, transaction database.Executor, path string) error {
+		image.Path = path
+		return image.Create(ctx, transaction)
+	})
+}
+
+// contents returns the names of the files in the directory
+func contents(t *testing.T, directory string) []string {
+	t.Helper()
+
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
+
+func TestStoreFile(t *testing.T) {
+	db := openDatabase(t)
+	directory := filepath.Join(t.TempDir(), "user", "cam1")
+	path := filepath.Join(directory, "capture.jpg")
+
+	// devices reuse their file names, every capture is kept under a name of its own
+	for index, expected := range []string{"capture.jpg", "capture-1.jpg", "capture-2.jpg"} {
+		stored, err := store(db, path, []byte(strconv.Itoa(index)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != filepath.Join(directory, expected) {
+			t.Errorf("expected capture %d to be stored as %s, got %s", index, expected, stored)
+		}
+
+		data, err := ioutil.ReadFile(stored)
+		if err != nil || string(data) != strconv.Itoa(index) {
+			t.Errorf("expected capture %d to be left in place, got %q: %v", index, data, err)
+		}
+	}
+
+	images, err := (&database.ImageObject{}).Query(context.Background(), db, nil)
+	if err != nil || len(images) != 3 {
+		t.Fatalf("expected a row for every capture, have %d rows: %v", len(images), err)
+	}
+	for _, image := range images {
+		if image.Name != "capture.jpg" {
+			t.Errorf("expected the name from the device to be kept, got %s", image.Name)
+		}
+	}
+
+	if names := contents(t, directory); len(names) != 3 {
+		t.Errorf("expected only the stored files to be left, have %v", names)
+	}
+}
+
+func TestStoreFileRollsBack(t *testing.T) {
+	db := openDatabase(t)
+	directory := t.TempDir()
+
+	failed := errors.New("record refused")
+	_, err := StoreFile(context.Background(), db, filepath.Join(directory, "capture.jpg"), []byte("data"),
+		func(ctx context.Context, transaction database.Executor, path string) error {
+			return failed
+		})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the record error, got %v", err)
+	}
+
+	if names := contents(t, directory); len(names) != 0 {
+		t.Errorf("expected the file of the refused record to be removed, have %v", names)
+	}
+}
+
+func TestStoreFileConcurrently(t *testing.T) {
+	db := openDatabase(t)
+	directory := t.TempDir()
+	path := filepath.Join(directory, "capture.jpg")
+
+	// every ingestion links a name of its own rather than replacing another
+	const ingestions = 8
+	results := make(chan error, ingestions)
+	started := &sync.WaitGroup{}
+	started.Add(ingestions)
+	for index := 0; index < ingestions; index++ {
+		go func() {
+			started.Done()
+			started.Wait()
+			_, err := store(db, path, []byte("data"))
+			results <- err
+		}()
+	}
+
+	stored := 0
+	for index := 0; index < ingestions; index++ {
+		if err := <-results; err == nil {
+			stored++
+		} else {
+			t.Logf("ingestion failed: %v", err)
+		}
+	}
+
+	images, err := (&database.ImageObject{}).Query(context.Background(), db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != len(images) || stored == 0 {
+		t.Errorf("expected a row for every stored file, %d stored with %d rows", stored, len(images))
+	}
+
+	paths := map[string]bool{}
+	for _, image := range images {
+		paths[image.Path] = true
+	}
+	if names := contents(t, directory); len(names) != stored || len(paths) != stored {
+		t.Errorf("expected a distinct file for every stored capture and no temporary files, have %v", names)
+	}
+}
@@ -0,0 +1,207 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxAudioSize is the largest audio clip accepted in a single frame
+	MaxAudioSize = 64 * 1024 * 1024
+
+	// WAVFormat is a RIFF wave clip
+	WAVFormat = "wav"
+	// OpusFormat is an opus stream in an ogg container
+	OpusFormat = "opus"
+
+	audioSizeLength    = 4
+	millisecondsPerSec = 1000
+
+	riffHeaderSize   = 12
+	riffChunkHeader  = 8
+	wavByteRateStart = 8
+	wavFormatSize    = 16
+
+	oggPageHeaderSize = 27
+	oggSegmentCount   = 26
+)
+
+// ErrInvalidAudio is returned when a clip is not a well formed wav or opus stream
+var ErrInvalidAudio = errors.New("audio is not valid wav or opus")
+
+// AudioFrame is an audio clip as sent by a device:
+//
+//	[file name length - 1 byte][file name]
+//	[capture name length - 1 byte][capture name, empty if not linked]
+//	[audio size - 32 bit little endian][audio data]
+//
+// the capture name is the file name of the image or video the clip was recorded with
+type AudioFrame struct {
+	FileName    string
+	CaptureName string
+	Data        []byte
+
+	// Format and DurationMS are derived from the data when decoding
+	Format     string
+	DurationMS int64
+}
+
+// Encode writes the frame in its wire format
+func (frame *AudioFrame) Encode() ([]byte, error) {
+	if err := validateFileName(frame.FileName); err != nil {
+		return nil, err
+	}
+
+	if len(frame.CaptureName) > 0 {
+		if err := validateFileName(frame.CaptureName); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(frame.Data) > MaxAudioSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameOversized, len(frame.Data))
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(2*fileNameLengthSize + len(frame.FileName) + len(frame.CaptureName) + audioSizeLength + len(frame.Data))
+
+	buffer.WriteByte(byte(len(frame.FileName)))
+	buffer.WriteString(frame.FileName)
+	buffer.WriteByte(byte(len(frame.CaptureName)))
+	buffer.WriteString(frame.CaptureName)
+
+	size := make([]byte, audioSizeLength)
+	binary.LittleEndian.PutUint32(size, uint32(len(frame.Data)))
+	buffer.Write(size)
+	buffer.Write(frame.Data)
+
+	return buffer.Bytes(), nil
+}
+
+// readName reads a length prefixed name at offset, returning it and the offset following it
+func readName(rawData []byte, offset int) (string, int, error) {
+	if len(rawData) < offset+fileNameLengthSize {
+		return "", 0, fmt.Errorf("%w: no name length", ErrFrameTruncated)
+	}
+
+	end := offset + fileNameLengthSize + int(rawData[offset])
+	if len(rawData) < end {
+		return "", 0, fmt.Errorf("%w: header needs %d bytes, have %d", ErrFrameTruncated, end, len(rawData))
+	}
+
+	return string(rawData[offset+fileNameLengthSize : end]), end, nil
+}
+
+// Decode parses and validates the wire format into the frame
+func (frame *AudioFrame) Decode(rawData []byte) error {
+	fileName, offset, err := readName(rawData, 0)
+	if err != nil {
+		return err
+	}
+
+	if err = validateFileName(fileName); err != nil {
+		return err
+	}
+
+	captureName, offset, err := readName(rawData, offset)
+	if err != nil {
+		return err
+	}
+
+	if len(captureName) > 0 {
+		if err = validateFileName(captureName); err != nil {
+			return err
+		}
+	}
+
+	if len(rawData) < offset+audioSizeLength {
+		return fmt.Errorf("%w: no audio size", ErrFrameTruncated)
+	}
+
+	audioSize := binary.LittleEndian.Uint32(rawData[offset:])
+	if audioSize > MaxAudioSize {
+		return fmt.Errorf("%w: %d bytes", ErrFrameOversized, audioSize)
+	}
+
+	audioData := rawData[offset+audioSizeLength:]
+	switch {
+	case uint32(len(audioData)) < audioSize:
+		return fmt.Errorf("%w: %d of %d audio bytes", ErrFrameTruncated, len(audioData), audioSize)
+	case uint32(len(audioData)) > audioSize:
+		return fmt.Errorf("%w: %d bytes declared, %d received", ErrFrameLengthMismatch, audioSize, len(audioData))
+	}
+
+	format, duration, err := inspectAudio(audioData)
+	if err != nil {
+		return err
+	}
+
+	frame.FileName = fileName
+	frame.CaptureName = captureName
+	frame.Data = audioData
+	frame.Format = format
+	frame.DurationMS = duration
+
+	return nil
+}
+
+// inspectAudio validates the framing of the clip, returning its format and duration if known
+func inspectAudio(data []byte) (string, int64, error) {
+	switch {
+	case len(data) >= riffHeaderSize && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		duration, err := wavDuration(data)
+		return WAVFormat, duration, err
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return OpusFormat, 0, validateOpus(data)
+	}
+	return "", 0, fmt.Errorf("%w: unrecognized header", ErrInvalidAudio)
+}
+
+// wavDuration walks the riff chunks for the byte rate and size of the samples
+func wavDuration(data []byte) (int64, error) {
+	var byteRate uint32
+
+	for offset := riffHeaderSize; offset+riffChunkHeader <= len(data); {
+		chunkID := string(data[offset : offset+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		body := offset + riffChunkHeader
+
+		switch chunkID {
+		case "fmt ":
+			if chunkSize < wavFormatSize || body+chunkSize > len(data) {
+				return 0, fmt.Errorf("%w: short fmt chunk", ErrInvalidAudio)
+			}
+			byteRate = binary.LittleEndian.Uint32(data[body+wavByteRateStart:])
+		case "data":
+			if byteRate == 0 {
+				return 0, fmt.Errorf("%w: data chunk before a valid fmt chunk", ErrInvalidAudio)
+			}
+			if body+chunkSize > len(data) {
+				return 0, fmt.Errorf("%w: data chunk exceeds the clip", ErrInvalidAudio)
+			}
+			return int64(chunkSize) * millisecondsPerSec / int64(byteRate), nil
+		}
+
+		// chunks are padded to an even size
+		offset = body + chunkSize + chunkSize%2
+	}
+
+	return 0, fmt.Errorf("%w: no data chunk", ErrInvalidAudio)
+}
+
+// validateOpus ensures the first ogg page carries the opus identification header
+func validateOpus(data []byte) error {
+	if len(data) < oggPageHeaderSize {
+		return fmt.Errorf("%w: truncated ogg page", ErrInvalidAudio)
+	}
+
+	packet := oggPageHeaderSize + int(data[oggSegmentCount])
+	if packet > len(data) || !bytes.HasPrefix(data[packet:], []byte("OpusHead")) {
+		return fmt.Errorf("%w: ogg stream is not opus", ErrInvalidAudio)
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// riffChunk builds a chunk declaring size, which the body may disagree with
+func riffChunk(id string, size uint32, body []byte) []byte {
+	chunk := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], size)
+	return append(chunk, body...)
+}
+
+// wavFormat is the body of a fmt chunk with the byte rate
+func wavFormat(byteRate uint32) []byte {
+	format := make([]byte, wavFormatSize)
+	binary.LittleEndian.PutUint32(format[wavByteRateStart:], byteRate)
+	return format
+}
+
+// wav builds a clip from the chunks following the riff header
+func wav(chunks ...[]byte) []byte {
+	clip := []byte("RIFF\x00\x00\x00\x00WAVE")
+	for _, chunk := range chunks {
+		clip = append(clip, chunk...)
+	}
+	return clip
+}
+
+// opus builds the first ogg page of a stream with the packet following its segment table
+func opus(packet string) []byte {
+	page := append([]byte("OggS"), make([]byte, oggPageHeaderSize-4)...)
+	page[oggSegmentCount] = 1
+	page = append(page, byte(len(packet)))
+	return append(page, packet...)
+}
+
+// rawAudio builds an audio frame by hand so the declared size can disagree with what follows it
+func rawAudio(fileName, captureName string, declared uint32, data []byte) []byte {
+	rawData := append([]byte{byte(len(fileName))}, fileName...)
+	rawData = append(append(rawData, byte(len(captureName))), captureName...)
+	size := make([]byte, audioSizeLength)
+	binary.LittleEndian.PutUint32(size, declared)
+	rawData = append(rawData, size...)
+	return append(rawData, data...)
+}
+
+func TestInspectAudio(t *testing.T) {
+	samples := make([]byte, 8000)
+	clip := wav(riffChunk("fmt ", wavFormatSize, wavFormat(16000)), riffChunk("data", 8000, samples))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		duration int64
+		invalid  bool
+	}{
+		{name: "wav", data: clip, format: WAVFormat, duration: 500},
+		{name: "wav with a padded chunk", format: WAVFormat, duration: 500, data: wav(riffChunk("fmt ", wavFormatSize, wavFormat(16000)),
+			riffChunk("LIST", 3, []byte("abc\x00")), riffChunk("data", 8000, samples))},
+		{name: "empty samples", data: wav(riffChunk("fmt ", wavFormatSize, wavFormat(16000)), riffChunk("data", 0, nil)), format: WAVFormat},
+		{name: "opus", data: opus("OpusHead\x01\x02"), format: OpusFormat},
+		{name: "riff header only", data: wav(), invalid: true},
+		{name: "truncated riff", data: clip[:riffHeaderSize-1], invalid: true},
+		{name: "truncated chunk header", data: clip[:riffHeaderSize+riffChunkHeader-1], invalid: true},
+		{name: "truncated fmt chunk", data: clip[:riffHeaderSize+riffChunkHeader+4], invalid: true},
+		{name: "short fmt chunk", data: wav(riffChunk("fmt ", 8, make([]byte, 8)), riffChunk("data", 0, nil)), invalid: true},
+		{name: "zero byte rate", data: wav(riffChunk("fmt ", wavFormatSize, wavFormat(0)), riffChunk("data", 4, []byte("pcm!"))), invalid: true},
+		{name: "data before fmt", data: wav(riffChunk("data", 4, []byte("pcm!")), riffChunk("fmt ", wavFormatSize, wavFormat(16000))), invalid: true},
+		{name: "truncated samples", data: clip[:len(clip)-1], invalid: true},
+		{name: "largest chunk size", data: wav(riffChunk("LIST", 0xffffffff, nil)), invalid: true},
+		{name: "no data chunk", data: wav(riffChunk("fmt ", wavFormatSize, wavFormat(16000))), invalid: true},
+		{name: "bad opus magic", data: opus("OpusHeap"), invalid: true},
+		{name: "vorbis", data: opus("\x01vorbis"), invalid: true},
+		{name: "truncated ogg page", data: opus("OpusHead")[:oggPageHeaderSize-1], invalid: true},
+		{name: "segment table past the end", data: opus("")[:oggPageHeaderSize], invalid: true},
+		{name: "unknown", data: []byte("ID3\x03"), invalid: true},
+		{name: "empty", data: nil, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, duration, err := inspectAudio(test.data)
+			if test.invalid {
+				if !errors.Is(err, ErrInvalidAudio) {
+					t.Fatalf("expected %v, got %v", ErrInvalidAudio, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != test.format || duration != test.duration {
+				t.Errorf("expected %s of %dms, got %s of %dms", test.format, test.duration, format, duration)
+			}
+		})
+	}
+}
+
+func TestAudioFrameDecode(t *testing.T) {
+	clip := wav(riffChunk("fmt ", wavFormatSize, wavFormat(16000)), riffChunk("data", 1600, make([]byte, 1600)))
+	valid := rawAudio("clip.wav", "capture.jpg", uint32(len(clip)), clip)
+
+	tests := []struct {
+		name    string
+		rawData []byte
+		err     error
+	}{
+		{name: "valid", rawData: valid},
+		{name: "not linked", rawData: rawAudio("clip.wav", "", uint32(len(clip)), clip)},
+		{name: "empty", rawData: nil, err: ErrFrameTruncated},
+		{name: "capture name missing", rawData: valid[:1+len("clip.wav")], err: ErrFrameTruncated},
+		{name: "capture name cut short", rawData: valid[:1+len("clip.wav")+3], err: ErrFrameTruncated},
+		{name: "size cut short", rawData: valid[:2+len("clip.wav")+len("capture.jpg")+2], err: ErrFrameTruncated},
+		{name: "clip cut short", rawData: valid[:len(valid)-1], err: ErrFrameTruncated},
+		{name: "oversized", rawData: rawAudio("clip.wav", "", MaxAudioSize+1, clip), err: ErrFrameOversized},
+		{name: "largest size", rawData: rawAudio("clip.wav", "", 0xffffffff, nil), err: ErrFrameOversized},
+		{name: "trailing bytes", rawData: append(append([]byte{}, valid...), "extra"...), err: ErrFrameLengthMismatch},
+		{name: "path", rawData: rawAudio("../clip.wav", "", uint32(len(clip)), clip), err: ErrInvalidFileName},
+		{name: "capture path", rawData: rawAudio("clip.wav", "../capture.jpg", uint32(len(clip)), clip), err: ErrInvalidFileName},
+		{name: "not audio", rawData: rawAudio("clip.wav", "", 4, []byte("jpeg")), err: ErrInvalidAudio},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := AudioFrame{}
+			err := frame.Decode(test.rawData)
+			if test.err == nil && err != nil {
+				t.Fatalf("expected the frame to decode, got %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err != nil && (len(frame.FileName) > 0 || frame.Data != nil || len(frame.Format) > 0) {
+				t.Errorf("a rejected frame was partly decoded into %+v", frame)
+			}
+		})
+	}
+}
+
+func TestAudioFrameEncode(t *testing.T) {
+	clip := wav(riffChunk("fmt ", wavFormatSize, wavFormat(8000)), riffChunk("data", 4000, make([]byte, 4000)))
+
+	tests := []struct {
+		name  string
+		frame AudioFrame
+		err   error
+	}{
+		{name: "wav", frame: AudioFrame{FileName: "clip.wav", CaptureName: "capture.jpg", Data: clip}},
+		{name: "opus", frame: AudioFrame{FileName: "clip.opus", Data: opus("OpusHead")}},
+		{name: "longest names", frame: AudioFrame{FileName: strings.Repeat("n", MaxFileNameLength),
+			CaptureName: strings.Repeat("c", MaxFileNameLength), Data: clip}},
+		{name: "capture name too long", frame: AudioFrame{FileName: "clip.wav",
+			CaptureName: strings.Repeat("c", MaxFileNameLength+1)}, err: ErrInvalidFileName},
+		{name: "oversized", frame: AudioFrame{FileName: "clip.wav", Data: make([]byte, MaxAudioSize+1)}, err: ErrFrameOversized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rawData, err := test.frame.Encode()
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded := AudioFrame{}
+			if err = decoded.Decode(rawData); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.FileName != test.frame.FileName || decoded.CaptureName != test.frame.CaptureName ||
+				!bytes.Equal(decoded.Data, test.frame.Data) {
+				t.Errorf("expected %q to round trip, got %q", test.frame.FileName, decoded.FileName)
+			}
+		})
+	}
+
+	frame := AudioFrame{}
+	if err := frame.Decode(rawAudio("clip.wav", "", uint32(len(clip)), clip)); err != nil || frame.Format != WAVFormat || frame.DurationMS != 500 {
+		t.Errorf("expected a wav clip of 500ms, got %s of %dms: %v", frame.Format, frame.DurationMS, err)
+	}
+}
+
+// FuzzAudioFrameDecode checks that no input panics and that whatever decodes encodes back to the same bytes
+func FuzzAudioFrameDecode(f *testing.F) {
+	clip := wav(riffChunk("fmt ", wavFormatSize, wavFormat(16000)), riffChunk("data", 4, []byte("pcm!")))
+	f.Add(rawAudio("clip.wav", "capture.jpg", uint32(len(clip)), clip))
+	f.Add(rawAudio("clip.opus", "", 36, opus("OpusHead")))
+	f.Add(rawAudio("clip.wav", "", 12, wav()))
+	f.Add(rawAudio("clip.wav", "", MaxAudioSize+1, nil))
+	f.Add([]byte{255})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, rawData []byte) {
+		frame := AudioFrame{}
+		if err := frame.Decode(rawData); err != nil {
+			return
+		}
+
+		if frame.DurationMS < 0 {
+			t.Fatalf("decoded a negative duration: %d", frame.DurationMS)
+		}
+
+		encoded, err := frame.Encode()
+		if err != nil {
+			t.Fatalf("decoded frame does not encode: %v", err)
+		}
+		if !bytes.Equal(encoded, rawData) {
+			t.Fatalf("decoded frame encodes to %x, not %x", encoded, rawData)
+		}
+	})
+}
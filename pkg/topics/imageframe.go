@@ -0,0 +1,109 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxFileNameLength is the longest file name a frame can carry
+	MaxFileNameLength = 255
+	// MaxImageSize is the largest image accepted in a single frame
+	MaxImageSize = 16 * 1024 * 1024
+
+	fileNameLengthSize = 1
+	imageSizeLength    = 4
+)
+
+// Frame errors, each decoding failure wraps one of these
+var (
+	ErrFrameTruncated      = errors.New("frame is truncated")
+	ErrFrameOversized      = errors.New("frame exceeds the maximum size")
+	ErrFrameLengthMismatch = errors.New("frame length does not match its declared size")
+	ErrInvalidFileName     = errors.New("frame file name is invalid")
+)
+
+// ImageFrame is an image as sent by a device:
+//
+//	[file name length - 1 byte][file name]
+//	[image size - 32 bit little endian][image data]
+type ImageFrame struct {
+	FileName string
+	Data     []byte
+}
+
+// validateFileName ensures a name is a plain file name that cannot escape its directory
+func validateFileName(name string) error {
+	switch {
+	case len(name) == 0, len(name) > MaxFileNameLength:
+		return fmt.Errorf("%w: length %d", ErrInvalidFileName, len(name))
+	case !utf8.ValidString(name), strings.ContainsAny(name, "/\\\x00"), strings.HasPrefix(name, "."):
+		return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+	return nil
+}
+
+// Encode writes the frame in its wire format
+func (frame *ImageFrame) Encode() ([]byte, error) {
+	if err := validateFileName(frame.FileName); err != nil {
+		return nil, err
+	}
+
+	if len(frame.Data) > MaxImageSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameOversized, len(frame.Data))
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(fileNameLengthSize + len(frame.FileName) + imageSizeLength + len(frame.Data))
+
+	buffer.WriteByte(byte(len(frame.FileName)))
+	buffer.WriteString(frame.FileName)
+
+	size := make([]byte, imageSizeLength)
+	binary.LittleEndian.PutUint32(size, uint32(len(frame.Data)))
+	buffer.Write(size)
+	buffer.Write(frame.Data)
+
+	return buffer.Bytes(), nil
+}
+
+// Decode parses and validates the wire format into the frame
+func (frame *ImageFrame) Decode(rawData []byte) error {
+	if len(rawData) < fileNameLengthSize {
+		return fmt.Errorf("%w: no file name length", ErrFrameTruncated)
+	}
+
+	fileNameLength := int(rawData[0])
+	headerLength := fileNameLengthSize + fileNameLength + imageSizeLength
+	if len(rawData) < headerLength {
+		return fmt.Errorf("%w: header needs %d bytes, have %d", ErrFrameTruncated, headerLength, len(rawData))
+	}
+
+	fileName := string(rawData[fileNameLengthSize : fileNameLengthSize+fileNameLength])
+	if err := validateFileName(fileName); err != nil {
+		return err
+	}
+
+	imageSize := binary.LittleEndian.Uint32(rawData[headerLength-imageSizeLength : headerLength])
+	if imageSize > MaxImageSize {
+		return fmt.Errorf("%w: %d bytes", ErrFrameOversized, imageSize)
+	}
+
+	imageData := rawData[headerLength:]
+	switch {
+	case uint32(len(imageData)) < imageSize:
+		return fmt.Errorf("%w: %d of %d image bytes", ErrFrameTruncated, len(imageData), imageSize)
+	case uint32(len(imageData)) > imageSize:
+		return fmt.Errorf("%w: %d bytes declared, %d received", ErrFrameLengthMismatch, imageSize, len(imageData))
+	}
+
+	frame.FileName = fileName
+	frame.Data = imageData
+
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// rawFrame builds a frame by hand so the declared lengths can disagree with what follows them
+func rawFrame(fileName string, declared uint32, data []byte) []byte {
+	rawData := append([]byte{byte(len(fileName))}, fileName...)
+	size := make([]byte, imageSizeLength)
+	binary.LittleEndian.PutUint32(size, declared)
+	rawData = append(rawData, size...)
+	return append(rawData, data...)
+}
+
+func TestImageFrameDecode(t *testing.T) {
+	valid := rawFrame("capture.jpg", 4, []byte("jpeg"))
+
+	tests := []struct {
+		name    string
+		rawData []byte
+		err     error
+	}{
+		{name: "valid", rawData: valid},
+		{name: "empty image", rawData: rawFrame("capture.jpg", 0, nil)},
+		{name: "empty", rawData: nil, err: ErrFrameTruncated},
+		{name: "name length only", rawData: []byte{11}, err: ErrFrameTruncated},
+		{name: "name cut short", rawData: valid[:5], err: ErrFrameTruncated},
+		{name: "size cut short", rawData: valid[:14], err: ErrFrameTruncated},
+		{name: "image cut short", rawData: valid[:len(valid)-1], err: ErrFrameTruncated},
+		{name: "name length past the end", rawData: append([]byte{255}, "short"...), err: ErrFrameTruncated},
+		{name: "oversized", rawData: rawFrame("capture.jpg", MaxImageSize+1, []byte("jpeg")), err: ErrFrameOversized},
+		{name: "largest size", rawData: rawFrame("capture.jpg", 0xffffffff, nil), err: ErrFrameOversized},
+		{name: "trailing bytes", rawData: append(append([]byte{}, valid...), "extra"...), err: ErrFrameLengthMismatch},
+		{name: "declared less", rawData: rawFrame("capture.jpg", 2, []byte("jpeg")), err: ErrFrameLengthMismatch},
+		{name: "empty name", rawData: rawFrame("", 4, []byte("jpeg")), err: ErrInvalidFileName},
+		{name: "path", rawData: rawFrame("../etc/passwd", 4, []byte("jpeg")), err: ErrInvalidFileName},
+		{name: "hidden", rawData: rawFrame(".capture.jpg", 4, []byte("jpeg")), err: ErrInvalidFileName},
+		{name: "nul", rawData: rawFrame("cap\x00.jpg", 4, []byte("jpeg")), err: ErrInvalidFileName},
+		{name: "invalid utf8", rawData: rawFrame("cap\xff.jpg", 4, []byte("jpeg")), err: ErrInvalidFileName},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := ImageFrame{}
+			err := frame.Decode(test.rawData)
+			if test.err == nil && err != nil {
+				t.Fatalf("expected the frame to decode, got %v", err)
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err != nil && (len(frame.FileName) > 0 || frame.Data != nil) {
+				t.Errorf("a rejected frame was partly decoded into %+v", frame)
+			}
+		})
+	}
+}
+
+func TestImageFrameEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame ImageFrame
+		err   error
+	}{
+		{name: "valid", frame: ImageFrame{FileName: "capture.jpg", Data: []byte("jpeg")}},
+		{name: "longest name", frame: ImageFrame{FileName: strings.Repeat("n", MaxFileNameLength), Data: []byte("jpeg")}},
+		{name: "name too long", frame: ImageFrame{FileName: strings.Repeat("n", MaxFileNameLength+1)}, err: ErrInvalidFileName},
+		{name: "oversized", frame: ImageFrame{FileName: "capture.jpg", Data: make([]byte, MaxImageSize+1)}, err: ErrFrameOversized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rawData, err := test.frame.Encode()
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded := ImageFrame{}
+			if err = decoded.Decode(rawData); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.FileName != test.frame.FileName || !bytes.Equal(decoded.Data, test.frame.Data) {
+				t.Errorf("expected %q to round trip, got %q", test.frame.FileName, decoded.FileName)
+			}
+		})
+	}
+}
+
+// FuzzImageFrameDecode checks that no input panics and that whatever decodes encodes back to the same bytes
+func FuzzImageFrameDecode(f *testing.F) {
+	f.Add(rawFrame("capture.jpg", 4, []byte("jpeg")))
+	f.Add(rawFrame("capture.jpg", MaxImageSize+1, nil))
+	f.Add(rawFrame("../capture.jpg", 0, nil))
+	f.Add([]byte{255})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, rawData []byte) {
+		frame := ImageFrame{}
+		if err := frame.Decode(rawData); err != nil {
+			return
+		}
+
+		if err := validateFileName(frame.FileName); err != nil {
+			t.Fatalf("decoded an invalid file name: %v", err)
+		}
+
+		encoded, err := frame.Encode()
+		if err != nil {
+			t.Fatalf("decoded frame does not encode: %v", err)
+		}
+		if !bytes.Equal(encoded, rawData) {
+			t.Fatalf("decoded frame encodes to %x, not %x", encoded, rawData)
+		}
+	})
+}
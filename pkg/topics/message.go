@@ -0,0 +1,25 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"fmt"
+	"time"
+)
+
+// Message is a message received from or published to the broker, the payload is kept as
+// bytes throughout as images and other media are binary
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+	// Received is when the message arrived from the broker, zero for outgoing messages
+	Received time.Time
+	// MessageID is assigned by the broker for qos 1 and 2, zero otherwise
+	MessageID uint16
+}
+
+// String describes the message without its payload, which may be binary
+func (message *Message) String() string {
+	return fmt.Sprintf("%s (%d bytes, qos %d, id %d)", message.Topic, len(message.Payload), message.QoS, message.MessageID)
+}
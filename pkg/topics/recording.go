@@ -0,0 +1,98 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// recordedMessage is a line of a recording, the payload is base64 encoded by encoding/json
+type recordedMessage struct {
+	Time     time.Time `json:"time"`
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained,omitempty"`
+	Payload  []byte    `json:"payload"`
+}
+
+// Recorder writes messages along with when they arrived, a json object per line, so the
+// traffic can be replayed later
+type Recorder struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	count   int
+}
+
+// NewRecorder creates a recorder writing to output
+func NewRecorder(output io.Writer) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(output)}
+}
+
+// Record writes the message, it is safe to call from several goroutines
+func (recorder *Recorder) Record(message *Message) error {
+	received := message.Received
+	if received.IsZero() {
+		received = time.Now()
+	}
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	err := recorder.encoder.Encode(&recordedMessage{Time: received.UTC(), Topic: message.Topic, QoS: message.QoS,
+		Retained: message.Retained, Payload: message.Payload})
+	if err == nil {
+		recorder.count++
+	}
+	return err
+}
+
+// Count is the number of messages recorded so far
+func (recorder *Recorder) Count() int {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	return recorder.count
+}
+
+// Replay reads a recording and hands each message to deliver, waiting between messages as long
+// as passed between them while recording divided by speed. With a speed of 0 or less messages are
+// delivered as fast as deliver returns. It returns how many messages were delivered.
+func Replay(ctx context.Context, recording io.Reader, speed float64, deliver func(message *Message) error) (int, error) {
+	decoder := json.NewDecoder(recording)
+	var previous time.Time
+
+	for count := 0; ; count++ {
+		var recorded recordedMessage
+		err := decoder.Decode(&recorded)
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("invalid recording after %d messages: %v", count, err)
+		}
+
+		if speed > 0 && !previous.IsZero() && recorded.Time.After(previous) {
+			select {
+			case <-time.After(time.Duration(float64(recorded.Time.Sub(previous)) / speed)):
+			case <-ctx.Done():
+				return count, ctx.Err()
+			}
+		}
+		previous = recorded.Time
+
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+
+		message := &Message{Topic: recorded.Topic, Payload: recorded.Payload, QoS: recorded.QoS,
+			Retained: recorded.Retained, Received: time.Now()}
+		if err = deliver(message); err != nil {
+			return count, err
+		}
+	}
+}
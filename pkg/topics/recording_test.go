@@ -0,0 +1,147 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// record writes the messages, each received gap after the one before it
+func record(t *testing.T, gap time.Duration, messages ...*Message) *bytes.Buffer {
+	t.Helper()
+
+	var recording bytes.Buffer
+	recorder := NewRecorder(&recording)
+	received := time.Now()
+	for _, message := range messages {
+		message.Received = received
+		if err := recorder.Record(message); err != nil {
+			t.Fatal(err)
+		}
+		received = received.Add(gap)
+	}
+
+	if recorder.Count() != len(messages) {
+		t.Fatalf("expected %d messages recorded, counted %d", len(messages), recorder.Count())
+	}
+	return &recording
+}
+
+func TestRecordReplay(t *testing.T) {
+	messages := []*Message{
+		{Topic: TopicPrefix + "/" + ImageTopic + "/cam1", Payload: []byte{0x00, 0xff, '\n', '"'}, QoS: 1},
+		{Topic: TopicPrefix + "/" + SettingsTopic + "/cam1", Payload: []byte(`{"fps": 10}`), Retained: true},
+		{Topic: TopicPrefix + "/" + HeartbeatTopic + "/cam2"},
+	}
+	recording := record(t, time.Millisecond, messages...)
+
+	replayed := make([]*Message, 0)
+	count, err := Replay(context.Background(), recording, 0, func(message *Message) error {
+		replayed = append(replayed, message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != len(messages) || len(replayed) != len(messages) {
+		t.Fatalf("expected %d messages replayed, got %d", len(messages), count)
+	}
+	for index, message := range messages {
+		got := replayed[index]
+		if got.Topic != message.Topic || !bytes.Equal(got.Payload, message.Payload) ||
+			got.QoS != message.QoS || got.Retained != message.Retained {
+			t.Errorf("message %d: expected %s, replayed %s", index, message, got)
+		}
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		speed   float64
+		minimum time.Duration
+		maximum time.Duration
+	}{
+		{name: "recorded", speed: 1, minimum: 100 * time.Millisecond, maximum: time.Second},
+		{name: "faster", speed: 4, minimum: 25 * time.Millisecond, maximum: 95 * time.Millisecond},
+		{name: "unpaced", speed: 0, maximum: 50 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// three messages 50ms apart span 100ms
+			recording := record(t, 50*time.Millisecond, &Message{Topic: "a"}, &Message{Topic: "b"}, &Message{Topic: "c"})
+
+			started := time.Now()
+			if _, err := Replay(context.Background(), recording, test.speed, func(*Message) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			if took := time.Since(started); took < test.minimum || took > test.maximum {
+				t.Errorf("expected the replay to take between %v and %v, took %v", test.minimum, test.maximum, took)
+			}
+		})
+	}
+}
+
+func TestReplayStops(t *testing.T) {
+	recording := record(t, time.Hour, &Message{Topic: "a"}, &Message{Topic: "b"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	count, err := Replay(ctx, recording, 1, func(*Message) error { return nil })
+	if count != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait for the second message to be cut short, got %d messages and %v", count, err)
+	}
+
+	failed := errors.New("broker went away")
+	recording = record(t, 0, &Message{Topic: "a"}, &Message{Topic: "b"})
+	count, err = Replay(context.Background(), recording, 0, func(*Message) error { return failed })
+	if count != 0 || !errors.Is(err, failed) {
+		t.Errorf("expected the delivery error to stop the replay, got %d messages and %v", count, err)
+	}
+}
+
+func TestReplayInvalidRecording(t *testing.T) {
+	complete := record(t, 0, &Message{Topic: "a", Payload: []byte("one")}, &Message{Topic: "b", Payload: []byte("two")}).String()
+
+	tests := []struct {
+		name      string
+		recording string
+		delivered int
+	}{
+		{name: "truncated", recording: complete[:len(complete)-10], delivered: 1},
+		{name: "cut between fields", recording: complete[:strings.Index(complete, "\n")+20], delivered: 1},
+		{name: "not json", recording: "not a recording\n", delivered: 0},
+		{name: "wrong types", recording: `{"topic": 5}` + "\n", delivered: 0},
+		{name: "bad payload", recording: `{"topic": "a", "payload": "not base64!"}` + "\n", delivered: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delivered := 0
+			count, err := Replay(context.Background(), strings.NewReader(test.recording), 0, func(*Message) error {
+				delivered++
+				return nil
+			})
+
+			if err == nil {
+				t.Fatal("expected the recording to be rejected")
+			}
+			if count != test.delivered || delivered != test.delivered {
+				t.Errorf("expected %d messages before the error, got %d", test.delivered, count)
+			}
+		})
+	}
+
+	count, err := Replay(context.Background(), strings.NewReader(""), 0, func(*Message) error { return nil })
+	if count != 0 || err != nil {
+		t.Errorf("expected an empty recording to replay nothing, got %d messages and %v", count, err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// TopicPrefix is the root of every topic this application handles
+	TopicPrefix = "afm/v1"
+
+	topicSeparator      = "/"
+	singleLevelWildcard = "+"
+	multiLevelWildcard  = "#"
+	systemTopicPrefix   = "$"
+)
+
+// ErrUnknownTopic is returned when no handler is registered for a topic
+var ErrUnknownTopic = errors.New("no handler registered for topic")
+
+// Decoder converts the payload received from a device into its typed data
+type Decoder func(deviceID string, payload []byte) (DeviceData, error)
+
+// Handler processes decoded device data along with the message it arrived in
+type Handler func(ctx context.Context, message *Message, data DeviceData) error
+
+// UnknownHandler processes messages on topics without a registered handler
+type UnknownHandler func(ctx context.Context, message *Message) error
+
+// route is a registered pattern and the handler for it
+type route struct {
+	pattern []string
+	// deviceLevel is the topic level matched by the first single level wildcard, -1 if none
+	deviceLevel int
+	decoder     Decoder
+	handler     Handler
+}
+
+// Router dispatches incoming messages to the handler registered for the matching topic pattern
+type Router struct {
+	lock    sync.RWMutex
+	routes  []route
+	unknown UnknownHandler
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// ActionPattern returns the pattern for an action where the last level is the device id
+// i.e. afm/v1/<action>/+
+func ActionPattern(action string) string {
+	return TopicPrefix + topicSeparator + action + topicSeparator + singleLevelWildcard
+}
+
+// DeviceID returns the device id of a topic of the form afm/v1/<action>/<device_id>,
+// or an empty string for any other topic
+func DeviceID(topic string) string {
+	levels := strings.Split(topic, topicSeparator)
+	if len(levels) != 4 || levels[0]+topicSeparator+levels[1] != TopicPrefix {
+		return ""
+	}
+	return levels[3]
+}
+
+// validatePattern ensures wildcards follow mqtt rules, + occupying a full level and # the last one
+func validatePattern(levels []string) error {
+	for index, level := range levels {
+		switch {
+		case level == multiLevelWildcard && index != len(levels)-1:
+			return fmt.Errorf("%s must be the last level", multiLevelWildcard)
+		case level != singleLevelWildcard && strings.Contains(level, singleLevelWildcard),
+			level != multiLevelWildcard && strings.Contains(level, multiLevelWildcard):
+			return fmt.Errorf("wildcards must occupy an entire level: %q", level)
+		}
+	}
+	return nil
+}
+
+// Handle registers the decoder and handler for a topic pattern, patterns are matched in
+// the order they were registered and the level matched by the first + is the device id
+func (router *Router) Handle(pattern string, decoder Decoder, handler Handler) error {
+	levels := strings.Split(pattern, topicSeparator)
+	if err := validatePattern(levels); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+
+	if decoder == nil || handler == nil {
+		return fmt.Errorf("pattern %q requires a decoder and handler", pattern)
+	}
+
+	deviceLevel := -1
+	for index, level := range levels {
+		if level == singleLevelWildcard {
+			deviceLevel = index
+			break
+		}
+	}
+
+	router.lock.Lock()
+	defer router.lock.Unlock()
+
+	router.routes = append(router.routes, route{pattern: levels, deviceLevel: deviceLevel, decoder: decoder, handler: handler})
+
+	return nil
+}
+
+// HandleUnknown registers the handler for messages no pattern matches, without it Route
+// returns ErrUnknownTopic for them
+func (router *Router) HandleUnknown(handler UnknownHandler) {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+
+	router.unknown = handler
+}
+
+// Route decodes the payload and passes it to the handler registered for the topic
+func (router *Router) Route(ctx context.Context, message *Message) error {
+	topic := message.Topic
+	levels := strings.Split(topic, topicSeparator)
+
+	router.lock.RLock()
+	unknown := router.unknown
+	var matched *route
+	for index := range router.routes {
+		if matchLevels(router.routes[index].pattern, levels) {
+			matched = &router.routes[index]
+			break
+		}
+	}
+	router.lock.RUnlock()
+
+	if matched == nil {
+		if unknown != nil {
+			return unknown(ctx, message)
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownTopic, topic)
+	}
+
+	deviceID := ""
+	if matched.deviceLevel >= 0 {
+		deviceID = levels[matched.deviceLevel]
+	}
+
+	data, err := matched.decoder(deviceID, message.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", topic, err)
+	}
+
+	return matched.handler(ctx, message, data)
+}
+
+// MatchTopic reports whether the topic matches the pattern using mqtt wildcard semantics
+func MatchTopic(pattern, topic string) bool {
+	return matchLevels(strings.Split(pattern, topicSeparator), strings.Split(topic, topicSeparator))
+}
+
+func matchLevels(pattern, topic []string) bool {
+	// wildcards never match the system topics at the first level
+	if len(topic) > 0 && strings.HasPrefix(topic[0], systemTopicPrefix) &&
+		len(pattern) > 0 && (pattern[0] == singleLevelWildcard || pattern[0] == multiLevelWildcard) {
+		return false
+	}
+
+	for index, level := range pattern {
+		if level == multiLevelWildcard {
+			// # also matches the parent level
+			return true
+		}
+
+		if index >= len(topic) {
+			return false
+		}
+
+		if level != singleLevelWildcard && level != topic[index] {
+			return false
+		}
+	}
+
+	return len(pattern) == len(topic)
+}
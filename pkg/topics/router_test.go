@@ -0,0 +1,151 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{pattern: "afm/v1/image/cam1", topic: "afm/v1/image/cam1", match: true},
+		{pattern: "afm/v1/image/cam1", topic: "afm/v1/image/cam2", match: false},
+		{pattern: "afm/v1/image/+", topic: "afm/v1/image/cam1", match: true},
+		{pattern: "afm/v1/image/+", topic: "afm/v1/image", match: false},
+		{pattern: "afm/v1/image/+", topic: "afm/v1/image/cam1/extra", match: false},
+		{pattern: "afm/+/image/+", topic: "afm/v2/image/cam1", match: true},
+		{pattern: "afm/v1/#", topic: "afm/v1/image/cam1", match: true},
+		{pattern: "afm/v1/#", topic: "afm/v1", match: true},
+		{pattern: "afm/v1/#", topic: "afm/v2/image", match: false},
+		{pattern: "#", topic: "afm/v1/image/cam1", match: true},
+		{pattern: "#", topic: "afm", match: true},
+		{pattern: "+/v1/#", topic: "afm/v1/settings/cam1", match: true},
+		{pattern: "#", topic: "$SYS/broker/uptime", match: false},
+		{pattern: "+/broker/uptime", topic: "$SYS/broker/uptime", match: false},
+		{pattern: "$SYS/#", topic: "$SYS/broker/uptime", match: true},
+		{pattern: "afm/v1/image/+", topic: "afm/v1/image/", match: true},
+		{pattern: "afm/+/image", topic: "afm//image", match: true},
+		{pattern: "afm/v1/image", topic: "afm/v1/image/", match: false},
+		{pattern: "/afm/+", topic: "/afm/cam1", match: true},
+		{pattern: "+/afm/+", topic: "/afm/cam1", match: true},
+		{pattern: "afm/+", topic: "/afm/cam1", match: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.topic, func(t *testing.T) {
+			if match := MatchTopic(test.pattern, test.topic); match != test.match {
+				t.Errorf("expected match to be %v", test.match)
+			}
+		})
+	}
+}
+
+// ignore is a handler for routes the test only registers
+func ignore(ctx context.Context, message *Message, data DeviceData) error {
+	return nil
+}
+
+func TestHandleInvalidPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		valid   bool
+	}{
+		{name: "action", pattern: ActionPattern(ImageTopic), valid: true},
+		{name: "root wildcard", pattern: "#", valid: true},
+		{name: "trailing wildcard", pattern: "afm/v1/#", valid: true},
+		{name: "empty level", pattern: "afm//+", valid: true},
+		{name: "wildcard before the last level", pattern: "afm/#/cam1", valid: false},
+		{name: "wildcard twice", pattern: "#/#", valid: false},
+		{name: "wildcard within a level", pattern: "afm/v1/image#", valid: false},
+		{name: "single level wildcard within a level", pattern: "afm/v1/cam+", valid: false},
+		{name: "both wildcards in a level", pattern: "afm/+#", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewRouter().Handle(test.pattern, DecodeAckData, ignore)
+			if test.valid && err != nil {
+				t.Errorf("expected %q to be accepted, got %v", test.pattern, err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected %q to be rejected", test.pattern)
+			}
+		})
+	}
+
+	if err := NewRouter().Handle("afm/+", nil, ignore); err == nil {
+		t.Error("expected a route without a decoder to be rejected")
+	}
+	if err := NewRouter().Handle("afm/+", DecodeAckData, nil); err == nil {
+		t.Error("expected a route without a handler to be rejected")
+	}
+}
+
+func TestRoute(t *testing.T) {
+	router := NewRouter()
+	routed := ""
+	handler := func(name string) Handler {
+		return func(ctx context.Context, message *Message, data DeviceData) error {
+			routed = name + " " + data.GetDeviceID()
+			return nil
+		}
+	}
+
+	failed := errors.New("undecodable")
+	routes := []struct {
+		pattern string
+		decoder Decoder
+		name    string
+	}{
+		{pattern: ActionPattern(ImageTopic), decoder: DecodeAckData, name: "image"},
+		{pattern: TopicPrefix + "/+/+/extra", decoder: DecodeAckData, name: "extra"},
+		{pattern: ActionPattern(VideoTopic), decoder: func(string, []byte) (DeviceData, error) { return nil, failed }, name: "video"},
+		{pattern: TopicPrefix + "/#", decoder: DecodeAckData, name: "anything"},
+	}
+	for _, route := range routes {
+		if err := router.Handle(route.pattern, route.decoder, handler(route.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		topic  string
+		routed string
+		err    error
+	}{
+		{topic: TopicPrefix + "/" + ImageTopic + "/cam1", routed: "image cam1"},
+		{topic: TopicPrefix + "/" + ImageTopic + "/cam1/extra", routed: "extra image"},
+		{topic: TopicPrefix + "/" + SettingsTopic + "/cam1", routed: "anything "},
+		{topic: TopicPrefix + "/" + VideoTopic + "/cam1", err: failed},
+		{topic: "other/topic", err: ErrUnknownTopic},
+	}
+
+	for _, test := range tests {
+		t.Run(test.topic, func(t *testing.T) {
+			routed = ""
+			err := router.Route(context.Background(), &Message{Topic: test.topic})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if routed != test.routed {
+				t.Errorf("expected the message routed to %q, got %q", test.routed, routed)
+			}
+		})
+	}
+
+	// once registered, the fallback takes the topics no pattern matches
+	unknown := ""
+	router.HandleUnknown(func(ctx context.Context, message *Message) error {
+		unknown = message.Topic
+		return nil
+	})
+	if err := router.Route(context.Background(), &Message{Topic: "other/topic"}); err != nil || unknown != "other/topic" {
+		t.Errorf("expected the unknown topic to reach the fallback, got %q: %v", unknown, err)
+	}
+}
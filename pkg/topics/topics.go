@@ -1,11 +1,6 @@
 // Package topics for handling all topics
 package topics
 
-import (
-	"fmt"
-	"strings"
-)
-
 const (
 	// SettingsType is indicative of a settings data object
 	SettingsType = 1
@@ -23,9 +18,24 @@ const (
 	AudioType = 4
 	// AudioTopic is the topic for audio data
 	AudioTopic = "audio"
-
-	// NumberTopicPortions is the number of parts of an incoming topic that is expected
-	NumberTopicPortions = 4
+	// AckType is indicative of a command acknowledgement
+	AckType = 5
+	// AckTopic is the topic devices acknowledge commands on
+	AckTopic = "ack"
+	// CommandTopic is the topic commands are sent to devices on
+	CommandTopic = "cmd"
+	// RegisterType is indicative of a device registration
+	RegisterType = 6
+	// RegisterTopic is the topic devices register on when they start
+	RegisterTopic = "register"
+	// RegisteredTopic is the topic devices are told their provisioning state on
+	RegisteredTopic = "registered"
+	// StatusType is indicative of a device presence report
+	StatusType = 7
+	// StatusTopic is the topic devices announce going online or offline on, including their last will
+	StatusTopic = "status"
+	// HeartbeatTopic is the topic devices periodically publish on while connected
+	HeartbeatTopic = "heartbeat"
 )
 
 // DeviceData interface that all device data packets implement
@@ -74,6 +84,7 @@ func (ds *DeviceSettings) GetType() int {
 type ImageData struct {
 	data     []byte
 	deviceID string
+	frame    ImageFrame
 }
 
 // GetData implements DeviceData intereface to return the data
@@ -81,13 +92,23 @@ func (img *ImageData) GetData() []byte {
 	return img.data
 }
 
-// SetData sets the data for the incoming object
+// SetData sets the data for the incoming object, failing if it is not a valid image frame
 func (img *ImageData) SetData(incomingData []byte) error {
+	err := img.frame.Decode(incomingData)
+	if err != nil {
+		return err
+	}
+
 	img.data = incomingData
 
 	return nil
 }
 
+// Frame returns the validated image frame
+func (img *ImageData) Frame() *ImageFrame {
+	return &img.frame
+}
+
 // GetDeviceID returns the associated device id with the data
 func (img *ImageData) GetDeviceID() string {
 	return img.deviceID
@@ -107,6 +128,7 @@ func (img *ImageData) GetType() int {
 type VideoData struct {
 	data     []byte
 	deviceID string
+	frame    VideoFrame
 }
 
 // GetData implements DeviceData intereface to return the data
@@ -114,13 +136,23 @@ func (vid *VideoData) GetData() []byte {
 	return vid.data
 }
 
-// SetData sets the data for the incoming object
+// SetData sets the data for the incoming object, failing if it is not a valid video frame
 func (vid *VideoData) SetData(incomingData []byte) error {
+	err := vid.frame.Decode(incomingData)
+	if err != nil {
+		return err
+	}
+
 	vid.data = incomingData
 
 	return nil
 }
 
+// Frame returns the validated video frame
+func (vid *VideoData) Frame() *VideoFrame {
+	return &vid.frame
+}
+
 // GetDeviceID returns the associated device id with the data
 func (vid *VideoData) GetDeviceID() string {
 	return vid.deviceID
@@ -140,6 +172,7 @@ func (vid *VideoData) GetType() int {
 type AudioData struct {
 	data     []byte
 	deviceID string
+	frame    AudioFrame
 }
 
 // GetData implements DeviceData intereface to return the data
@@ -147,13 +180,23 @@ func (aud *AudioData) GetData() []byte {
 	return aud.data
 }
 
-// SetData sets the data for the incoming object
+// SetData sets the data for the incoming object, failing if it is not a valid audio frame
 func (aud *AudioData) SetData(incomingData []byte) error {
+	err := aud.frame.Decode(incomingData)
+	if err != nil {
+		return err
+	}
+
 	aud.data = incomingData
 
 	return nil
 }
 
+// Frame returns the validated audio frame
+func (aud *AudioData) Frame() *AudioFrame {
+	return &aud.frame
+}
+
 // GetDeviceID returns the associated device id with the data
 func (aud *AudioData) GetDeviceID() string {
 	return aud.deviceID
@@ -169,7 +212,146 @@ func (aud *AudioData) GetType() int {
 	return AudioType
 }
 
-func processAudioData(deviceID string, data []byte) (*AudioData, error) {
+// AckData struct representing a command acknowledgement from a client
+type AckData struct {
+	data     []byte
+	deviceID string
+}
+
+// GetData implements DeviceData intereface to return the data
+func (ack *AckData) GetData() []byte {
+	return ack.data
+}
+
+// SetData sets the data for the incoming object
+func (ack *AckData) SetData(incomingData []byte) error {
+	ack.data = incomingData
+
+	return nil
+}
+
+// GetDeviceID returns the associated device id with the data
+func (ack *AckData) GetDeviceID() string {
+	return ack.deviceID
+}
+
+// SetDeviceID set the associated device id
+func (ack *AckData) SetDeviceID(identifier string) {
+	ack.deviceID = identifier
+}
+
+// GetType returns the acknowledgement type
+func (ack *AckData) GetType() int {
+	return AckType
+}
+
+// DecodeAckData decodes the command acknowledgement sent by a device
+func DecodeAckData(deviceID string, data []byte) (DeviceData, error) {
+	var ackData AckData
+
+	ackData.SetDeviceID(deviceID)
+	err := ackData.SetData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ackData, nil
+}
+
+// RegisterData struct representing a registration from a client
+type RegisterData struct {
+	data     []byte
+	deviceID string
+}
+
+// GetData implements DeviceData intereface to return the data
+func (reg *RegisterData) GetData() []byte {
+	return reg.data
+}
+
+// SetData sets the data for the incoming object
+func (reg *RegisterData) SetData(incomingData []byte) error {
+	reg.data = incomingData
+
+	return nil
+}
+
+// GetDeviceID returns the associated device id with the data
+func (reg *RegisterData) GetDeviceID() string {
+	return reg.deviceID
+}
+
+// SetDeviceID set the associated device id
+func (reg *RegisterData) SetDeviceID(identifier string) {
+	reg.deviceID = identifier
+}
+
+// GetType returns the registration type
+func (reg *RegisterData) GetType() int {
+	return RegisterType
+}
+
+// DecodeRegisterData decodes the registration sent by a device
+func DecodeRegisterData(deviceID string, data []byte) (DeviceData, error) {
+	var registerData RegisterData
+
+	registerData.SetDeviceID(deviceID)
+	err := registerData.SetData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registerData, nil
+}
+
+// StatusData struct representing a heartbeat or status report from a client
+type StatusData struct {
+	data     []byte
+	deviceID string
+}
+
+// GetData implements DeviceData intereface to return the data
+func (status *StatusData) GetData() []byte {
+	return status.data
+}
+
+// SetData sets the data for the incoming object
+func (status *StatusData) SetData(incomingData []byte) error {
+	status.data = incomingData
+
+	return nil
+}
+
+// GetDeviceID returns the associated device id with the data
+func (status *StatusData) GetDeviceID() string {
+	return status.deviceID
+}
+
+// SetDeviceID set the associated device id
+func (status *StatusData) SetDeviceID(identifier string) {
+	status.deviceID = identifier
+}
+
+// GetType returns the status type
+func (status *StatusData) GetType() int {
+	return StatusType
+}
+
+// DecodeStatusData decodes the heartbeat or status report sent by a device
+func DecodeStatusData(deviceID string, data []byte) (DeviceData, error) {
+	var statusData StatusData
+
+	statusData.SetDeviceID(deviceID)
+	err := statusData.SetData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statusData, nil
+}
+
+// DecodeAudioData decodes the audio data sent by a device
+func DecodeAudioData(deviceID string, data []byte) (DeviceData, error) {
 	var audioData AudioData
 
 	audioData.SetDeviceID(deviceID)
@@ -181,7 +363,8 @@ func processAudioData(deviceID string, data []byte) (*AudioData, error) {
 	return &audioData, nil
 }
 
-func processVideoData(deviceID string, data []byte) (*VideoData, error) {
+// DecodeVideoData decodes the video data sent by a device
+func DecodeVideoData(deviceID string, data []byte) (DeviceData, error) {
 	var videoData VideoData
 
 	videoData.SetDeviceID(deviceID)
@@ -193,7 +376,8 @@ func processVideoData(deviceID string, data []byte) (*VideoData, error) {
 	return &videoData, nil
 }
 
-func processImageData(deviceID string, data []byte) (*ImageData, error) {
+// DecodeImageData decodes the image data sent by a device
+func DecodeImageData(deviceID string, data []byte) (DeviceData, error) {
 	var imageData ImageData
 
 	imageData.SetDeviceID(deviceID)
@@ -205,7 +389,8 @@ func processImageData(deviceID string, data []byte) (*ImageData, error) {
 	return &imageData, nil
 }
 
-func processDeviceSettings(deviceID string, data []byte) (*DeviceSettings, error) {
+// DecodeDeviceSettings decodes the settings sent by a device
+func DecodeDeviceSettings(deviceID string, data []byte) (DeviceData, error) {
 	var deviceSettings DeviceSettings
 
 	deviceSettings.SetDeviceID(deviceID)
@@ -216,33 +401,3 @@ func processDeviceSettings(deviceID string, data []byte) (*DeviceSettings, error
 
 	return &deviceSettings, nil
 }
-
-// ProcessIncomingMQTTMessage to process an incoming message
-// topics should be afm/v1/<action>/device_id
-func ProcessIncomingMQTTMessage(topic, message string) (DeviceData, error) {
-	// device id
-	topicParts := strings.Split(topic, "/")
-
-	if len(topicParts) >= NumberTopicPortions {
-		deviceID := topicParts[NumberTopicPortions-1]
-		deviceData := []byte(message)
-
-		// which topic?
-		topics := []string{SettingsTopic, ImageTopic, VideoTopic, AudioTopic}
-		for _, v := range topics {
-			if strings.Contains(topic, v) {
-				switch v {
-				case SettingsTopic:
-					return processDeviceSettings(deviceID, deviceData)
-				case ImageTopic:
-					return processImageData(deviceID, deviceData)
-				case VideoTopic:
-					return processVideoData(deviceID, deviceData)
-				case AudioTopic:
-					return processAudioData(deviceID, deviceData)
-				}
-			}
-		}
-	}
-	return nil, fmt.Errorf("failed to process message")
-}
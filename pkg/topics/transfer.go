@@ -0,0 +1,401 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TransferTopic is the topic replies about chunked transfers are published on
+	TransferTopic = "transfer"
+
+	// MaxChunks is the most chunks a single transfer can be split into
+	MaxChunks = 4096
+	// MaxTransferSize is the largest payload that can be reassembled
+	MaxTransferSize = 256 * 1024 * 1024
+	// MaxTransfers is the most transfers that can be in progress at once
+	MaxTransfers = 64
+	// MaxBufferedSize is the most chunk data held across every transfer in progress, a few
+	// transfers at the largest size rather than every transfer at it
+	MaxBufferedSize = 1024 * 1024 * 1024
+
+	// chunkMarker starts every chunk, a frame never starts with it as file names cannot be empty
+	chunkMarker      = 0x00
+	transferIDLength = 16
+	chunkHeaderSize  = 2 + transferIDLength + 3*4
+)
+
+// ChunkKind distinguishes chunks carrying data from a device asking where a transfer stands
+type ChunkKind byte
+
+const (
+	// DataChunk carries a portion of the payload
+	DataChunk ChunkKind = iota
+	// StatusChunk asks for the missing chunks of a transfer, e.g. after a reconnect
+	StatusChunk
+)
+
+// Transfer states reported to the device
+const (
+	TransferIncomplete = "incomplete"
+	TransferComplete   = "complete"
+	TransferExpired    = "expired"
+	TransferUnknown    = "unknown"
+	TransferRefused    = "refused"
+)
+
+// ErrTransferIncomplete is returned while a chunked transfer is still waiting on chunks
+var ErrTransferIncomplete = errors.New("transfer is incomplete")
+
+// Chunk is a part of a payload too large for a single publish:
+//
+//	[0x00][kind - 1 byte][transfer id - 16 bytes]
+//	[chunk index - 32 bit][total chunks - 32 bit][crc32 of chunk data - 32 bit]
+//	[chunk data]
+//
+// all integers are little endian
+type Chunk struct {
+	Kind       ChunkKind
+	TransferID [transferIDLength]byte
+	Index      uint32
+	Total      uint32
+	Checksum   uint32
+	Data       []byte
+}
+
+// TransferReply tells the device the state of a transfer and which chunks it still has to send,
+// a refused chunk comes with the reason it was refused
+type TransferReply struct {
+	TransferID string   `json:"transfer"`
+	Action     string   `json:"action"`
+	State      string   `json:"state"`
+	Missing    []uint32 `json:"missing,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+// Publisher sends a message to the broker
+type Publisher func(topic string, payload []byte)
+
+// IsChunk reports whether a payload is a chunk rather than a complete frame
+func IsChunk(rawData []byte) bool {
+	return len(rawData) > 0 && rawData[0] == chunkMarker
+}
+
+// NewChunk creates a data chunk, computing its checksum
+func NewChunk(transferID [transferIDLength]byte, index, total uint32, data []byte) *Chunk {
+	return &Chunk{Kind: DataChunk, TransferID: transferID, Index: index, Total: total, Checksum: crc32.ChecksumIEEE(data), Data: data}
+}
+
+// Encode writes the chunk in its wire format
+func (chunk *Chunk) Encode() []byte {
+	rawData := make([]byte, chunkHeaderSize, chunkHeaderSize+len(chunk.Data))
+
+	rawData[0] = chunkMarker
+	rawData[1] = byte(chunk.Kind)
+	copy(rawData[2:], chunk.TransferID[:])
+
+	offset := 2 + transferIDLength
+	binary.LittleEndian.PutUint32(rawData[offset:], chunk.Index)
+	binary.LittleEndian.PutUint32(rawData[offset+4:], chunk.Total)
+	binary.LittleEndian.PutUint32(rawData[offset+8:], chunk.Checksum)
+
+	return append(rawData, chunk.Data...)
+}
+
+// Decode parses and validates the wire format into the chunk
+func (chunk *Chunk) Decode(rawData []byte) error {
+	if !IsChunk(rawData) {
+		return fmt.Errorf("not a chunk")
+	}
+
+	if len(rawData) < chunkHeaderSize {
+		return fmt.Errorf("%w: chunk header needs %d bytes, have %d", ErrFrameTruncated, chunkHeaderSize, len(rawData))
+	}
+
+	chunk.Kind = ChunkKind(rawData[1])
+	copy(chunk.TransferID[:], rawData[2:])
+
+	offset := 2 + transferIDLength
+	chunk.Index = binary.LittleEndian.Uint32(rawData[offset:])
+	chunk.Total = binary.LittleEndian.Uint32(rawData[offset+4:])
+	chunk.Checksum = binary.LittleEndian.Uint32(rawData[offset+8:])
+	chunk.Data = rawData[chunkHeaderSize:]
+
+	switch {
+	case chunk.Kind != DataChunk && chunk.Kind != StatusChunk:
+		return fmt.Errorf("unknown chunk kind: %d", chunk.Kind)
+	case chunk.Kind == StatusChunk:
+		return nil
+	case chunk.Total == 0 || chunk.Total > MaxChunks:
+		return fmt.Errorf("%w: %d chunks", ErrFrameOversized, chunk.Total)
+	case chunk.Index >= chunk.Total:
+		return fmt.Errorf("chunk %d is outside of %d chunks", chunk.Index, chunk.Total)
+	case crc32.ChecksumIEEE(chunk.Data) != chunk.Checksum:
+		return fmt.Errorf("chunk %d failed its checksum", chunk.Index)
+	}
+	return nil
+}
+
+// transfer is a payload being reassembled
+type transfer struct {
+	action   string
+	deviceID string
+	id       string
+	chunks   [][]byte
+	received uint32
+	size     int
+	lastSeen time.Time
+}
+
+func (current *transfer) missing() []uint32 {
+	missing := make([]uint32, 0, len(current.chunks)-int(current.received))
+	for index, data := range current.chunks {
+		if data == nil {
+			missing = append(missing, uint32(index))
+		}
+	}
+	return missing
+}
+
+// outgoing is a reply waiting to be published once the lock is released
+type outgoing struct {
+	deviceID string
+	reply    *TransferReply
+}
+
+// Reassembler collects the chunks of each transfer until the payload is complete
+type Reassembler struct {
+	lock      sync.Mutex
+	actions   map[string]bool
+	transfers map[string]*transfer
+	buffered  int
+	budget    int
+	timeout   time.Duration
+	publish   Publisher
+}
+
+// NewReassembler creates a reassembler that abandons transfers idle for longer than the timeout
+// and sends its replies to devices through publish
+func NewReassembler(timeout time.Duration, publish Publisher) *Reassembler {
+	return &Reassembler{
+		actions:   make(map[string]bool),
+		transfers: make(map[string]*transfer),
+		budget:    MaxBufferedSize,
+		timeout:   timeout,
+		publish:   publish,
+	}
+}
+
+// Chunked lets devices send the payloads of the actions as chunks
+func (reassembler *Reassembler) Chunked(actions ...string) {
+	reassembler.lock.Lock()
+	defer reassembler.lock.Unlock()
+
+	for _, action := range actions {
+		reassembler.actions[action] = true
+	}
+}
+
+// Assemble adds a chunk for a Chunked action to its transfer, returning the message with the
+// complete payload once every chunk has arrived and ErrTransferIncomplete until then. Any other
+// message is returned as it is. Processing the result rather than the chunks means a message can
+// be retried, or kept as a dead letter, without the transfer that has since completed. A chunk
+// that is refused is the device's to resend, it is told why and the error is returned.
+func (reassembler *Reassembler) Assemble(message *Message) (*Message, error) {
+	deviceID := DeviceID(message.Topic)
+	if !IsChunk(message.Payload) || len(deviceID) == 0 {
+		return message, nil
+	}
+
+	action := strings.Split(message.Topic, topicSeparator)[2]
+
+	reassembler.lock.Lock()
+	chunked := reassembler.actions[action]
+	reassembler.lock.Unlock()
+
+	if !chunked {
+		return message, nil
+	}
+
+	var chunk Chunk
+	if err := chunk.Decode(message.Payload); err != nil {
+		// without a complete header there is no transfer to tell the device about
+		if !errors.Is(err, ErrFrameTruncated) {
+			reassembler.reply(deviceID, refused(hex.EncodeToString(chunk.TransferID[:]), action, nil, err))
+		}
+		return nil, err
+	}
+
+	payload, err := reassembler.Accept(action, deviceID, &chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	complete := *message
+	complete.Payload = payload
+	return &complete, nil
+}
+
+// Accept adds the chunk to its transfer, returning the payload once it is complete
+func (reassembler *Reassembler) Accept(action, deviceID string, chunk *Chunk) ([]byte, error) {
+	reassembler.lock.Lock()
+	payload, reply, err := reassembler.accept(action, deviceID, chunk)
+	reassembler.lock.Unlock()
+
+	// publishing can block on the broker, so it never holds up the chunks of other transfers
+	if reply != nil {
+		reassembler.reply(deviceID, reply)
+	}
+	return payload, err
+}
+
+// accept adds the chunk with the lock held, returning the reply to send once it is released
+func (reassembler *Reassembler) accept(action, deviceID string, chunk *Chunk) ([]byte, *TransferReply, error) {
+	transferID := hex.EncodeToString(chunk.TransferID[:])
+	key := action + topicSeparator + deviceID + topicSeparator + transferID
+
+	current, found := reassembler.transfers[key]
+
+	if chunk.Kind == StatusChunk {
+		reply := TransferReply{TransferID: transferID, Action: action, State: TransferUnknown}
+		if found {
+			reply.State = TransferIncomplete
+			reply.Missing = current.missing()
+		}
+		return nil, &reply, ErrTransferIncomplete
+	}
+
+	if !found {
+		if len(reassembler.transfers) >= MaxTransfers {
+			err := fmt.Errorf("too many transfers in progress to start %s", transferID)
+			return nil, refused(transferID, action, nil, err), err
+		}
+		current = &transfer{action: action, deviceID: deviceID, id: transferID, chunks: make([][]byte, chunk.Total)}
+		reassembler.transfers[key] = current
+	}
+
+	if int(chunk.Total) != len(current.chunks) {
+		err := fmt.Errorf("chunk total %d does not match transfer of %d chunks", chunk.Total, len(current.chunks))
+		return nil, refused(transferID, action, current, err), err
+	}
+
+	current.lastSeen = time.Now()
+	if current.chunks[chunk.Index] == nil {
+		if current.size+len(chunk.Data) > MaxTransferSize {
+			reassembler.remove(key)
+			err := fmt.Errorf("%w: transfer %s", ErrFrameOversized, transferID)
+			return nil, refused(transferID, action, nil, err), err
+		}
+		// the chunk is refused rather than the transfer, the device resends it once others complete
+		if reassembler.buffered+len(chunk.Data) > reassembler.budget {
+			err := fmt.Errorf("%w: transfers in progress hold %d bytes, chunk %d of %s refused",
+				ErrFrameOversized, reassembler.buffered, chunk.Index, transferID)
+			return nil, refused(transferID, action, current, err), err
+		}
+		current.chunks[chunk.Index] = append([]byte{}, chunk.Data...)
+		current.received++
+		current.size += len(chunk.Data)
+		reassembler.buffered += len(chunk.Data)
+	}
+
+	if current.received < chunk.Total {
+		// once the last chunk has been sent let the device know what it needs to resend
+		if chunk.Index == chunk.Total-1 {
+			return nil, &TransferReply{TransferID: transferID, Action: action, State: TransferIncomplete, Missing: current.missing()}, ErrTransferIncomplete
+		}
+		return nil, nil, ErrTransferIncomplete
+	}
+
+	reassembler.remove(key)
+
+	payload := make([]byte, 0, current.size)
+	for _, data := range current.chunks {
+		payload = append(payload, data...)
+	}
+
+	return payload, &TransferReply{TransferID: transferID, Action: action, State: TransferComplete}, nil
+}
+
+// refused is the reply telling the device why its chunk was refused, with the chunks the transfer
+// still needs when it is kept
+func refused(transferID, action string, current *transfer, reason error) *TransferReply {
+	reply := &TransferReply{TransferID: transferID, Action: action, State: TransferRefused, Reason: reason.Error()}
+	if current != nil {
+		reply.Missing = current.missing()
+	}
+	return reply
+}
+
+// remove drops the transfer and releases the data it held, the lock must be held
+func (reassembler *Reassembler) remove(key string) {
+	if current, found := reassembler.transfers[key]; found {
+		reassembler.buffered -= current.size
+		delete(reassembler.transfers, key)
+	}
+}
+
+// Expire abandons transfers that have not received a chunk within the timeout
+func (reassembler *Reassembler) Expire() {
+	replies := make([]outgoing, 0)
+
+	reassembler.lock.Lock()
+	for key, current := range reassembler.transfers {
+		if time.Since(current.lastSeen) > reassembler.timeout {
+			logrus.Warnf("abandoning %s transfer %s from %s with %d of %d chunks",
+				current.action, current.id, current.deviceID, current.received, len(current.chunks))
+			reassembler.remove(key)
+			replies = append(replies, outgoing{deviceID: current.deviceID,
+				reply: &TransferReply{TransferID: current.id, Action: current.action, State: TransferExpired}})
+		}
+	}
+	reassembler.lock.Unlock()
+
+	for _, pending := range replies {
+		reassembler.reply(pending.deviceID, pending.reply)
+	}
+}
+
+// Run expires idle transfers until the context is done
+func (reassembler *Reassembler) Run(ctx context.Context) {
+	if reassembler.timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(reassembler.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reassembler.Expire()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reply publishes the transfer state on afm/v1/transfer/<device_id>
+func (reassembler *Reassembler) reply(deviceID string, reply *TransferReply) {
+	if reassembler.publish == nil {
+		return
+	}
+
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		logrus.Errorf("failed to encode transfer reply: %v", err)
+		return
+	}
+
+	reassembler.publish(TopicPrefix+topicSeparator+TransferTopic+topicSeparator+deviceID, payload)
+}
@@ -0,0 +1,210 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// chunks splits the payload into size byte chunks of a single transfer
+func chunks(transferID byte, payload []byte, size int) []*Chunk {
+	var id [transferIDLength]byte
+	id[0] = transferID
+
+	total := (len(payload) + size - 1) / size
+	split := make([]*Chunk, 0, total)
+	for index := 0; index < total; index++ {
+		end := (index + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+		split = append(split, NewChunk(id, uint32(index), uint32(total), payload[index*size:end]))
+	}
+	return split
+}
+
+func TestAssemble(t *testing.T) {
+	reassembler := NewReassembler(time.Minute, nil)
+	reassembler.Chunked(ImageTopic)
+
+	frame := ImageFrame{FileName: "capture.jpg", Data: bytes.Repeat([]byte("image data "), 100)}
+	payload, err := frame.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := TopicPrefix + "/" + ImageTopic + "/cam1"
+	split := chunks(1, payload, 64)
+
+	var complete *Message
+	for index, chunk := range split {
+		complete, err = reassembler.Assemble(&Message{Topic: topic, Payload: chunk.Encode(), QoS: 1})
+		if index < len(split)-1 && !errors.Is(err, ErrTransferIncomplete) {
+			t.Fatalf("chunk %d: expected the transfer to be incomplete, got %v", index, err)
+		}
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete.Topic != topic || complete.QoS != 1 || !bytes.Equal(complete.Payload, payload) {
+		t.Fatalf("assembled %s does not match the payload that was split", complete)
+	}
+
+	// the assembled message is a plain frame, so processing it again does not need the transfer
+	again, err := reassembler.Assemble(complete)
+	if err != nil || again != complete {
+		t.Errorf("expected a complete message to be returned as it is, got %v", err)
+	}
+
+	if _, err = DecodeImageData("cam1", complete.Payload); err != nil {
+		t.Errorf("assembled payload does not decode: %v", err)
+	}
+}
+
+func TestAssemblePassesThrough(t *testing.T) {
+	reassembler := NewReassembler(time.Minute, nil)
+	reassembler.Chunked(ImageTopic)
+
+	chunk := chunks(2, []byte("data"), 2)[0].Encode()
+	messages := []*Message{
+		{Topic: TopicPrefix + "/" + ImageTopic + "/cam1", Payload: []byte("frame")},
+		{Topic: TopicPrefix + "/" + SettingsTopic + "/cam1", Payload: chunk},
+		{Topic: "other/topic", Payload: chunk},
+	}
+
+	for _, message := range messages {
+		if result, err := reassembler.Assemble(message); err != nil || result != message {
+			t.Errorf("expected %s to pass through unchanged, got %v", message, err)
+		}
+	}
+
+	corrupt := chunks(3, []byte("data"), 2)[0]
+	corrupt.Checksum++
+	if _, err := reassembler.Assemble(&Message{Topic: TopicPrefix + "/" + ImageTopic + "/cam1", Payload: corrupt.Encode()}); err == nil {
+		t.Error("expected a chunk failing its checksum to be rejected")
+	}
+}
+
+func TestRefusedChunks(t *testing.T) {
+	var replies []TransferReply
+	reassembler := NewReassembler(time.Minute, func(topic string, payload []byte) {
+		reply := TransferReply{}
+		if err := json.Unmarshal(payload, &reply); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, reply)
+	})
+	reassembler.Chunked(ImageTopic)
+	reassembler.budget = 100
+
+	corrupt := chunks(7, []byte("data"), 2)[0]
+	corrupt.Checksum++
+	mismatched := chunks(8, bytes.Repeat([]byte("c"), 64), 32)
+	mismatched[1].Total = 3
+	overBudget := chunks(9, bytes.Repeat([]byte("d"), 256), 128)
+
+	tests := []struct {
+		name    string
+		chunk   *Chunk
+		refused bool
+		missing int
+	}{
+		{name: "bad checksum", chunk: corrupt, refused: true},
+		{name: "first of a transfer", chunk: mismatched[0]},
+		{name: "total does not match", chunk: mismatched[1], refused: true, missing: 1},
+		{name: "over the budget", chunk: overBudget[0], refused: true, missing: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replies = nil
+			_, err := reassembler.Assemble(&Message{Topic: TopicPrefix + "/" + ImageTopic + "/cam1", Payload: test.chunk.Encode()})
+
+			if !test.refused {
+				if !errors.Is(err, ErrTransferIncomplete) || len(replies) != 0 {
+					t.Fatalf("expected the chunk to be taken quietly, got %v and %d replies", err, len(replies))
+				}
+				return
+			}
+
+			if err == nil || errors.Is(err, ErrTransferIncomplete) {
+				t.Fatalf("expected the chunk to be refused, got %v", err)
+			}
+			if len(replies) != 1 || replies[0].State != TransferRefused || replies[0].Reason != err.Error() {
+				t.Fatalf("expected the device to be told why, got %+v", replies)
+			}
+			if len(replies[0].Missing) != test.missing {
+				t.Errorf("expected %d chunks still missing, got %v", test.missing, replies[0].Missing)
+			}
+		})
+	}
+}
+
+func TestRepliesAfterUnlocking(t *testing.T) {
+	var reassembler *Reassembler
+	replies := make(chan string, 10)
+	reassembler = NewReassembler(time.Nanosecond, func(topic string, payload []byte) {
+		// a publisher that reaches back into the reassembler would deadlock if it were still locked
+		reassembler.Expire()
+		replies <- string(payload)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		split := chunks(4, []byte("payload"), 4)
+		if _, err := reassembler.Accept(ImageTopic, "cam1", split[1]); !errors.Is(err, ErrTransferIncomplete) {
+			done <- err
+			return
+		}
+		time.Sleep(time.Millisecond)
+		reassembler.Expire()
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replying while holding the lock deadlocked")
+	}
+
+	if len(replies) != 2 {
+		t.Errorf("expected an incomplete and an expired reply, got %d", len(replies))
+	}
+}
+
+func TestBufferedBudget(t *testing.T) {
+	reassembler := NewReassembler(time.Minute, nil)
+	reassembler.budget = 100
+
+	first := chunks(5, bytes.Repeat([]byte("a"), 128), 64)
+	second := chunks(6, bytes.Repeat([]byte("b"), 128), 64)
+
+	if _, err := reassembler.Accept(ImageTopic, "cam1", first[0]); !errors.Is(err, ErrTransferIncomplete) {
+		t.Fatal(err)
+	}
+	if _, err := reassembler.Accept(ImageTopic, "cam2", second[0]); !errors.Is(err, ErrFrameOversized) {
+		t.Fatalf("expected a chunk over the budget to be refused, got %v", err)
+	}
+
+	// completing a transfer releases what it held, so the refused chunk is taken when resent
+	if _, err := reassembler.Accept(ImageTopic, "cam1", first[1]); !errors.Is(err, ErrFrameOversized) {
+		t.Fatalf("expected the budget to cover the first transfer too, got %v", err)
+	}
+	reassembler.budget = 128
+	if payload, err := reassembler.Accept(ImageTopic, "cam1", first[1]); err != nil || len(payload) != 128 {
+		t.Fatalf("expected the first transfer to complete, got %v", err)
+	}
+	if reassembler.buffered != 0 {
+		t.Errorf("expected nothing buffered after the transfer completed, have %d bytes", reassembler.buffered)
+	}
+	if _, err := reassembler.Accept(ImageTopic, "cam2", second[0]); !errors.Is(err, ErrTransferIncomplete) {
+		t.Errorf("expected the resent chunk to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// MaxVideoSize is the largest video accepted in a single frame
+	MaxVideoSize = 256 * 1024 * 1024
+	// MaxVideoMetadataSize is the largest metadata document a frame can carry
+	MaxVideoMetadataSize = 4096
+	// MaxVideoFormatLength is the longest container or codec name accepted
+	MaxVideoFormatLength = 32
+
+	metadataSizeLength = 2
+	videoSizeLength    = 4
+)
+
+// VideoMetadata describes a video as reported by the device
+type VideoMetadata struct {
+	DurationMS int64  `json:"duration_ms"`
+	Container  string `json:"container"`
+	Codec      string `json:"codec"`
+}
+
+// VideoFrame is a video as sent by a device:
+//
+//	[file name length - 1 byte][file name]
+//	[metadata size - 16 bit little endian][metadata json]
+//	[video size - 32 bit little endian][video data]
+type VideoFrame struct {
+	FileName string
+	Metadata VideoMetadata
+	Data     []byte
+}
+
+// validate ensures the reported metadata fits what is stored for a video
+func (metadata *VideoMetadata) validate() error {
+	switch {
+	case metadata.DurationMS < 0:
+		return fmt.Errorf("negative video duration: %d", metadata.DurationMS)
+	case len(metadata.Container) > MaxVideoFormatLength, len(metadata.Codec) > MaxVideoFormatLength:
+		return fmt.Errorf("video container or codec exceeds %d characters", MaxVideoFormatLength)
+	}
+	return nil
+}
+
+// Encode writes the frame in its wire format
+func (frame *VideoFrame) Encode() ([]byte, error) {
+	if err := validateFileName(frame.FileName); err != nil {
+		return nil, err
+	}
+
+	if err := frame.Metadata.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(frame.Data) > MaxVideoSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameOversized, len(frame.Data))
+	}
+
+	metadata, err := json.Marshal(&frame.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	buffer.Grow(fileNameLengthSize + len(frame.FileName) + metadataSizeLength + len(metadata) + videoSizeLength + len(frame.Data))
+
+	buffer.WriteByte(byte(len(frame.FileName)))
+	buffer.WriteString(frame.FileName)
+
+	size := make([]byte, videoSizeLength)
+	binary.LittleEndian.PutUint16(size, uint16(len(metadata)))
+	buffer.Write(size[:metadataSizeLength])
+	buffer.Write(metadata)
+
+	binary.LittleEndian.PutUint32(size, uint32(len(frame.Data)))
+	buffer.Write(size)
+	buffer.Write(frame.Data)
+
+	return buffer.Bytes(), nil
+}
+
+// Decode parses and validates the wire format into the frame
+func (frame *VideoFrame) Decode(rawData []byte) error {
+	if len(rawData) < fileNameLengthSize {
+		return fmt.Errorf("%w: no file name length", ErrFrameTruncated)
+	}
+
+	offset := fileNameLengthSize + int(rawData[0])
+	if len(rawData) < offset+metadataSizeLength {
+		return fmt.Errorf("%w: header needs %d bytes, have %d", ErrFrameTruncated, offset+metadataSizeLength, len(rawData))
+	}
+
+	fileName := string(rawData[fileNameLengthSize:offset])
+	if err := validateFileName(fileName); err != nil {
+		return err
+	}
+
+	metadataSize := int(binary.LittleEndian.Uint16(rawData[offset:]))
+	if metadataSize > MaxVideoMetadataSize {
+		return fmt.Errorf("%w: %d bytes of metadata", ErrFrameOversized, metadataSize)
+	}
+	offset += metadataSizeLength
+
+	if len(rawData) < offset+metadataSize+videoSizeLength {
+		return fmt.Errorf("%w: header needs %d bytes, have %d", ErrFrameTruncated, offset+metadataSize+videoSizeLength, len(rawData))
+	}
+
+	metadata := VideoMetadata{}
+	if err := json.Unmarshal(rawData[offset:offset+metadataSize], &metadata); err != nil {
+		return fmt.Errorf("invalid video metadata: %v", err)
+	}
+
+	if err := metadata.validate(); err != nil {
+		return err
+	}
+	offset += metadataSize
+
+	videoSize := binary.LittleEndian.Uint32(rawData[offset:])
+	if videoSize > MaxVideoSize {
+		return fmt.Errorf("%w: %d bytes", ErrFrameOversized, videoSize)
+	}
+
+	videoData := rawData[offset+videoSizeLength:]
+	switch {
+	case uint32(len(videoData)) < videoSize:
+		return fmt.Errorf("%w: %d of %d video bytes", ErrFrameTruncated, len(videoData), videoSize)
+	case uint32(len(videoData)) > videoSize:
+		return fmt.Errorf("%w: %d bytes declared, %d received", ErrFrameLengthMismatch, videoSize, len(videoData))
+	}
+
+	frame.FileName = fileName
+	frame.Metadata = metadata
+	frame.Data = videoData
+
+	return nil
+}
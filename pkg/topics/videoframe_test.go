@@ -0,0 +1,156 @@
+// Package topics for handling all topics
+package topics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// rawVideo builds a video frame by hand so the declared sizes can disagree with what follows them
+func rawVideo(fileName string, metadata string, declaredMetadata uint16, declared uint32, data []byte) []byte {
+	rawData := append([]byte{byte(len(fileName))}, fileName...)
+	size := make([]byte, videoSizeLength)
+	binary.LittleEndian.PutUint16(size, declaredMetadata)
+	rawData = append(append(rawData, size[:metadataSizeLength]...), metadata...)
+	binary.LittleEndian.PutUint32(size, declared)
+	rawData = append(rawData, size...)
+	return append(rawData, data...)
+}
+
+// videoWith builds a consistent video frame with the metadata document
+func videoWith(metadata string) []byte {
+	return rawVideo("clip.mp4", metadata, uint16(len(metadata)), 4, []byte("mpeg"))
+}
+
+func TestVideoFrameDecode(t *testing.T) {
+	metadata := `{"duration_ms":1500,"container":"mp4","codec":"h264"}`
+	valid := videoWith(metadata)
+	header := 1 + len("clip.mp4") + metadataSizeLength
+
+	// metadata the device got wrong is rejected without a sentinel, so it is only expected to fail
+	tests := []struct {
+		name     string
+		rawData  []byte
+		err      error
+		rejected bool
+	}{
+		{name: "valid", rawData: valid},
+		{name: "empty metadata", rawData: videoWith("{}")},
+		{name: "empty video", rawData: rawVideo("clip.mp4", "{}", 2, 0, nil)},
+		{name: "empty", rawData: nil, err: ErrFrameTruncated},
+		{name: "name cut short", rawData: valid[:5], err: ErrFrameTruncated},
+		{name: "metadata size cut short", rawData: valid[:header-1], err: ErrFrameTruncated},
+		{name: "metadata cut short", rawData: valid[:header+10], err: ErrFrameTruncated},
+		{name: "video size cut short", rawData: valid[:header+len(metadata)+2], err: ErrFrameTruncated},
+		{name: "video cut short", rawData: valid[:len(valid)-1], err: ErrFrameTruncated},
+		{name: "metadata size past the end", rawData: rawVideo("clip.mp4", "{}", 4000, 0, nil), err: ErrFrameTruncated},
+		{name: "oversized metadata", rawData: rawVideo("clip.mp4", "{}", MaxVideoMetadataSize+1, 0, nil), err: ErrFrameOversized},
+		{name: "largest metadata size", rawData: rawVideo("clip.mp4", "{}", 0xffff, 0, nil), err: ErrFrameOversized},
+		{name: "oversized", rawData: rawVideo("clip.mp4", "{}", 2, MaxVideoSize+1, []byte("mpeg")), err: ErrFrameOversized},
+		{name: "largest size", rawData: rawVideo("clip.mp4", "{}", 2, 0xffffffff, nil), err: ErrFrameOversized},
+		{name: "trailing bytes", rawData: append(append([]byte{}, valid...), "extra"...), err: ErrFrameLengthMismatch},
+		{name: "declared less", rawData: rawVideo("clip.mp4", "{}", 2, 2, []byte("mpeg")), err: ErrFrameLengthMismatch},
+		{name: "path", rawData: rawVideo("../clip.mp4", "{}", 2, 0, nil), err: ErrInvalidFileName},
+		{name: "empty name", rawData: rawVideo("", "{}", 2, 0, nil), err: ErrInvalidFileName},
+		{name: "metadata not json", rawData: videoWith("not json"), rejected: true},
+		{name: "metadata of the wrong type", rawData: videoWith(`{"duration_ms":"long"}`), rejected: true},
+		{name: "negative duration", rawData: videoWith(`{"duration_ms":-1}`), rejected: true},
+		{name: "codec too long", rawData: videoWith(`{"codec":"` + strings.Repeat("c", MaxVideoFormatLength+1) + `"}`), rejected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame := VideoFrame{}
+			err := frame.Decode(test.rawData)
+			switch {
+			case test.rejected && err == nil:
+				t.Fatal("expected the metadata to be rejected")
+			case !test.rejected && test.err == nil && err != nil:
+				t.Fatalf("expected the frame to decode, got %v", err)
+			case test.err != nil && !errors.Is(err, test.err):
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err != nil && (len(frame.FileName) > 0 || frame.Data != nil || frame.Metadata != VideoMetadata{}) {
+				t.Errorf("a rejected frame was partly decoded into %+v", frame)
+			}
+		})
+	}
+}
+
+func TestVideoFrameEncode(t *testing.T) {
+	metadata := VideoMetadata{DurationMS: 1500, Container: "mp4", Codec: "h264"}
+
+	tests := []struct {
+		name  string
+		frame VideoFrame
+		err   error
+	}{
+		{name: "valid", frame: VideoFrame{FileName: "clip.mp4", Metadata: metadata, Data: []byte("mpeg")}},
+		{name: "no metadata", frame: VideoFrame{FileName: "clip.mp4", Data: []byte("mpeg")}},
+		{name: "longest name", frame: VideoFrame{FileName: strings.Repeat("n", MaxFileNameLength), Data: []byte("mpeg")}},
+		{name: "name too long", frame: VideoFrame{FileName: strings.Repeat("n", MaxFileNameLength+1)}, err: ErrInvalidFileName},
+		{name: "oversized", frame: VideoFrame{FileName: "clip.mp4", Data: make([]byte, MaxVideoSize+1)}, err: ErrFrameOversized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rawData, err := test.frame.Encode()
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded := VideoFrame{}
+			if err = decoded.Decode(rawData); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.FileName != test.frame.FileName || decoded.Metadata != test.frame.Metadata ||
+				!bytes.Equal(decoded.Data, test.frame.Data) {
+				t.Errorf("expected %+v to round trip, got %+v", test.frame.Metadata, decoded.Metadata)
+			}
+		})
+	}
+
+	invalid := VideoFrame{FileName: "clip.mp4", Metadata: VideoMetadata{DurationMS: -1}}
+	if _, err := invalid.Encode(); err == nil {
+		t.Error("expected a negative duration to be rejected")
+	}
+}
+
+// FuzzVideoFrameDecode checks that no input panics and that whatever decodes survives encoding again
+func FuzzVideoFrameDecode(f *testing.F) {
+	f.Add(videoWith(`{"duration_ms":1500,"container":"mp4","codec":"h264"}`))
+	f.Add(rawVideo("clip.mp4", "{}", 0xffff, 0, nil))
+	f.Add(rawVideo("clip.mp4", "{}", 2, MaxVideoSize+1, nil))
+	f.Add([]byte{255})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, rawData []byte) {
+		frame := VideoFrame{}
+		if err := frame.Decode(rawData); err != nil {
+			return
+		}
+
+		// the metadata may be written differently, so the frame is compared rather than the bytes
+		encoded, err := frame.Encode()
+		if err != nil {
+			t.Fatalf("decoded frame does not encode: %v", err)
+		}
+
+		again := VideoFrame{}
+		if err = again.Decode(encoded); err != nil {
+			t.Fatalf("encoded frame does not decode: %v", err)
+		}
+		if again.FileName != frame.FileName || again.Metadata != frame.Metadata || !bytes.Equal(again.Data, frame.Data) {
+			t.Fatalf("frame changed from %+v to %+v", frame.Metadata, again.Metadata)
+		}
+	})
+}
@@ -9,8 +9,15 @@ import (
 
 // cli is an internal command structure to pass into kong
 var cli struct {
+	Deadletter cmd.DeadLetterCommand `cmd:"" help:"Inspect, replay and purge messages that failed processing"`
+	Device     cmd.DeviceCommand     `cmd:"" help:"Manage and command devices"`
+	Fsck       cmd.FsckCommand       `cmd:"" help:"Reconcile stored images with the cache directory"`
 	Initialize cmd.InitializeCommand `cmd:"" help:"Initialize the system"`
+	Migrate    cmd.MigrateCommand    `cmd:"" help:"Manage database schema migrations"`
+	Record     cmd.RecordCommand     `cmd:"" help:"Record the messages on the broker to a file"`
+	Replay     cmd.ReplayCommand     `cmd:"" help:"Process a recording as if its messages came from the broker"`
 	Run        cmd.RunCommand        `cmd:"" help:"Run this application"`
+	Simulate   cmd.SimulateCommand   `cmd:"" help:"Run virtual cameras against the broker"`
 	Version    cmd.VersionCommand    `cmd:"" help:"version: Print version and exit"`
 }
 
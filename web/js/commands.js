@@ -0,0 +1,31 @@
+// SendCommand sends a command to the device and reports the acknowledgement
+function SendCommand(serial, type, args) {
+    return fetch("devices/" + encodeURIComponent(serial) + "/commands", {
+        method: "POST",
+        credentials: "same-origin",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ type: type, args: args })
+    }).then(Authorized).then(function(response) {
+        return response.json().then(function(result) {
+            if (!response.ok) {
+                throw new Error(result.error || result.message || response.statusText);
+            }
+            return result;
+        });
+    });
+}
+
+// CaptureNow asks the first device of the user to take an image right away,
+// the live view picks it up once it arrives
+function CaptureNow() {
+    fetch("devices", { credentials: "same-origin" }).then(Authorized).then(function(response) {
+        return response.json();
+    }).then(function(devices) {
+        if (devices.length == 0) {
+            throw new Error("no devices");
+        }
+        return SendCommand(devices[0].serial, "capture");
+    }).catch(function(error) {
+        console.log("capture failed: " + error.message);
+    });
+}
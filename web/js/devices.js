@@ -0,0 +1,76 @@
+class DevicesPage {
+    constructor(containerId) {
+        this.m_containerId = containerId;
+        this.m_container = document.getElementById(containerId);
+        this.m_rows = {};
+    }
+
+    display() {
+        var page = this;
+        fetch("devices", { credentials: "same-origin" }).then(Authorized).then(function(response) {
+            return response.json();
+        }).then(function(devices) {
+            page.render(devices);
+        });
+    }
+
+    render(devices) {
+        var page = this;
+        this.m_container.innerHTML = "";
+        this.m_rows = {};
+        if (devices.length == 0) {
+            this.m_container.textContent = "No devices";
+            return;
+        }
+
+        var table = document.createElement("table");
+        var header = table.insertRow();
+        ["Device", "Model", "Firmware", "Status", "Last seen", "Address"].forEach(function(title) {
+            var cell = document.createElement("th");
+            cell.textContent = title;
+            header.appendChild(cell);
+        });
+
+        devices.forEach(function(device) {
+            var row = table.insertRow();
+            [device.serial, device.model, device.firmware, "", "", device.ip || ""].forEach(function(text) {
+                row.insertCell().textContent = text;
+            });
+            page.m_rows[device.id] = row;
+            page.update(device.id, device.online, device.last_seen);
+        });
+        this.m_container.appendChild(table);
+    }
+
+    // update shows the presence of a device, as listed or as it changes
+    update(deviceId, online, lastSeen) {
+        var row = this.m_rows[deviceId];
+        if (row === undefined) {
+            return;
+        }
+
+        row.cells[3].textContent = online ? "online" : "offline";
+        if (lastSeen) {
+            row.cells[4].textContent = new Date(lastSeen).toLocaleString();
+        }
+    }
+}
+
+function ShowDevices(containerId) {
+    this.devicesPage = new DevicesPage(containerId);
+
+    this.devicesPage.display();
+    eventFeed.start();
+}
+
+eventFeed.on("device_online", function(event) {
+    if (window.devicesPage) {
+        window.devicesPage.update(event.device_id, true, event.time);
+    }
+});
+
+eventFeed.on("device_offline", function(event) {
+    if (window.devicesPage) {
+        window.devicesPage.update(event.device_id, false);
+    }
+});
@@ -0,0 +1,47 @@
+class EventFeed {
+    constructor() {
+        this.m_handlers = {};
+        this.m_source = null;
+    }
+
+    // on registers a callback for an event type such as "image", "video",
+    // "audio", "device_online", "device_offline" or "settings_changed"
+    on(type, callback) {
+        if (!(type in this.m_handlers)) {
+            this.m_handlers[type] = [];
+            if (this.m_source != null) {
+                this.listen(type);
+            }
+        }
+        this.m_handlers[type].push(callback);
+    }
+
+    listen(type) {
+        var handlers = this.m_handlers;
+        this.m_source.addEventListener(type, function(message) {
+            var event = JSON.parse(message.data);
+            handlers[type].forEach(function(callback) {
+                callback(event);
+            });
+        });
+    }
+
+    // start opens the stream, the browser reconnects by itself if it drops
+    start() {
+        if (this.m_source == null) {
+            this.m_source = new EventSource("events");
+            for (var type in this.m_handlers) {
+                this.listen(type);
+            }
+        }
+    }
+
+    stop() {
+        if (this.m_source != null) {
+            this.m_source.close();
+            this.m_source = null;
+        }
+    }
+}
+
+var eventFeed = new EventFeed();
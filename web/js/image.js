@@ -10,7 +10,13 @@ class LiveImage {
         if (image == null) {
             image = document.createElement("img");
             image.id = "liveimage";
-            image.src = "live";
+            // the stream replaces the image as each new frame arrives, fall
+            // back to the latest still if the browser cannot keep it open
+            image.onerror = function() {
+                image.onerror = null;
+                image.src = "live";
+            };
+            image.src = "stream";
             image.style.maxWidth = "100%";
             image.style.maxHeight = "100%";
             image.style.margin = "auto";
@@ -27,4 +33,4 @@ function ShowLiveImage(containerId) {
     this.liveImage = new LiveImage(containerId);
 
     this.liveImage.display();
-}
\ No newline at end of file
+}
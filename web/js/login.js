@@ -0,0 +1,85 @@
+class LoginPage {
+    constructor(containerId) {
+        this.m_containerId = containerId;
+        this.m_container = document.getElementById(containerId);
+    }
+
+    // display asks for the access token, the server keeps it in a cookie
+    // that every page and the requests they make are authenticated with
+    display() {
+        var page = this;
+        this.m_container.innerHTML = "";
+
+        var form = document.createElement("form");
+        var input = document.createElement("input");
+        input.type = "password";
+        input.name = "token";
+        input.placeholder = "Access token";
+        form.appendChild(input);
+
+        var button = document.createElement("button");
+        button.type = "submit";
+        button.textContent = "Log in";
+        form.appendChild(button);
+
+        var message = document.createElement("p");
+        form.appendChild(message);
+
+        form.onsubmit = function(event) {
+            event.preventDefault();
+            page.login(input.value, message);
+        };
+        this.m_container.appendChild(form);
+        input.focus();
+    }
+
+    login(token, message) {
+        var page = this;
+        fetch("login", {
+            method: "POST",
+            credentials: "same-origin",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ token: token })
+        }).then(function(response) {
+            if (!response.ok) {
+                message.textContent = "That token is not valid";
+                return;
+            }
+
+            // a feed refused before logging in does not reconnect by itself
+            if (eventFeed.m_source != null) {
+                eventFeed.stop();
+                eventFeed.start();
+            }
+            ShowLiveImage(page.m_containerId);
+        });
+    }
+}
+
+function ShowLogin(containerId) {
+    this.loginPage = new LoginPage(containerId);
+
+    this.loginPage.display();
+}
+
+function Logout() {
+    fetch("logout", { method: "POST", credentials: "same-origin" }).then(function() {
+        eventFeed.stop();
+        ShowLogin("main_content");
+    });
+}
+
+// Authorized passes the response on, asking to log in when the request was
+// refused for a missing or no longer valid token
+function Authorized(response) {
+    if (response.status == 401) {
+        ShowLogin("main_content");
+        throw new Error("not logged in");
+    }
+    return response;
+}
+
+// ask to log in straight away rather than on the first page that fails
+window.addEventListener("load", function() {
+    fetch("devices", { credentials: "same-origin" }).then(Authorized).catch(function() {});
+});
@@ -0,0 +1,135 @@
+class SettingsPage {
+    constructor(containerId) {
+        this.m_containerId = containerId;
+        this.m_container = document.getElementById(containerId);
+        this.m_serial = null;
+    }
+
+    display() {
+        var page = this;
+        fetch("devices", { credentials: "same-origin" }).then(Authorized).then(function(response) {
+            return response.json();
+        }).then(function(devices) {
+            page.m_container.innerHTML = "";
+            if (devices.length == 0) {
+                page.m_container.textContent = "No devices";
+                return;
+            }
+
+            var picker = document.createElement("select");
+            devices.forEach(function(device) {
+                var option = document.createElement("option");
+                option.value = device.serial;
+                option.textContent = device.serial + " " + device.model;
+                picker.appendChild(option);
+            });
+            picker.onchange = function() {
+                page.load(picker.value);
+            };
+            page.m_container.appendChild(picker);
+
+            page.m_form = document.createElement("form");
+            page.m_container.appendChild(page.m_form);
+            page.load(devices[0].serial);
+        });
+    }
+
+    load(serial) {
+        var page = this;
+        this.m_serial = serial;
+        fetch("devices/" + encodeURIComponent(serial) + "/settings", { credentials: "same-origin" }).then(Authorized).then(function(response) {
+            return response.json();
+        }).then(function(settings) {
+            page.render(settings);
+        });
+    }
+
+    // render builds an input for each setting in the schema of the model,
+    // noting the value the device reports when it has not caught up yet
+    render(settings) {
+        var page = this;
+        var form = this.m_form;
+        form.innerHTML = "";
+
+        Object.keys(settings.schema).sort().forEach(function(name) {
+            var field = settings.schema[name];
+            var value = name in settings.desired ? settings.desired[name] : (field.default || "");
+            var input;
+
+            if (field.type == "enum") {
+                input = document.createElement("select");
+                field.values.forEach(function(allowed) {
+                    var option = document.createElement("option");
+                    option.value = allowed;
+                    option.textContent = allowed;
+                    input.appendChild(option);
+                });
+            } else if (field.type == "bool") {
+                input = document.createElement("input");
+                input.type = "checkbox";
+                input.checked = value == "true";
+            } else {
+                input = document.createElement("input");
+                input.type = field.type == "int" ? "number" : "text";
+                if (field.min !== undefined) input.min = field.min;
+                if (field.max !== undefined) input.max = field.max;
+            }
+            input.name = name;
+            if (input.type != "checkbox") {
+                input.value = value;
+            }
+
+            var label = document.createElement("label");
+            label.textContent = field.description || name;
+            label.appendChild(input);
+            if (name in settings.pending && name in settings.reported) {
+                var note = document.createElement("small");
+                note.textContent = " device reports " + settings.reported[name];
+                label.appendChild(note);
+            }
+            form.appendChild(label);
+            form.appendChild(document.createElement("br"));
+        });
+
+        var save = document.createElement("button");
+        save.type = "submit";
+        save.textContent = "Save";
+        form.appendChild(save);
+        form.onsubmit = function(event) {
+            event.preventDefault();
+            page.save();
+        };
+    }
+
+    save() {
+        var page = this;
+        var values = {};
+        Array.prototype.forEach.call(this.m_form.elements, function(input) {
+            if (input.name) {
+                values[input.name] = input.type == "checkbox" ? String(input.checked) : input.value;
+            }
+        });
+
+        fetch("devices/" + encodeURIComponent(this.m_serial) + "/settings", {
+            method: "PUT",
+            credentials: "same-origin",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify(values)
+        }).then(Authorized).then(function(response) {
+            return response.json().then(function(result) {
+                if (!response.ok) {
+                    throw new Error(result.message);
+                }
+                page.render(result);
+            });
+        }).catch(function(error) {
+            alert("Settings not saved: " + error.message);
+        });
+    }
+}
+
+function ShowSettings(containerId) {
+    this.settingsPage = new SettingsPage(containerId);
+
+    this.settingsPage.display();
+}